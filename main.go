@@ -1,15 +1,22 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/ankurkotwal/metarefcard/mrc"
+	"github.com/ankurkotwal/metarefcard/mrc/common"
 )
 
 func main() {
@@ -21,23 +28,168 @@ func main() {
 // ServerRunner is a function type that runs a gin.Engine on a port
 type ServerRunner func(*gin.Engine, string) error
 
-// defaultRunner is the default server runner that starts the HTTP server
+// shutdownTimeout - time requests in flight have to finish after SIGTERM.
+// Cloud Run stops the container 10 seconds after sending it.
+const shutdownTimeout = 8 * time.Second
+
+// defaultRunner is the default server runner that starts the HTTP server. On
+// SIGTERM or an interrupt it stops taking requests, lets those in flight
+// finish and saves what the server hasn't written yet.
 func defaultRunner(router *gin.Engine, port string) error {
-	return router.Run(port)
+	server := &http.Server{Addr: port, Handler: router}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(stop)
+	served := make(chan error, 1)
+	go func() { served <- server.ListenAndServe() }()
+	select {
+	case err := <-served:
+		return err
+	case <-stop:
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	mrc.Shutdown(common.NewLog())
+	return err
+}
+
+// cliRender holds the command line options for generating cards without the server
+var cliRender struct {
+	game      string
+	outDir    string
+	include   string
+	exclude   string
+	scale     float64
+	width     int
+	format    string
+	rerender  bool
+	title     string
+	subtitle  string
+	pilot     string
+	composite bool
 }
 
 // runServer contains the main application logic and is extracted for testability.
 // The runner parameter allows injecting a mock for testing.
 func runServer(runner ServerRunner) error {
 	debugMode, gameArgs := parseCliArgs()
+	if cliRender.rerender {
+		return rerenderCards(flag.Args())
+	}
+	if len(cliRender.game) > 0 {
+		return renderCards(cliRender.game, flag.Args())
+	}
 	router, port := mrc.GetServer(debugMode, gameArgs)
 	return runner(router, port)
 }
 
+// renderCards generates reference cards for the input files and writes them
+// to the output directory
+func renderCards(game string, files []string) error {
+	if len(files) == 0 {
+		return fmt.Errorf("no input files for %s", game)
+	}
+	inputFiles := make([][]byte, 0, len(files))
+	for _, filename := range files {
+		contents, err := os.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		inputFiles = append(inputFiles, contents)
+	}
+
+	log := common.NewLog()
+	opts := &common.RequestOptions{
+		Contexts:  common.ParseContextFilter(cliRender.include, cliRender.exclude, log),
+		Scale:     cliRender.scale,
+		Width:     cliRender.width,
+		Format:    cliRender.format,
+		Title:     common.CleanHeaderText(cliRender.title),
+		Subtitle:  common.CleanHeaderText(cliRender.subtitle),
+		Pilot:     common.CleanHeaderText(cliRender.pilot),
+		Composite: cliRender.composite,
+	}
+	if err := os.MkdirAll(cliRender.outDir, 0755); err != nil {
+		return err
+	}
+	if len(opts.Format) > 0 {
+		return renderReport(game, inputFiles, opts, log)
+	}
+	generatedFiles, err := mrc.GenerateCards(game, inputFiles, opts, log)
+	if err != nil {
+		return err
+	}
+	for idx, file := range generatedFiles {
+		filename := filepath.Join(cliRender.outDir, fmt.Sprintf("%s_%02d.jpg", game, idx+1))
+		if err := os.WriteFile(filename, file.Bytes(), 0644); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %s\n", filename)
+	}
+	return nil
+}
+
+// rerenderCards renders cards again from their embedded metadata and writes
+// them to the output directory
+func rerenderCards(cards []string) error {
+	if len(cards) == 0 {
+		return fmt.Errorf("no cards to render again")
+	}
+	if err := os.MkdirAll(cliRender.outDir, 0755); err != nil {
+		return err
+	}
+	log := common.NewLog()
+	opts := &common.RequestOptions{Scale: cliRender.scale, Width: cliRender.width,
+		Title:    common.CleanHeaderText(cliRender.title),
+		Subtitle: common.CleanHeaderText(cliRender.subtitle),
+		Pilot:    common.CleanHeaderText(cliRender.pilot)}
+	for _, card := range cards {
+		contents, err := os.ReadFile(card)
+		if err != nil {
+			return err
+		}
+		generatedFiles, _, _, err := mrc.RerenderCard(contents, opts, log)
+		if err != nil {
+			return fmt.Errorf("%s - %w", card, err)
+		}
+		name := strings.TrimSuffix(filepath.Base(card), filepath.Ext(card))
+		for _, file := range generatedFiles {
+			filename := filepath.Join(cliRender.outDir, name+"_rerendered.jpg")
+			if err := os.WriteFile(filename, file.Bytes(), 0644); err != nil {
+				return err
+			}
+			fmt.Printf("Wrote %s\n", filename)
+		}
+	}
+	return nil
+}
+
+// renderReport writes a binding report for the input files to the output
+// directory
+func renderReport(game string, inputFiles [][]byte, opts *common.RequestOptions,
+	log *common.Logger) error {
+	format, found := common.ReportFormats[opts.Format]
+	if !found {
+		return fmt.Errorf("unsupported format %s", opts.Format)
+	}
+	report, err := mrc.GenerateReport(game, inputFiles, opts, log)
+	if err != nil {
+		return err
+	}
+	filename := filepath.Join(cliRender.outDir,
+		fmt.Sprintf("%s_bindings.%s", game, format.Extension))
+	if err := os.WriteFile(filename, report, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s\n", filename)
+	return nil
+}
+
 func parseCliArgs() (bool, mrc.GameToInputFiles) {
 	gameFiles := make(mrc.GameToInputFiles)
 	flag.Usage = func() {
-		fmt.Printf("Usage: %s file...\n\n", filepath.Base(os.Args[0]))
+		fmt.Printf("Usage: %s [-g game] file...\n\n", filepath.Base(os.Args[0]))
 		fmt.Printf("file\tSupported game input configration.\n")
 		flag.PrintDefaults()
 	}
@@ -45,6 +197,18 @@ func parseCliArgs() (bool, mrc.GameToInputFiles) {
 	flag.BoolVar(&debugMode, "d", false, "Enable debug mode & deploy GET handlers.")
 	var testDataDir string
 	flag.StringVar(&testDataDir, "t", "", "Directory to load test data from. Only used if debug mode is enabled.")
+	flag.StringVar(&cliRender.game, "g", "", "Generate cards for this game's (e.g. fs2020) input files instead of running the server.")
+	flag.StringVar(&cliRender.outDir, "o", ".", "Directory to write generated cards to. Only used with -g.")
+	flag.StringVar(&cliRender.include, "include", "", "Comma separated contexts to show e.g. PLANE,*CAMERA*. Only used with -g.")
+	flag.StringVar(&cliRender.exclude, "exclude", "", "Comma separated contexts to hide e.g. MENU,DRONE. Only used with -g.")
+	flag.Float64Var(&cliRender.scale, "scale", 0, "Scale the cards by this factor e.g. 0.5. Only used with -g.")
+	flag.IntVar(&cliRender.width, "width", 0, "Width in pixels of the cards, overrides -scale. Only used with -g.")
+	flag.StringVar(&cliRender.format, "format", "", "Write a bindings report (markdown, csv or text) instead of cards. Only used with -g.")
+	flag.BoolVar(&cliRender.rerender, "rerender", false, "Render the card files again from their embedded bindings, with the current layouts and themes.")
+	flag.StringVar(&cliRender.title, "title", "", "Card header title, shown before the device label e.g. A320.")
+	flag.StringVar(&cliRender.subtitle, "subtitle", "", "Card header subtitle e.g. \"Captain side\".")
+	flag.StringVar(&cliRender.pilot, "pilot", "", "Pilot or squadron name shown in the card header.")
+	flag.BoolVar(&cliRender.composite, "composite", false, "Tile all of a profile's devices onto one card. Only used with -g.")
 	flag.Parse()
 	// If in debug mode and a test data dir was provided, read files by game label dir
 	if debugMode && len(testDataDir) > 0 {
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -241,3 +246,125 @@ func TestDefaultRunner_InvalidPort(t *testing.T) {
 		t.Error("Expected error for invalid port")
 	}
 }
+
+func TestDefaultRunner_Shutdown(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/healthz", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	done := make(chan error, 1)
+	go func() { done <- defaultRunner(router, addr) }()
+
+	// Signals are handled once the server is listening
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if resp, err := http.Get("http://" + addr + "/healthz"); err == nil {
+			resp.Body.Close()
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("The server didn't start")
+		}
+	}
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected a clean shutdown, got %s", err)
+		}
+	case <-time.After(shutdownTimeout + time.Second):
+		t.Error("Expected the server to stop on SIGTERM")
+	}
+}
+
+func TestRunServer_RenderCards(t *testing.T) {
+	resetFlags()
+	outDir := t.TempDir()
+	os.Args = []string{"cmd", "-g", "fs2020", "-o", outDir, "-exclude", "*camera*",
+		"testdata/fs2020/T.16000M.xml"}
+
+	err := runServer(func(router *gin.Engine, port string) error {
+		t.Error("Server should not run in render mode")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "fs2020_01.jpg")); err != nil {
+		t.Errorf("Expected generated card, got %v", err)
+	}
+}
+
+func TestRunServer_RenderReport(t *testing.T) {
+	resetFlags()
+	outDir := t.TempDir()
+	os.Args = []string{"cmd", "-g", "fs2020", "-o", outDir, "-format", "markdown",
+		"testdata/fs2020/T.16000M.xml"}
+
+	if err := runServer(defaultRunner); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	report, err := os.ReadFile(filepath.Join(outDir, "fs2020_bindings.md"))
+	if err != nil {
+		t.Fatalf("Expected generated report, got %v", err)
+	}
+	if !strings.Contains(string(report), "| Label | Action | Primary | Secondary | Input |") {
+		t.Errorf("Unexpected report\n%s", report)
+	}
+
+	resetFlags()
+	os.Args = []string{"cmd", "-g", "fs2020", "-o", outDir, "-format", "pdf",
+		"testdata/fs2020/T.16000M.xml"}
+	if err := runServer(defaultRunner); err == nil {
+		t.Error("Expected error for unsupported format")
+	}
+}
+
+func TestRunServer_Rerender(t *testing.T) {
+	resetFlags()
+	outDir := t.TempDir()
+	os.Args = []string{"cmd", "-g", "fs2020", "-o", outDir, "testdata/fs2020/T.16000M.xml"}
+	if err := runServer(defaultRunner); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	card := filepath.Join(outDir, "fs2020_01.jpg")
+
+	resetFlags()
+	os.Args = []string{"cmd", "-rerender", "-o", outDir, card}
+	if err := runServer(defaultRunner); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	original, _ := os.ReadFile(card)
+	rerendered, err := os.ReadFile(filepath.Join(outDir, "fs2020_01_rerendered.jpg"))
+	if err != nil {
+		t.Fatalf("Expected rendered card, got %v", err)
+	}
+	// Nothing changed so the card is the same
+	if !bytes.Equal(original, rerendered) {
+		t.Error("Expected the same card")
+	}
+
+	resetFlags()
+	os.Args = []string{"cmd", "-rerender", "-o", outDir, "testdata/fs2020/T.16000M.xml"}
+	if err := runServer(defaultRunner); err == nil {
+		t.Error("Expected error for a file that isn't a card")
+	}
+	resetFlags()
+	os.Args = []string{"cmd", "-rerender"}
+	if err := runServer(defaultRunner); err == nil {
+		t.Error("Expected error without cards")
+	}
+}
+
+func TestRunServer_RenderCardsNoFiles(t *testing.T) {
+	resetFlags()
+	os.Args = []string{"cmd", "-g", "fs2020"}
+
+	if err := runServer(defaultRunner); err == nil {
+		t.Error("Expected error without input files")
+	}
+}
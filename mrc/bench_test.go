@@ -65,14 +65,14 @@ func BenchmarkGenerateImages(b *testing.B) {
 	}
 
 	// 1. Handle Request (Pre-computation)
-	gameData, gameBinds, gameDevices, gameContexts, gameLogo := handler(inputFiles, cfg, log)
+	gameData, gameBinds, gameAxes, gameDevices, gameContexts, gameLogo := handler(inputFiles, cfg, log)
 
 	// 2. Populate Overlays (Pre-computation)
-	overlaysByImage := common.PopulateImageOverlays(gameDevices, cfg, log, gameBinds, gameData, matchFunc)
+	overlaysByImage, hidden := common.PopulateImageOverlays(gameDevices, cfg, log, gameBinds, gameAxes, gameData, matchFunc, nil)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		// 3. Generate Images (Target function)
-		common.GenerateImages(overlaysByImage, gameContexts, gameLogo, cfg, log)
+		common.GenerateImages(overlaysByImage, hidden, gameContexts, gameLogo, cfg, log, nil)
 	}
 }
@@ -0,0 +1,115 @@
+package common
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+)
+
+// APIVersion - version of the JSON API's responses
+const APIVersion = 2
+
+// APIResponse is the JSON API's response with everything MetaRefCard parsed,
+// resolved and drew for a request
+type APIResponse struct {
+	APIVersion int
+	Game       string
+	Version    string // MetaRefCard version
+	// Profile -> Device -> Context -> Action -> Primary/Secondary
+	Bindings GameBindsByProfile
+	Axes     GameAxesByProfile `json:",omitempty"`
+	// Profile -> Device image -> Inputs with actions drawn on them
+	Overlays map[string]map[string][]APIOverlay
+	Cards    []APICard
+	Logs     []*LogEntry
+}
+
+// APIOverlay - an input on a device image and the actions drawn on it
+type APIOverlay struct {
+	Device string
+	Input  string
+	// Position and size in device model coordinates, like devices.yaml
+	X, Y, W, H int
+	Contexts   map[string][]OverlayAction // Context -> Actions
+	// Hat widgets only. Direction -> Context -> Texts
+	Directions map[string]map[string][]string `json:",omitempty"`
+}
+
+// APICard - a generated card. Chip rects are in card pixels.
+type APICard struct {
+	Profile string
+	Image   string // Device image name. Empty for composite cards
+	Label   string // Device label. Empty for composite cards
+	Width   int
+	Height  int
+	URL     string // Card store URL of the card or a data URL without a store
+	Chips   []CardChip
+}
+
+// NewAPIResponse returns the response for the generated cards, storing them in
+// the card store if there is one. Overlays are sorted by device and input.
+func NewAPIResponse(game string, version string, gameBinds GameBindsByProfile,
+	gameAxes GameAxesByProfile, overlaysByProfile OverlaysByProfile,
+	generatedFiles []bytes.Buffer, cardChips []CardChips,
+	labelsByImage map[string]string, store CardStore, log *Logger) APIResponse {
+	response := APIResponse{
+		APIVersion: APIVersion,
+		Game:       game,
+		Version:    version,
+		Bindings:   gameBinds,
+		Axes:       gameAxes,
+		Overlays:   make(map[string]map[string][]APIOverlay),
+		Cards:      make([]APICard, 0, len(generatedFiles)),
+	}
+	for profile, overlaysByImage := range overlaysByProfile {
+		images := make(map[string][]APIOverlay)
+		response.Overlays[profile] = images
+		for imageName, overlays := range overlaysByImage {
+			images[imageName] = apiOverlays(overlays)
+		}
+	}
+	for idx, file := range generatedFiles {
+		if file.Len() == 0 {
+			continue
+		}
+		card := APICard{URL: CardURL(store, file.Bytes(), log)}
+		if idx < len(cardChips) {
+			chips := cardChips[idx]
+			card.Profile = chips.Profile
+			card.Image = chips.Image
+			card.Label = labelsByImage[chips.Image]
+			card.Width = chips.Width
+			card.Height = chips.Height
+			card.Chips = chips.Chips
+		}
+		response.Cards = append(response.Cards, card)
+	}
+	// Last so it has any errors storing the cards
+	response.Logs = log.Entries
+	return response
+}
+
+// apiOverlays returns an image's overlays sorted by device and input
+func apiOverlays(overlays map[string]OverlayData) []APIOverlay {
+	keys := make([]string, 0, len(overlays))
+	for deviceAndInput := range overlays {
+		keys = append(keys, deviceAndInput)
+	}
+	sort.Strings(keys)
+	apiOverlays := make([]APIOverlay, 0, len(keys))
+	for _, deviceAndInput := range keys {
+		overlay := overlays[deviceAndInput]
+		device, input, _ := strings.Cut(deviceAndInput, ":")
+		apiOverlays = append(apiOverlays, APIOverlay{
+			Device:     device,
+			Input:      input,
+			X:          overlay.PosAndSize.X,
+			Y:          overlay.PosAndSize.Y,
+			W:          overlay.PosAndSize.W,
+			H:          overlay.PosAndSize.H,
+			Contexts:   overlay.ContextToActions,
+			Directions: overlay.Directions,
+		})
+	}
+	return apiOverlays
+}
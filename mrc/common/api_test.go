@@ -0,0 +1,67 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"strings"
+	"testing"
+)
+
+func TestNewAPIResponse(t *testing.T) {
+	log, _ := mockLogger()
+	log.Err("unknown input")
+	binds := GameBindsByProfile{"Default": GameDeviceContextActions{
+		"stick": GameContextActions{"PLANE": GameActions{"GEAR": {"Button 1", ""}}}}}
+	axes := make(GameAxesByProfile)
+	axes.Set("Default", "stick", "PLANE", "AILERONS", AxisSettings{Inverted: true})
+	gear := OverlayAction{Text: "Gear", Action: "GEAR", Primary: "Button 1"}
+	overlays := OverlaysByProfile{"Default": OverlaysByImage{"hotas": {
+		"throttle:2": {PosAndSize: InputData{X: 50, Y: 60, W: 70, H: 80}},
+		"stick:1": {PosAndSize: InputData{X: 10, Y: 20, W: 30, H: 40},
+			ContextToActions: map[string][]OverlayAction{"PLANE": {gear}}},
+	}}}
+	files := []bytes.Buffer{*bytes.NewBufferString("jpg"), {}}
+	cardChips := []CardChips{
+		{Profile: "Default", Image: "hotas", Width: 100, Height: 50,
+			Chips: []CardChip{{Rect: image.Rect(1, 2, 3, 4), Context: "PLANE",
+				Actions: []OverlayAction{gear}}}},
+		{Profile: "Default", Image: "pedals"},
+	}
+
+	response := NewAPIResponse("game", "1.0", binds, axes, overlays, files,
+		cardChips, map[string]string{"hotas": "Warthog"}, nil, log)
+	if response.APIVersion != APIVersion || response.Game != "game" ||
+		response.Version != "1.0" || response.Bindings["Default"] == nil ||
+		response.Axes.Lookup("Default", "stick", "PLANE", "AILERONS") == nil {
+		t.Errorf("Unexpected response %+v", response)
+	}
+	// Sorted by device and input
+	hotas := response.Overlays["Default"]["hotas"]
+	if len(hotas) != 2 || hotas[0].Device != "stick" || hotas[0].Input != "1" ||
+		hotas[0].X != 10 || hotas[0].H != 40 || hotas[0].Contexts["PLANE"][0] != gear ||
+		hotas[1].Device != "throttle" {
+		t.Errorf("Unexpected overlays %+v", hotas)
+	}
+	// Empty files aren't cards
+	if len(response.Cards) != 1 {
+		t.Fatalf("Expected 1 card, got %+v", response.Cards)
+	}
+	card := response.Cards[0]
+	if card.Label != "Warthog" || card.Width != 100 || len(card.Chips) != 1 ||
+		card.URL != "data:image/jpeg;base64,anBn" {
+		t.Errorf("Unexpected card %+v", card)
+	}
+	if len(response.Logs) != 1 || !response.Logs[0].IsError {
+		t.Errorf("Unexpected logs %+v", response.Logs)
+	}
+
+	encoded, err := json.Marshal(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Optional fields are left out
+	if strings.Contains(string(encoded), "Directions") {
+		t.Errorf("Expected no hat directions in %s", encoded)
+	}
+}
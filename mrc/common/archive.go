@@ -0,0 +1,121 @@
+package common
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Archive file names besides the cards
+const (
+	ArchiveBindingsCSV  = "bindings.csv"
+	ArchiveBindingsJSON = "bindings.json"
+	ArchiveLog          = "log.txt"
+)
+
+// archiveNameReplacer replaces characters that aren't allowed in file names
+var archiveNameReplacer = strings.NewReplacer("/", "_", "\\", "_", ":", "_",
+	"*", "_", "?", "_", "\"", "_", "<", "_", ">", "_", "|", "_")
+
+// WriteCardArchive writes a ZIP archive of the cards, named
+// <profile>/<device label>.jpg, with the binding rows as CSV and JSON and the
+// log as text. Cards are stored as is, they're already compressed.
+func WriteCardArchive(w io.Writer, generatedFiles []bytes.Buffer,
+	cardChips []CardChips, labelsByImage map[string]string, rows []BindingRow,
+	log *Logger) error {
+	archive := zip.NewWriter(w)
+	modified := time.Now()
+	used := make(Set)
+	for idx, file := range generatedFiles {
+		if file.Len() == 0 {
+			continue
+		}
+		var profile, imageName string
+		if idx < len(cardChips) {
+			profile, imageName = cardChips[idx].Profile, cardChips[idx].Image
+		}
+		name := archiveCardName(profile, labelsByImage[imageName], used)
+		entry, err := archive.CreateHeader(&zip.FileHeader{Name: name,
+			Method: zip.Store, Modified: modified})
+		if err != nil {
+			return err
+		}
+		if _, err := entry.Write(file.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	entry, err := archive.CreateHeader(&zip.FileHeader{Name: ArchiveBindingsCSV,
+		Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return err
+	}
+	if err := WriteBindingReport(entry, ReportCSV, rows); err != nil {
+		return err
+	}
+	entry, err = archive.CreateHeader(&zip.FileHeader{Name: ArchiveBindingsJSON,
+		Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	if rows == nil {
+		rows = []BindingRow{}
+	}
+	if err := encoder.Encode(rows); err != nil {
+		return err
+	}
+
+	// Log last so it has everything logged while writing the archive
+	entry, err = archive.CreateHeader(&zip.FileHeader{Name: ArchiveLog,
+		Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return err
+	}
+	log.mu.Lock()
+	entries := log.Entries
+	log.mu.Unlock()
+	for _, logEntry := range entries {
+		line := logEntry.Msg
+		if logEntry.IsError {
+			line = "Error: " + line
+		}
+		if _, err := fmt.Fprintln(entry, line); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// archiveCardName returns a unique file name for the card in its profile's
+// directory. Composite cards and cards without a device label are "Cards".
+func archiveCardName(profile string, label string, used Set) string {
+	if len(profile) == 0 || profile == ProfileDefault {
+		profile = "Default"
+	}
+	if len(label) == 0 {
+		label = "Cards"
+	}
+	base := fmt.Sprintf("%s/%s", archiveFileName(profile), archiveFileName(label))
+	name := base + ".jpg"
+	for count := 2; used[name]; count++ {
+		name = fmt.Sprintf("%s %d.jpg", base, count)
+	}
+	used[name] = true
+	return name
+}
+
+// archiveFileName returns the text with characters not allowed in file names
+// replaced
+func archiveFileName(text string) string {
+	name := strings.Trim(archiveNameReplacer.Replace(text), " .")
+	if len(name) == 0 {
+		return "_"
+	}
+	return name
+}
@@ -0,0 +1,87 @@
+package common
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestWriteCardArchive(t *testing.T) {
+	log, _ := mockLogger()
+	log.Msg("Loaded")
+	log.Err("unknown input")
+	files := []bytes.Buffer{*bytes.NewBufferString("card1"), {},
+		*bytes.NewBufferString("card2"), *bytes.NewBufferString("card3"),
+		*bytes.NewBufferString("card4")}
+	cardChips := []CardChips{
+		{Profile: ProfileDefault, Image: "hotas"},
+		{Profile: ProfileDefault, Image: "pedals"}, // Empty file, no card
+		{Profile: "A320/Captain", Image: "hotas"},
+		{Profile: "A320/Captain", Image: "twin"}, // Same label
+		{Profile: "A320/Captain"},                // Composite
+	}
+	labels := map[string]string{"hotas": "Warthog", "twin": "Warthog",
+		"pedals": "Pedals"}
+	rows := []BindingRow{{Profile: ProfileDefault, Device: "stick",
+		Context: "PLANE", Action: "GEAR", Label: "Gear", Primary: "Button 1",
+		Input: "1"}}
+
+	var buffer bytes.Buffer
+	if err := WriteCardArchive(&buffer, files, cardChips, labels, rows, log); err != nil {
+		t.Fatal(err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := make(map[string]string)
+	var names []string
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(reader)
+		reader.Close()
+		contents[file.Name] = string(data)
+		names = append(names, file.Name)
+	}
+	expected := []string{"Default/Warthog.jpg", "A320_Captain/Warthog.jpg",
+		"A320_Captain/Warthog 2.jpg", "A320_Captain/Cards.jpg", ArchiveBindingsCSV,
+		ArchiveBindingsJSON, ArchiveLog}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected %v, got %v", expected, names)
+	}
+	if contents["Default/Warthog.jpg"] != "card1" ||
+		contents["A320_Captain/Cards.jpg"] != "card4" {
+		t.Errorf("Unexpected cards %v", contents)
+	}
+	if !strings.Contains(contents[ArchiveBindingsCSV], "GEAR,Gear,Button 1,,1") {
+		t.Errorf("Unexpected CSV %s", contents[ArchiveBindingsCSV])
+	}
+	var jsonRows []BindingRow
+	if err := json.Unmarshal([]byte(contents[ArchiveBindingsJSON]), &jsonRows); err != nil ||
+		len(jsonRows) != 1 || jsonRows[0] != rows[0] {
+		t.Errorf("Unexpected JSON %s - %v", contents[ArchiveBindingsJSON], err)
+	}
+	if contents[ArchiveLog] != "Loaded\nError: unknown input\n" {
+		t.Errorf("Unexpected log %q", contents[ArchiveLog])
+	}
+}
+
+func TestArchiveFileName(t *testing.T) {
+	for text, expected := range map[string]string{
+		"Warthog":           "Warthog",
+		"A320/Captain":      "A320_Captain",
+		`C:\cards\*?"<>|`:   "C__cards_______",
+		" ..":               "_",
+		"Stick & Throttle.": "Stick & Throttle",
+	} {
+		if name := archiveFileName(text); name != expected {
+			t.Errorf("%s expected %s, got %s", text, expected, name)
+		}
+	}
+}
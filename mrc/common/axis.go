@@ -0,0 +1,115 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Axis directions for bindings to half an axis
+const (
+	AxisPositive = "+"
+	AxisNegative = "-"
+)
+
+// Axis chip markers. The arrows come from a fallback font.
+const (
+	axisMarkerFull     = "↑↓"
+	axisMarkerPositive = "↑"
+	axisMarkerNegative = "↓"
+	axisMarkerInverted = "inv"
+)
+
+// AxisSettings - how an axis binding responds. The zero value is a full axis
+// with the game's default curve.
+type AxisSettings struct {
+	Direction   string `json:",omitempty"` // AxisPositive/AxisNegative for half the axis
+	Inverted    bool   `json:",omitempty"`
+	Sensitivity string `json:",omitempty"` // As the game shows it. Empty for the default
+	Deadzone    string `json:",omitempty"` // Empty for no deadzone
+}
+
+// GameAxesByProfile - Profile -> Short name -> Context -> Action -> AxisSettings
+type GameAxesByProfile map[string]GameDeviceContextAxes
+
+// GameDeviceContextAxes - Short name -> Context -> Action -> AxisSettings
+type GameDeviceContextAxes map[string]GameContextAxes
+
+// GameContextAxes - Context -> Action -> AxisSettings
+type GameContextAxes map[string]GameAxes
+
+// GameAxes - Action -> AxisSettings. Only actions bound to an axis are included.
+type GameAxes map[string]AxisSettings
+
+// Lookup returns the axis settings for the action or nil if the action isn't
+// bound to an axis
+func (a GameAxesByProfile) Lookup(profile string, shortName string,
+	context string, action string) *AxisSettings {
+	settings, found := a[profile][shortName][context][action]
+	if !found {
+		return nil
+	}
+	return &settings
+}
+
+// Set stores the axis settings for the action
+func (a GameAxesByProfile) Set(profile string, shortName string, context string,
+	action string, settings AxisSettings) {
+	deviceAxes, found := a[profile]
+	if !found {
+		deviceAxes = make(GameDeviceContextAxes)
+		a[profile] = deviceAxes
+	}
+	contextAxes, found := deviceAxes[shortName]
+	if !found {
+		contextAxes = make(GameContextAxes)
+		deviceAxes[shortName] = contextAxes
+	}
+	axes, found := contextAxes[context]
+	if !found {
+		axes = make(GameAxes)
+		contextAxes[context] = axes
+	}
+	axes[action] = settings
+}
+
+// Marker returns the text added to an axis chip e.g. "↑↓ inv s-50 dz2"
+func (s *AxisSettings) Marker() string {
+	markers := []string{axisMarkerFull}
+	switch s.Direction {
+	case AxisPositive:
+		markers[0] = axisMarkerPositive
+	case AxisNegative:
+		markers[0] = axisMarkerNegative
+	}
+	if s.Inverted {
+		markers = append(markers, axisMarkerInverted)
+	}
+	if len(s.Sensitivity) > 0 {
+		markers = append(markers, "s"+s.Sensitivity)
+	}
+	if len(s.Deadzone) > 0 {
+		markers = append(markers, "dz"+s.Deadzone)
+	}
+	return strings.Join(markers, " ")
+}
+
+// Description returns the settings in words e.g. "full axis, inverted"
+func (s *AxisSettings) Description() string {
+	description := []string{"full axis"}
+	switch s.Direction {
+	case AxisPositive:
+		description[0] = "positive half"
+	case AxisNegative:
+		description[0] = "negative half"
+	}
+	if s.Inverted {
+		description = append(description, "inverted")
+	}
+	if len(s.Sensitivity) > 0 {
+		description = append(description, fmt.Sprintf("sensitivity %s", s.Sensitivity))
+	}
+	if len(s.Deadzone) > 0 {
+		description = append(description, fmt.Sprintf("deadzone %s", s.Deadzone))
+	}
+	return strings.Join(description, ", ")
+}
@@ -0,0 +1,47 @@
+package common
+
+import "testing"
+
+func TestAxisSettings_Marker(t *testing.T) {
+	for _, test := range []struct {
+		settings    AxisSettings
+		marker      string
+		description string
+	}{
+		{AxisSettings{}, "↑↓", "full axis"},
+		{AxisSettings{Direction: AxisPositive}, "↑", "positive half"},
+		{AxisSettings{Direction: AxisNegative, Inverted: true}, "↓ inv",
+			"negative half, inverted"},
+		{AxisSettings{Inverted: true, Sensitivity: "-50", Deadzone: "2"},
+			"↑↓ inv s-50 dz2", "full axis, inverted, sensitivity -50, deadzone 2"},
+		{AxisSettings{Deadzone: "0.1"}, "↑↓ dz0.1", "full axis, deadzone 0.1"},
+	} {
+		if marker := test.settings.Marker(); marker != test.marker {
+			t.Errorf("Expected marker %s, got %s", test.marker, marker)
+		}
+		if description := test.settings.Description(); description != test.description {
+			t.Errorf("Expected description %s, got %s", test.description, description)
+		}
+	}
+}
+
+func TestGameAxesByProfile(t *testing.T) {
+	axes := make(GameAxesByProfile)
+	axes.Set("Default", "d1", "PLANE", "ELEVATOR", AxisSettings{Inverted: true})
+	axes.Set("Default", "d1", "PLANE", "AILERONS", AxisSettings{})
+	if settings := axes.Lookup("Default", "d1", "PLANE", "ELEVATOR"); settings == nil ||
+		!settings.Inverted {
+		t.Errorf("Expected inverted elevator, got %v", settings)
+	}
+	if settings := axes.Lookup("Default", "d1", "PLANE", "AILERONS"); settings == nil ||
+		*settings != (AxisSettings{}) {
+		t.Errorf("Expected default ailerons, got %v", settings)
+	}
+	if settings := axes.Lookup("Default", "d1", "PLANE", "GEAR"); settings != nil {
+		t.Errorf("Expected no settings for a button, got %v", settings)
+	}
+	var none GameAxesByProfile
+	if settings := none.Lookup("Default", "d1", "PLANE", "ELEVATOR"); settings != nil {
+		t.Errorf("Expected no settings without axes, got %v", settings)
+	}
+}
@@ -0,0 +1,303 @@
+package common
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Card store backends
+const (
+	CardStoreMemory     = "Memory"
+	CardStoreFilesystem = "Filesystem"
+)
+
+// CardStorePath is where stored cards are served from
+const CardStorePath = "/cards/"
+
+// cardStorePruneInterval - how often the filesystem store removes expired cards
+const cardStorePruneInterval = time.Minute
+
+// cardNameRegex matches the names CardName returns. Anything else isn't looked
+// up so names can't escape the store's directory.
+var cardNameRegex = regexp.MustCompile(`^[0-9a-f]{32}\.jpg$`)
+
+// cardContentTypes - Card extension -> Content type
+var cardContentTypes = map[string]string{"jpg": "image/jpeg"}
+
+// CardStore keeps generated cards so pages link to them instead of inlining
+// them. Cards are named by their content so the same card is stored once.
+type CardStore interface {
+	// Put stores the card, restarting its TTL if it's already stored
+	Put(name string, data []byte) error
+	// Get returns the card if it's stored and hasn't expired
+	Get(name string) ([]byte, bool)
+	// TTL returns how long cards are kept after they're stored. 0 for no expiry
+	TTL() time.Duration
+}
+
+// NewCardStore returns the configured card store or nil if cards are sent
+// inline i.e. no backend or a memory store without any memory
+func NewCardStore(data CardStoreData) (CardStore, error) {
+	ttl := time.Duration(data.TTLMinutes) * time.Minute
+	switch data.Backend {
+	case "":
+		return nil, nil
+	case CardStoreMemory:
+		if data.MaxMB == 0 {
+			return nil, nil
+		}
+		return NewMemoryCardStore(int64(data.MaxMB)<<20, ttl), nil
+	case CardStoreFilesystem:
+		store, err := NewFileCardStore(data.Dir, ttl)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	}
+	return nil, fmt.Errorf("unknown card store backend %s", data.Backend)
+}
+
+// CardName returns the content hash name of a card with the extension
+func CardName(data []byte, ext string) string {
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%s.%s", hex.EncodeToString(sum[:16]), ext)
+}
+
+// CardContentType returns the content type of a stored card
+func CardContentType(name string) string {
+	return cardContentTypes[strings.TrimPrefix(filepath.Ext(name), ".")]
+}
+
+// CardURL stores the JPEG card and returns its URL. Without a store, or if
+// storing fails, the card is returned as a data URL.
+func CardURL(store CardStore, data []byte, log *Logger) string {
+	if store != nil {
+		name := CardName(data, "jpg")
+		err := store.Put(name, data)
+		if err == nil {
+			return CardStorePath + name
+		}
+		log.ErrAs(ErrorStorage, "Error storing card %s - %s", name, err)
+	}
+	return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+// MemoryCardStore is a bounded store of cards in memory. Cards are kept until
+// they expire, so pages can link to them for the whole TTL, and cards that
+// don't fit are sent inline. Without a TTL the least recently used cards are
+// evicted instead. Cards are only in the memory of the instance that stored
+// them so this store needs a single server instance.
+type MemoryCardStore struct {
+	lock      sync.Mutex
+	maxBytes  int64
+	ttl       time.Duration
+	usedBytes int64
+	entries   map[string]*list.Element
+	order     *list.List // Most recently used at the front
+	now       func() time.Time
+}
+
+type memoryCardEntry struct {
+	name   string
+	stored time.Time
+	data   []byte
+}
+
+// NewMemoryCardStore returns a store holding at most maxBytes of cards for ttl
+func NewMemoryCardStore(maxBytes int64, ttl time.Duration) *MemoryCardStore {
+	return &MemoryCardStore{
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		now:      time.Now,
+	}
+}
+
+// Put stores the card, removing expired cards to fit it. Without a TTL, least
+// recently used cards are evicted instead.
+func (s *MemoryCardStore) Put(name string, data []byte) error {
+	if !cardNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid card name %s", name)
+	}
+	size := int64(len(data))
+	if size > s.maxBytes {
+		return fmt.Errorf("card %s is larger than the store", name)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if element, found := s.entries[name]; found {
+		element.Value.(*memoryCardEntry).stored = s.now()
+		s.order.MoveToFront(element)
+		return nil
+	}
+	if s.usedBytes+size > s.maxBytes && s.ttl > 0 {
+		now := s.now()
+		for element := s.order.Back(); element != nil; {
+			previous := element.Prev()
+			if now.Sub(element.Value.(*memoryCardEntry).stored) > s.ttl {
+				s.remove(element)
+			}
+			element = previous
+		}
+		if s.usedBytes+size > s.maxBytes {
+			return fmt.Errorf("card store is full, card %s not stored", name)
+		}
+	}
+	for s.usedBytes+size > s.maxBytes && s.order.Len() > 0 {
+		s.remove(s.order.Back())
+	}
+	s.entries[name] = s.order.PushFront(
+		&memoryCardEntry{name: name, stored: s.now(), data: data})
+	s.usedBytes += size
+	return nil
+}
+
+// Get returns the card if it's stored and hasn't expired
+func (s *MemoryCardStore) Get(name string) ([]byte, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	element, found := s.entries[name]
+	if !found {
+		return nil, false
+	}
+	entry := element.Value.(*memoryCardEntry)
+	if s.ttl > 0 && s.now().Sub(entry.stored) > s.ttl {
+		s.remove(element)
+		return nil, false
+	}
+	s.order.MoveToFront(element)
+	return entry.data, true
+}
+
+// TTL returns how long cards are kept after they're stored
+func (s *MemoryCardStore) TTL() time.Duration {
+	return s.ttl
+}
+
+func (s *MemoryCardStore) remove(element *list.Element) {
+	entry := s.order.Remove(element).(*memoryCardEntry)
+	delete(s.entries, entry.name)
+	s.usedBytes -= int64(len(entry.data))
+}
+
+// FileCardStore stores cards as files in a directory. A card's modification
+// time is when it was stored.
+type FileCardStore struct {
+	dir       string
+	ttl       time.Duration
+	lock      sync.Mutex
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+// NewFileCardStore returns a store keeping cards in dir for ttl, creating the
+// directory if needed
+func NewFileCardStore(dir string, ttl time.Duration) (*FileCardStore, error) {
+	if len(dir) == 0 {
+		return nil, fmt.Errorf("card store directory not set")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileCardStore{dir: dir, ttl: ttl, now: time.Now}, nil
+}
+
+// Put writes the card, or touches it if it's already stored. Expired cards
+// are removed every so often.
+func (s *FileCardStore) Put(name string, data []byte) error {
+	if !cardNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid card name %s", name)
+	}
+	s.prune()
+	path := filepath.Join(s.dir, name)
+	now := s.now()
+	if _, err := os.Stat(path); err == nil {
+		return os.Chtimes(path, now, now)
+	}
+	// Write to a temporary file so readers never see part of a card
+	file, err := os.CreateTemp(s.dir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chtimes(file.Name(), now, now)
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+// Get returns the card if it's stored and hasn't expired
+func (s *FileCardStore) Get(name string) ([]byte, bool) {
+	if !cardNameRegex.MatchString(name) {
+		return nil, false
+	}
+	path := filepath.Join(s.dir, name)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, false
+	}
+	if s.expired(info) {
+		os.Remove(path)
+		return nil, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// TTL returns how long cards are kept after they're stored
+func (s *FileCardStore) TTL() time.Duration {
+	return s.ttl
+}
+
+func (s *FileCardStore) expired(info os.FileInfo) bool {
+	return s.ttl > 0 && s.now().Sub(info.ModTime()) > s.ttl
+}
+
+// prune removes expired cards if it hasn't done so recently
+func (s *FileCardStore) prune() {
+	if s.ttl == 0 {
+		return
+	}
+	s.lock.Lock()
+	if s.now().Sub(s.lastPrune) < cardStorePruneInterval {
+		s.lock.Unlock()
+		return
+	}
+	s.lastPrune = s.now()
+	s.lock.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !cardNameRegex.MatchString(entry.Name()) {
+			continue
+		}
+		if info, err := entry.Info(); err == nil && s.expired(info) {
+			os.Remove(filepath.Join(s.dir, entry.Name()))
+		}
+	}
+}
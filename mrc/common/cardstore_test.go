@@ -0,0 +1,154 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCardName(t *testing.T) {
+	name := CardName([]byte("card"), "jpg")
+	if !cardNameRegex.MatchString(name) || name != CardName([]byte("card"), "jpg") ||
+		name == CardName([]byte("other"), "jpg") {
+		t.Errorf("Unexpected card name %s", name)
+	}
+	if contentType := CardContentType(name); contentType != "image/jpeg" {
+		t.Errorf("Expected image/jpeg, got %s", contentType)
+	}
+}
+
+func TestNewCardStore(t *testing.T) {
+	for _, test := range []struct {
+		data  CardStoreData
+		store bool
+		err   bool
+	}{
+		{CardStoreData{}, false, false},
+		{CardStoreData{Backend: CardStoreMemory}, false, false},
+		{CardStoreData{Backend: CardStoreMemory, MaxMB: 1}, true, false},
+		{CardStoreData{Backend: CardStoreFilesystem, Dir: t.TempDir()}, true, false},
+		{CardStoreData{Backend: CardStoreFilesystem}, false, true},
+		{CardStoreData{Backend: "Cloud"}, false, true},
+	} {
+		store, err := NewCardStore(test.data)
+		if (store != nil) != test.store || (err != nil) != test.err {
+			t.Errorf("%+v expected store %v error %v, got %v %v", test.data,
+				test.store, test.err, store, err)
+		}
+	}
+}
+
+func TestCardURL(t *testing.T) {
+	log, _ := mockLogger()
+	if url := CardURL(nil, []byte("card"), log); url != "data:image/jpeg;base64,Y2FyZA==" {
+		t.Errorf("Expected a data URL without a store, got %s", url)
+	}
+	store := NewMemoryCardStore(1<<20, time.Hour)
+	url := CardURL(store, []byte("card"), log)
+	name := strings.TrimPrefix(url, CardStorePath)
+	if data, found := store.Get(name); !found || string(data) != "card" {
+		t.Errorf("Expected the card stored at %s", url)
+	}
+	// Falls back to a data URL if the card doesn't fit
+	if url := CardURL(NewMemoryCardStore(1, time.Hour), []byte("card"), log); !strings.HasPrefix(url, "data:") ||
+		len(log.Entries) != 1 || !log.Entries[0].IsError {
+		t.Errorf("Expected a data URL and an error, got %s %+v", url, log.Entries)
+	}
+}
+
+func TestMemoryCardStore(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryCardStore(8, time.Hour)
+	store.now = func() time.Time { return now }
+	a, b, c := CardName([]byte("a"), "jpg"), CardName([]byte("b"), "jpg"),
+		CardName([]byte("c"), "jpg")
+
+	if err := store.Put("../a.jpg", []byte("a")); err == nil {
+		t.Error("Expected an invalid name error")
+	}
+	if err := store.Put(a, []byte("123456789")); err == nil {
+		t.Error("Expected a too large error")
+	}
+	store.Put(a, []byte("aaaa"))
+	store.Put(b, []byte("bbbb"))
+	// Cards aren't evicted before they expire
+	if err := store.Put(c, []byte("cccc")); err == nil {
+		t.Error("Expected a full store error")
+	}
+	if _, found := store.Get(b); !found {
+		t.Error("Expected b kept until it expires")
+	}
+
+	// Storing a card again restarts its TTL
+	now = now.Add(50 * time.Minute)
+	store.Put(a, []byte("aaaa"))
+	now = now.Add(20 * time.Minute)
+	// Expired cards make room
+	if err := store.Put(c, []byte("cccc")); err != nil {
+		t.Errorf("Expected c stored in place of b, got %v", err)
+	}
+	if _, found := store.Get(b); found {
+		t.Error("Expected b expired")
+	}
+	if data, found := store.Get(a); !found || string(data) != "aaaa" ||
+		store.usedBytes != 8 {
+		t.Errorf("Expected a and c stored, got %s and %d bytes", data,
+			store.usedBytes)
+	}
+}
+
+func TestMemoryCardStore_NoTTL(t *testing.T) {
+	store := NewMemoryCardStore(8, 0)
+	a, b, c := CardName([]byte("a"), "jpg"), CardName([]byte("b"), "jpg"),
+		CardName([]byte("c"), "jpg")
+	store.Put(a, []byte("aaaa"))
+	store.Put(b, []byte("bbbb"))
+	store.Get(a) // b is now least recently used
+	if err := store.Put(c, []byte("cccc")); err != nil {
+		t.Errorf("Expected c stored, got %v", err)
+	}
+	if _, found := store.Get(b); found {
+		t.Error("Expected b evicted")
+	}
+	if data, found := store.Get(a); !found || string(data) != "aaaa" {
+		t.Errorf("Expected a, got %s", data)
+	}
+}
+
+func TestFileCardStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cards")
+	store, err := NewFileCardStore(dir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	a, b := CardName([]byte("a"), "jpg"), CardName([]byte("b"), "jpg")
+
+	if err := store.Put("../a.jpg", []byte("a")); err == nil {
+		t.Error("Expected an invalid name error")
+	}
+	if _, found := store.Get("../../etc/passwd"); found {
+		t.Error("Expected names outside the store to be ignored")
+	}
+	if err := store.Put(a, []byte("aaaa")); err != nil {
+		t.Fatal(err)
+	}
+	if data, found := store.Get(a); !found || string(data) != "aaaa" {
+		t.Errorf("Expected a, got %s", data)
+	}
+
+	// Expired cards are removed when read and when pruned
+	store.Put(b, []byte("bbbb"))
+	now = now.Add(2 * time.Hour)
+	if _, found := store.Get(a); found {
+		t.Error("Expected a expired")
+	}
+	store.Put(a, []byte("aaaa"))
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() != a {
+		t.Errorf("Expected only a after pruning, got %v", entries)
+	}
+}
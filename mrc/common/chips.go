@@ -0,0 +1,48 @@
+package common
+
+import (
+	"fmt"
+	"image"
+)
+
+// CardChips are the action chips drawn on a generated card, used to make the
+// card interactive
+type CardChips struct {
+	Profile string
+	Image   string // Device image name
+	Width   int    // Card size in pixels
+	Height  int
+	Chips   []CardChip
+}
+
+// CardChip is a drawn action chip. Rect is in card pixels.
+type CardChip struct {
+	Rect    image.Rectangle
+	Context string
+	Actions []OverlayAction // Actions drawn with this chip's text
+}
+
+// newCardChip returns the chip for text drawn in rect, with the game actions
+// that have that text
+func newCardChip(rect image.Rectangle, context string, text string,
+	contextToActions map[string][]OverlayAction) CardChip {
+	chip := CardChip{Rect: rect, Context: context}
+	for _, action := range contextToActions[context] {
+		if action.Text == text {
+			chip.Actions = append(chip.Actions, action)
+		}
+	}
+	return chip
+}
+
+// chipText returns the text drawn for a chip. Axis actions have their axis
+// marker drawn after the text.
+func chipText(context string, text string,
+	contextToActions map[string][]OverlayAction) string {
+	for _, action := range contextToActions[context] {
+		if action.Text == text && action.Axis != nil {
+			return fmt.Sprintf("%s %s", text, action.Axis.Marker())
+		}
+	}
+	return text
+}
@@ -0,0 +1,32 @@
+package common
+
+import (
+	"image"
+	"testing"
+)
+
+func TestNewCardChip(t *testing.T) {
+	rect := image.Rect(1, 2, 30, 12)
+	contextToActions := map[string][]OverlayAction{
+		"PLANE": {
+			{Text: "Gear", Action: "GEAR_UP"},
+			{Text: "Flaps", Action: "FLAPS"},
+			{Text: "Gear", Action: "GEAR_DOWN"},
+		},
+		"MENU": {{Text: "Gear", Action: "MENU_GEAR"}},
+	}
+
+	chip := newCardChip(rect, "PLANE", "Gear", contextToActions)
+	if chip.Rect != rect || chip.Context != "PLANE" {
+		t.Errorf("Unexpected chip %+v", chip)
+	}
+	// All actions drawn with the same text in the context
+	if len(chip.Actions) != 2 || chip.Actions[0].Action != "GEAR_UP" ||
+		chip.Actions[1].Action != "GEAR_DOWN" {
+		t.Errorf("Unexpected actions %+v", chip.Actions)
+	}
+
+	if chip := newCardChip(rect, "PLANE", "Gear", nil); len(chip.Actions) != 0 {
+		t.Errorf("Expected no actions, got %+v", chip.Actions)
+	}
+}
@@ -0,0 +1,231 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/fogleman/gg"
+	xdraw "golang.org/x/image/draw"
+)
+
+// generateComposites returns a card per profile with all of the profile's
+// device images tiled onto it under a shared header and legend
+func generateComposites(profiles []string, imageNamesByProfile map[string][]string,
+	overlaysByProfile OverlaysByProfile, hidden HiddenContextsByProfile,
+	categories map[string]string, logo *image.RGBA, scale float64,
+	config *Config, log *Logger, opts *RequestOptions, gameLabel string) ([]bytes.Buffer, []bytes.Buffer,
+	[]CardChips, int) {
+
+	files := make([]bytes.Buffer, len(profiles))
+	thumbnails := make([]bytes.Buffer, len(profiles))
+	cardChips := make([]CardChips, len(profiles))
+	var totalBytes, rendered int64
+
+	var wg sync.WaitGroup
+	for idx, profile := range profiles {
+		wg.Add(1)
+		go func(idx int, profile string) {
+			defer wg.Done()
+			renderPool.Acquire()
+			defer renderPool.Release()
+			// font.Face is not thread safe so each composite has its own cache
+			fontCache := NewFontFaceCache(config.FallbackFonts...)
+			dc, chips := composeProfile(profile, imageNamesByProfile[profile],
+				overlaysByProfile[profile], hidden.Contexts(profile,
+					imageNamesByProfile[profile]...), categories, logo, scale,
+				config, log, opts, fontCache)
+			if dc == nil {
+				return
+			}
+			chips.Profile = profile
+			files[idx], thumbnails[idx] = encodeCard(dc.Image(), config, log)
+			cardChips[idx] = chips
+			atomic.AddInt64(&totalBytes, int64(files[idx].Len()))
+			if files[idx].Len() > 0 {
+				atomic.AddInt64(&rendered, 1)
+			}
+		}(idx, profile)
+	}
+	wg.Wait()
+	recordImages(gameLabel, rendered, totalBytes)
+	return files, thumbnails, cardChips, int(totalBytes)
+}
+
+// compositeLayout is where a profile's device images are tiled on its card
+type compositeLayout struct {
+	scale        float64 // Scale of the card, reduced to fit MaxCompositeMP
+	cols, rows   int
+	cell         image.Point // Largest tile size
+	headerHeight int
+}
+
+// size returns the size of the whole card
+func (l compositeLayout) size() image.Point {
+	return image.Pt(l.cols*l.cell.X, l.headerHeight+l.rows*l.cell.Y)
+}
+
+// layoutComposite lays out the images from their sizes in the device config,
+// so the card can be allocated before any image is loaded. The scale is
+// reduced until the card fits in Render.MaxCompositeMP.
+func layoutComposite(imageNames []string, config *Config,
+	scale float64) compositeLayout {
+	maxPixels := float64(config.Render.MaxCompositeMP) * 1e6
+	aspect := float64(config.DefaultImage.W) / float64(config.DefaultImage.H)
+	for {
+		layout := compositeLayout{scale: scale,
+			headerHeight: int(math.Round(config.ImageHeader.BackgroundHeight *
+				config.PixelMultiplier * scale))}
+		for _, imageName := range imageNames {
+			tile := compositeTileSize(imageName, config, scale)
+			layout.cell.X = max(layout.cell.X, tile.X)
+			layout.cell.Y = max(layout.cell.Y, tile.Y)
+		}
+		layout.cols, layout.rows = compositeGrid(len(imageNames), layout.cell,
+			layout.headerHeight, aspect)
+		size := layout.size()
+		pixels := float64(size.X) * float64(size.Y)
+		if maxPixels <= 0 || pixels <= maxPixels {
+			return layout
+		}
+		// Sizes are rounded so aim a little under
+		scale *= math.Sqrt(maxPixels/pixels) * 0.99
+	}
+}
+
+// compositeTileSize returns the size of an image scaled by scale without the
+// device's header, which is replaced by the shared header
+func compositeTileSize(imageName string, config *Config, scale float64) image.Point {
+	dimensions, found := config.Devices.ImageSizeOverride[imageName]
+	if !found {
+		dimensions = config.DefaultImage
+	}
+	// Matches scaleImage for an image of the configured size
+	width := math.Max(1, math.Round(float64(dimensions.W)*config.PixelMultiplier*scale))
+	height := math.Max(1, math.Round(float64(dimensions.H)*config.PixelMultiplier*scale))
+	top := math.Round(config.ImageHeader.BackgroundHeight *
+		getPixelMultiplier(imageName, config, scale))
+	return image.Pt(int(width), int(math.Max(0, height-top)))
+}
+
+// compositeNotes returns the notes config for the tiles. The grid is laid out
+// before the tiles are drawn, so a Page notes panel, which makes a tile
+// taller, goes in a corner away from the legend instead.
+func compositeNotes(config *Config) NotesData {
+	notes := config.Notes
+	if notes.Location == NotesPage {
+		notes.Location = LegendBottomRight
+		if config.Legend.Location == LegendBottomRight {
+			notes.Location = LegendBottomLeft
+		}
+	}
+	return notes
+}
+
+// composeProfile draws the profile's device images, in image name order, and
+// tiles them onto one card. Each image is drawn onto the card as soon as it's
+// rendered so only one is held at a time. Returns nil if none of the images
+// could be loaded.
+func composeProfile(profile string, imageNames []string,
+	overlaysByImage OverlaysByImage, hidden []string, categories map[string]string,
+	logo *image.RGBA, scale float64, config *Config, log *Logger,
+	opts *RequestOptions, fontCache FontLoader) (*gg.Context, CardChips) {
+
+	layout := layoutComposite(imageNames, config, scale)
+	tileConfig := *config
+	tileConfig.Notes = compositeNotes(config)
+	dc := gg.NewContext(layout.size().X, layout.size().Y)
+	dc.SetHexColor(config.BackgroundColour)
+	dc.Clear()
+	sheet := dc.Image().(*image.RGBA)
+
+	var chips []CardChip
+	var labels []string
+	contexts := make(Set)
+	for idx, imageName := range imageNames {
+		pixelMultiplier := getPixelMultiplier(imageName, config, layout.scale)
+		imageFilename := fmt.Sprintf("%s/%s.jpg", config.HotasImagesDir, imageName)
+		img, err := imageCache.Load(imageFilename, log)
+		if err != nil || img == nil {
+			log.ErrAs(ErrorImage, "loadImage %s failed. %v", imageName, err)
+			continue
+		}
+		img = scaleImage(img, layout.scale)
+		overlays := overlaysByImage[imageName]
+		tileDc, tileChips := drawOverlays(gg.NewContextForRGBA(img), imageFilename,
+			img.Bounds().Size(), pixelMultiplier, overlays, categories, &tileConfig,
+			log, fontCache)
+
+		// The device's header is replaced by the shared header
+		top := int(math.Round(config.ImageHeader.BackgroundHeight * pixelMultiplier))
+		card := tileDc.Image().(*image.RGBA)
+		tile := card.SubImage(image.Rect(0, top, card.Bounds().Dx(),
+			card.Bounds().Dy())).(*image.RGBA)
+		// Center the tile in its cell. An image that isn't the size in the
+		// device config is cut to fit.
+		cellMin := image.Pt((idx%layout.cols)*layout.cell.X,
+			layout.headerHeight+(idx/layout.cols)*layout.cell.Y)
+		size := tile.Bounds().Size()
+		offset := cellMin.Add(image.Pt((layout.cell.X-size.X)/2,
+			(layout.cell.Y-size.Y)/2))
+		dst := image.Rectangle{Min: offset, Max: offset.Add(size)}.Intersect(
+			image.Rectangle{Min: cellMin, Max: cellMin.Add(layout.cell)})
+		xdraw.Draw(sheet, dst, tile, tile.Bounds().Min.Add(dst.Min.Sub(offset)),
+			xdraw.Src)
+		shift := offset.Sub(tile.Bounds().Min)
+		for _, chip := range tileChips.Chips {
+			chip.Rect = chip.Rect.Add(shift)
+			chips = append(chips, chip)
+		}
+		labels = append(labels, config.Devices.DeviceLabelsByImage[imageName])
+		for _, context := range imageContexts(overlays) {
+			contexts[context] = true
+		}
+	}
+	if len(labels) == 0 {
+		return nil, CardChips{}
+	}
+
+	if layout.scale != scale {
+		logo = scaleImage(logo, layout.scale/scale)
+	}
+	pixelMultiplier := config.PixelMultiplier * layout.scale
+
+	dc.DrawImage(logo, 0, 0)
+	xOffset := float64(logo.Bounds().Max.X)
+	addImageHeader(dc, &config.ImageHeader,
+		opts.headerText(strings.Join(labels, ", "), profile), xOffset,
+		pixelMultiplier, config.FontsDir, config.InputMinFontSize, fontCache)
+	addMRCLogo(dc, &config.Watermark, config.Version, config.Domain, xOffset,
+		float64(config.InputPixelXInset), pixelMultiplier, config.FontsDir,
+		fontCache)
+	sortedContexts := contexts.Keys()
+	sort.Strings(sortedContexts)
+	addLegend(dc, &config.Legend, sortedContexts,
+		hidden, categories, float64(layout.headerHeight), pixelMultiplier, config.FontsDir,
+		config.InputMinFontSize, fontCache)
+
+	return dc, CardChips{Width: dc.Width(), Height: dc.Height(), Chips: chips}
+}
+
+// compositeGrid returns the columns and rows to tile count cells so the card,
+// including its header, is closest to the aspect ratio
+func compositeGrid(count int, cell image.Point, headerHeight int,
+	aspect float64) (int, int) {
+	bestCols, bestRows := 1, count
+	bestDiff := math.Inf(1)
+	for cols := 1; cols <= count; cols++ {
+		rows := (count + cols - 1) / cols
+		cardAspect := float64(cols*cell.X) / float64(headerHeight+rows*cell.Y)
+		// Compare ratios so too wide and too tall are treated the same
+		if diff := math.Abs(math.Log(cardAspect / aspect)); diff < bestDiff {
+			bestCols, bestRows, bestDiff = cols, rows, diff
+		}
+	}
+	return bestCols, bestRows
+}
@@ -0,0 +1,187 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompositeGrid(t *testing.T) {
+	cell := image.Pt(1920, 980) // 16:9 card without its header
+	for _, test := range []struct {
+		count      int
+		cols, rows int
+	}{
+		{1, 1, 1},
+		{2, 1, 2},
+		{3, 2, 2},
+		{4, 2, 2},
+		{5, 2, 3},
+		{7, 3, 3},
+	} {
+		cols, rows := compositeGrid(test.count, cell, 100, 16.0/9.0)
+		if cols != test.cols || rows != test.rows {
+			t.Errorf("Expected %dx%d for %d cards, got %dx%d", test.cols,
+				test.rows, test.count, cols, rows)
+		}
+	}
+	// Tall devices sit side by side
+	if cols, rows := compositeGrid(2, image.Pt(500, 1000), 100, 16.0/9.0); cols != 2 || rows != 1 {
+		t.Errorf("Expected tall devices side by side, got %dx%d", cols, rows)
+	}
+}
+
+func TestGenerateImages_Composite(t *testing.T) {
+	tmpDir := t.TempDir()
+	logoDir := filepath.Join(tmpDir, "logo")
+	hotasDir := filepath.Join(tmpDir, "hotas")
+	os.Mkdir(logoDir, 0755)
+	os.Mkdir(hotasDir, 0755)
+	createDummyJpg(t, filepath.Join(logoDir, "game.jpg"))
+	for _, name := range []string{"pedals", "stick", "throttle"} {
+		createDummyJpg(t, filepath.Join(hotasDir, name+".jpg"))
+	}
+	config := &Config{
+		LogoImagesDir:    logoDir,
+		HotasImagesDir:   hotasDir,
+		FontsDir:         "../../resources/fonts",
+		InputFont:        "Dirga.ttf",
+		InputFontSize:    12,
+		InputMinFontSize: 5,
+		ImageHeader:      HeaderData{Font: "Dirga.ttf", FontSize: 14, BackgroundHeight: 20},
+		Watermark:        WatermarkData{Font: "Dirga.ttf", FontSize: 10},
+		DefaultImage:     Dimensions2d{W: 100, H: 100},
+		PixelMultiplier:  1.0,
+		LightColour:      "#000000",
+		BackgroundColour: "#FFFFFF",
+		Devices: Devices{
+			DeviceLabelsByImage: map[string]string{"pedals": "Pedals",
+				"stick": "Stick", "throttle": "Throttle"},
+			ImageSizeOverride: make(map[string]Dimensions2d),
+		},
+	}
+	overlay := func(x int) map[string]OverlayData {
+		return map[string]OverlayData{"k": {
+			PosAndSize:     InputData{X: x, Y: 30, W: 40, H: 20},
+			ContextToTexts: map[string][]string{"c": {"T"}},
+		}}
+	}
+	overlays := OverlaysByProfile{
+		"Default": OverlaysByImage{"pedals": overlay(10), "stick": overlay(20),
+			"throttle": overlay(30)},
+		"Other": OverlaysByImage{"stick": overlay(20)},
+	}
+	log, _ := mockLogger()
+
+	files, _, cardChips, size := GenerateImages(overlays, nil,
+		map[string]string{"c": "#FF0000"}, "game", config, log,
+		&RequestOptions{Composite: true})
+	if len(files) != 2 || size == 0 {
+		t.Fatalf("Expected a card per profile, got %d", len(files))
+	}
+	card, err := jpeg.Decode(bytes.NewReader(files[0].Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Three 100x80 tiles in a 2x2 grid below the shared 20px header
+	if card.Bounds().Dx() != 200 || card.Bounds().Dy() != 180 {
+		t.Errorf("Unexpected composite size %v", card.Bounds())
+	}
+	chips := cardChips[0]
+	if chips.Profile != "Default" || chips.Image != "" || chips.Width != 200 ||
+		chips.Height != 180 || len(chips.Chips) != 3 {
+		t.Fatalf("Unexpected chips %+v", chips)
+	}
+	// Chips move with their tile. The header crop and shared header cancel out.
+	for idx, offset := range []image.Point{{10, 30}, {120, 30}, {30, 110}} {
+		rect := chips.Chips[idx].Rect
+		if rect.Min.X < offset.X || rect.Min.X > offset.X+10 ||
+			rect.Min.Y < offset.Y || rect.Min.Y > offset.Y+20 {
+			t.Errorf("Chip %d at %v, expected near %v", idx, rect, offset)
+		}
+	}
+	// A single device fills the card
+	if cardChips[1].Width != 100 || cardChips[1].Height != 100 {
+		t.Errorf("Unexpected single device composite %+v", cardChips[1])
+	}
+}
+
+func TestGenerateImages_CompositeMaxSize(t *testing.T) {
+	tmpDir := t.TempDir()
+	logoDir := filepath.Join(tmpDir, "logo")
+	hotasDir := filepath.Join(tmpDir, "hotas")
+	os.Mkdir(logoDir, 0755)
+	os.Mkdir(hotasDir, 0755)
+	createDummyJpg(t, filepath.Join(logoDir, "game.jpg"))
+	overlaysByImage := make(OverlaysByImage)
+	for idx := range 12 {
+		name := fmt.Sprintf("device%02d", idx)
+		createDummyJpg(t, filepath.Join(hotasDir, name+".jpg"))
+		overlaysByImage[name] = map[string]OverlayData{"k": {
+			PosAndSize:     InputData{X: 10, Y: 30, W: 40, H: 20},
+			ContextToTexts: map[string][]string{"c": {"T"}},
+		}}
+	}
+	config := &Config{
+		LogoImagesDir:    logoDir,
+		HotasImagesDir:   hotasDir,
+		FontsDir:         "../../resources/fonts",
+		InputFont:        "Dirga.ttf",
+		InputFontSize:    12,
+		InputMinFontSize: 5,
+		ImageHeader:      HeaderData{Font: "Dirga.ttf", FontSize: 14, BackgroundHeight: 20},
+		Watermark:        WatermarkData{Font: "Dirga.ttf", FontSize: 10},
+		DefaultImage:     Dimensions2d{W: 100, H: 100},
+		PixelMultiplier:  1.0,
+		MaxOutputScale:   8,
+		Render:           RenderLimits{MaxCompositeMP: 1},
+		LightColour:      "#000000",
+		BackgroundColour: "#FFFFFF",
+		Devices:          Devices{ImageSizeOverride: make(map[string]Dimensions2d)},
+	}
+	log, _ := mockLogger()
+
+	// Twelve 800x800 tiles are almost 8 megapixels
+	files, _, cardChips, _ := GenerateImages(OverlaysByProfile{"Default": overlaysByImage}, nil,
+		map[string]string{"c": "#FF0000"}, "game", config, log,
+		&RequestOptions{Composite: true, Scale: 8})
+	if len(files) != 1 {
+		t.Fatalf("Expected one composite, got %d", len(files))
+	}
+	card, err := jpeg.Decode(bytes.NewReader(files[0].Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := card.Bounds().Size()
+	if size.X*size.Y > 1e6 || size.X*size.Y < 0.9e6 {
+		t.Errorf("Expected the composite scaled down to 1 megapixel, got %v", size)
+	}
+	chips := cardChips[0]
+	if len(chips.Chips) != 12 {
+		t.Fatalf("Expected a chip per device, got %d", len(chips.Chips))
+	}
+	for _, chip := range chips.Chips {
+		if !chip.Rect.In(card.Bounds()) {
+			t.Errorf("Chip %v outside the composite %v", chip.Rect, card.Bounds())
+		}
+	}
+
+	// The layout comes from the device config at full size too
+	config.DefaultImage = Dimensions2d{W: 3840, H: 2160}
+	config.PixelMultiplier = 0.5
+	config.ImageHeader.BackgroundHeight = 200
+	config.Render.MaxCompositeMP = 32
+	imageNames := make([]string, 12)
+	for idx := range imageNames {
+		imageNames[idx] = fmt.Sprintf("device%02d", idx)
+	}
+	layout := layoutComposite(imageNames, config, 2)
+	if size := layout.size(); size.X*size.Y > 32e6 || layout.scale >= 2 {
+		t.Errorf("Expected a 4K composite scaled down to 32 megapixels, got %v at %v",
+			size, layout.scale)
+	}
+}
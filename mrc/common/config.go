@@ -1,6 +1,10 @@
 package common
 
-import "golang.org/x/text/cases"
+import (
+	"fmt"
+
+	"golang.org/x/text/cases"
+)
 
 // Config contains all the configuration data for the app
 type Config struct {
@@ -15,20 +19,33 @@ type Config struct {
 
 	DefaultImage    Dimensions2d `yaml:"DefaultImage"`
 	PixelMultiplier float64      `yaml:"PixelMultiplier"`
+	MaxOutputScale  float64      `yaml:"MaxOutputScale"` // Largest requested scale. Up to 1 if unset
+	ThumbnailWidth  int          `yaml:"ThumbnailWidth"` // Web page thumbnails. 0 disables
 	HotasImagesDir  string       `yaml:"HotasImagesDir"`
 	LogoImagesDir   string       `yaml:"LogoImagesDir"`
 	JpgQuality      int          `yaml:"JpgQuality"`
+	ImageCacheMaxMB int          `yaml:"ImageCacheMaxMB"` // Decoded images kept in memory. 0 disables
 
-	FontsDir          string  `yaml:"FontsDir"`
-	InputFont         string  `yaml:"InputFont"`
-	InputFontSize     float64 `yaml:"InputFontSize"`
-	InputMinFontSize  int     `yaml:"InputMinFontSize"`
-	DefaultLineHeight int     `yaml:"DefaultLineHeight"`
-	InputPixelXInset  float64 `yaml:"InputPixelXInset"`
-	InputPixelYInset  float64 `yaml:"InputPixelYInset"`
+	FontsDir          string   `yaml:"FontsDir"`
+	InputFont         string   `yaml:"InputFont"`
+	FallbackFonts     []string `yaml:"FallbackFonts"` // Tried in order for missing characters
+	InputFontSize     float64  `yaml:"InputFontSize"`
+	InputMinFontSize  int      `yaml:"InputMinFontSize"`
+	DefaultLineHeight int      `yaml:"DefaultLineHeight"`
+	InputPixelXInset  float64  `yaml:"InputPixelXInset"`
+	InputPixelYInset  float64  `yaml:"InputPixelYInset"`
 
-	ImageHeader HeaderData    `yaml:"ImageHeader"`
-	Watermark   WatermarkData `yaml:"Watermark"`
+	ImageHeader HeaderData     `yaml:"ImageHeader"`
+	Watermark   WatermarkData  `yaml:"Watermark"`
+	Legend      LegendData     `yaml:"Legend"`
+	Notes       NotesData      `yaml:"Notes"`
+	LeaderLine  LeaderLineData `yaml:"LeaderLine"`
+	CardStore   CardStoreData  `yaml:"CardStore"`
+	Share       ShareData      `yaml:"Share"`
+	Uploads     UploadLimits   `yaml:"Uploads"`
+	Render      RenderLimits   `yaml:"Render"`
+	RateLimit   RateLimitData  `yaml:"RateLimit"`
+	Usage       UsageData      `yaml:"Usage"`
 
 	BackgroundColour string   `yaml:"BackgroundColour"`
 	LightColour      string   `yaml:"LightColour"`
@@ -38,6 +55,15 @@ type Config struct {
 	LangTitleCase cases.Caser
 }
 
+// CheckLayout returns an error if parts of the card are configured to be
+// drawn over each other i.e. the notes panel in the legend's corner
+func (c *Config) CheckLayout() error {
+	if len(c.Notes.Location) > 0 && c.Notes.Location == c.Legend.Location {
+		return fmt.Errorf("notes and legend can't both be at %s", c.Notes.Location)
+	}
+	return nil
+}
+
 // HeaderData contains necessary data to generate header
 type HeaderData struct {
 	Font             string  `yaml:"Font"`
@@ -57,6 +83,109 @@ type WatermarkData struct {
 	Font             string  `yaml:"Font"`
 	FontSize         float64 `yaml:"FontSize"`
 	Location         Point2d `yaml:"Location"`
+	// Format of the text with {text}, {version} and {domain} placeholders.
+	// Empty for "{text} v{version} ({domain})"
+	Format string `yaml:"Format"`
+	Hide   bool   `yaml:"Hide"` // Don't draw the watermark e.g. when self hosting
+}
+
+// LegendData contains necessary data to generate the context colour legend
+type LegendData struct {
+	// Location is one of TopLeft, TopRight, BottomLeft, BottomRight or Footer.
+	// An empty location disables the legend.
+	Location         string  `yaml:"Location"`
+	Font             string  `yaml:"Font"`
+	FontSize         float64 `yaml:"FontSize"`
+	Inset            Point2d `yaml:"Inset"`
+	MaxWidth         float64 `yaml:"MaxWidth"` // Only used by corner locations
+	TextColour       string  `yaml:"TextColour"`
+	BackgroundColour string  `yaml:"BackgroundColour"`
+}
+
+// NotesData contains necessary data to generate the overflow notes panel.
+// Inputs whose text would be smaller than InputMinFontSize show a numbered
+// marker instead and their full action list is printed in the panel.
+type NotesData struct {
+	// Location is one of TopLeft, TopRight, BottomLeft, BottomRight or Page.
+	// Page appends the panel below the image. It can't be the legend's location.
+	// An empty location disables notes and overflowing text is drawn at
+	// InputMinFontSize.
+	Location         string  `yaml:"Location"`
+	Font             string  `yaml:"Font"`
+	FontSize         float64 `yaml:"FontSize"`
+	Inset            Point2d `yaml:"Inset"`
+	MaxWidth         float64 `yaml:"MaxWidth"` // Only used by corner locations
+	MarkerColour     string  `yaml:"MarkerColour"`
+	TextColour       string  `yaml:"TextColour"` // Marker number colour
+	BackgroundColour string  `yaml:"BackgroundColour"`
+}
+
+// LeaderLineData contains necessary data to draw a line from an input's box
+// to its anchor on the physical control. Empty colour disables leader lines.
+type LeaderLineData struct {
+	Colour    string  `yaml:"Colour"`
+	Width     float64 `yaml:"Width"`
+	DotRadius float64 `yaml:"DotRadius"` // Dot drawn on the anchor. 0 for none
+}
+
+// CardStoreData configures where generated cards are kept for pages to link
+// to. An empty backend sends cards inline as data URLs.
+type CardStoreData struct {
+	Backend    string `yaml:"Backend"`    // Memory or Filesystem
+	MaxMB      int    `yaml:"MaxMB"`      // Memory only. 0 sends cards inline
+	Dir        string `yaml:"Dir"`        // Filesystem only
+	TTLMinutes int    `yaml:"TTLMinutes"` // How long cards are served for. 0 for no expiry
+}
+
+// ShareData configures saving bindings for shareable /card/<id> links. An
+// empty backend disables sharing.
+type ShareData struct {
+	Backend string `yaml:"Backend"` // Filesystem or SQLite (needs the sqlite build tag)
+	Path    string `yaml:"Path"`    // Directory for Filesystem, database file for SQLite
+	TTLDays int    `yaml:"TTLDays"` // How long shared cards last. 0 for no expiry
+}
+
+// UploadLimits caps the input files a request can upload. Limits are checked
+// before the files are parsed, except profiles and devices which are checked
+// before rendering. 0 disables a limit.
+type UploadLimits struct {
+	MaxFiles     int `yaml:"MaxFiles"`
+	MaxFileKB    int `yaml:"MaxFileKB"`
+	MaxRequestKB int `yaml:"MaxRequestKB"` // Whole request body, files and options
+	MaxCardKB    int `yaml:"MaxCardKB"`    // Each card posted to be rendered again
+	MaxProfiles  int `yaml:"MaxProfiles"`
+	MaxDevices   int `yaml:"MaxDevices"`
+}
+
+// RenderLimits bounds the rendering work done at once. Requests over the
+// limit are turned away with a 503 rather than queueing without bound. 0
+// disables a limit.
+type RenderLimits struct {
+	Workers     int `yaml:"Workers"`     // Images rendered at once across requests
+	MaxRequests int `yaml:"MaxRequests"` // Requests rendering or waiting to render
+	// Largest composite card in megapixels. Composites are scaled down to fit
+	MaxCompositeMP int `yaml:"MaxCompositeMP"`
+}
+
+// RateLimitData configures a per client token bucket on /api/ requests.
+// Clients over the rate get a 429. 0 requests per minute disables it.
+// Clients are told apart by the connection's address unless a platform header
+// or trusted proxies' X-Forwarded-For is configured. Those headers are only
+// believed from a front end that sets them, anyone else can spoof them.
+type RateLimitData struct {
+	RequestsPerMinute int      `yaml:"RequestsPerMinute"`
+	Burst             int      `yaml:"Burst"`           // Requests allowed at once
+	TrustedPlatform   string   `yaml:"TrustedPlatform"` // Header with the client IP e.g. X-Appengine-Remote-Addr
+	TrustedProxies    []string `yaml:"TrustedProxies"`  // CIDRs whose X-Forwarded-For is believed
+}
+
+// UsageData configures anonymous daily usage statistics of games, devices and
+// profiles. An empty directory disables them.
+type UsageData struct {
+	Dir           string `yaml:"Dir"`
+	RetentionDays int    `yaml:"RetentionDays"` // 0 keeps them forever
+	// Token for the admin page at /admin/usage. Empty disables the page
+	AdminToken string `yaml:"AdminToken"`
 }
 
 // Point2d contains x and y
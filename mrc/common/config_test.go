@@ -0,0 +1,24 @@
+package common
+
+import "testing"
+
+func TestConfig_CheckLayout(t *testing.T) {
+	for _, test := range []struct {
+		legend, notes string
+		valid         bool
+	}{
+		{LegendBottomLeft, LegendBottomRight, true},
+		{LegendBottomLeft, "", true},
+		{"", "", true},
+		{LegendFooter, NotesPage, true},
+		{LegendBottomRight, LegendBottomRight, false},
+		{LegendTopLeft, LegendTopLeft, false},
+	} {
+		config := Config{Legend: LegendData{Location: test.legend},
+			Notes: NotesData{Location: test.notes}}
+		if err := config.CheckLayout(); (err == nil) != test.valid {
+			t.Errorf("Legend %q and notes %q expected valid %v, got %v",
+				test.legend, test.notes, test.valid, err)
+		}
+	}
+}
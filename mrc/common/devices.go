@@ -6,7 +6,26 @@ func LoadDevicesInfo(file string, devices *Devices, log *Logger) {
 
 	var generatedDevices GeneratedDevices
 	LoadYaml(devices.GeneratedFile, &generatedDevices, "Generated Devices", log)
+	mergeGeneratedDevices(devices, &generatedDevices)
+}
+
+// LoadDevicesFile loads all the device information like LoadDevicesInfo,
+// returning any errors rather than exiting
+func LoadDevicesFile(file string, devices *Devices) error {
+	if err := LoadYamlFile(file, devices); err != nil {
+		return err
+	}
+	var generatedDevices GeneratedDevices
+	if err := LoadYamlFile(devices.GeneratedFile, &generatedDevices); err != nil {
+		return err
+	}
+	mergeGeneratedDevices(devices, &generatedDevices)
+	return nil
+}
 
+// mergeGeneratedDevices adds the device additions to the generated devices
+// and uses the result
+func mergeGeneratedDevices(devices *Devices, generatedDevices *GeneratedDevices) {
 	// Add device additions to the main device index
 	if generatedDevices.Index == nil {
 		generatedDevices.Index = make(DeviceMap)
@@ -59,10 +78,12 @@ type DeviceInputs map[string]InputData
 
 // InputData - data relating to a given input
 type InputData struct {
-	X int `yaml:"x"` // X location
-	Y int `yaml:"y"` // Y location
-	W int `yaml:"w"` // Width
-	H int `yaml:"h"` // Height
+	X      int      `yaml:"x"`                // X location
+	Y      int      `yaml:"y"`                // Y location
+	W      int      `yaml:"w"`                // Width
+	H      int      `yaml:"h"`                // Height
+	Anchor *Point2d `yaml:"anchor,omitempty"` // Optional location of the physical control
+	Type   string   `yaml:"type,omitempty"`   // Optional widget e.g. InputTypeHat
 }
 
 // ImageMap - contains device short name -> image name
@@ -151,3 +151,20 @@ func TestLoadDevicesInfo_Errors(t *testing.T) {
 		}
 	})
 }
+
+func TestInputData_Anchor(t *testing.T) {
+	var inputs DeviceInputs
+	err := yaml.Unmarshal([]byte(`
+plain: { x: 1, y: 2, w: 3, h: 4 }
+anchored: { x: 1, y: 2, w: 3, h: 4, anchor: { x: 50, y: 60 } }
+`), &inputs)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if inputs["plain"].Anchor != nil {
+		t.Error("Expected no anchor")
+	}
+	if anchor := inputs["anchored"].Anchor; anchor == nil || anchor.X != 50 || anchor.Y != 60 {
+		t.Errorf("Unexpected anchor %v", anchor)
+	}
+}
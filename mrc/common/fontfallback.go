@@ -0,0 +1,116 @@
+package common
+
+import (
+	"image"
+	"sync"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// fallbackFace is a font.Face that uses the first of its fonts containing a
+// glyph for each character. Metrics come from the first (primary) font so
+// text lines up the same as without fallbacks. Fallback faces are only
+// created when needed as each face holds its own glyph cache.
+type fallbackFace struct {
+	lock  sync.Mutex  // Guards creating faces
+	faces []font.Face // Primary face then fallback faces, nil until used
+	fonts []*truetype.Font
+	size  int
+}
+
+// missingFallbackFonts - fallback fonts that couldn't be loaded, logged once
+var missingFallbackFonts sync.Map
+
+// loadFontWithFallbacks loads the font and wraps it with any fallback fonts.
+// A missing primary font panics like loadFont, missing fallbacks are logged
+// and skipped.
+func loadFontWithFallbacks(dir string, name string, fallbackFonts []string,
+	size int) font.Face {
+	primary := loadFont(dir, name, size)
+	if len(fallbackFonts) == 0 {
+		return primary
+	}
+	primaryFont, _ := loadTrueType(dir, name)
+	face := &fallbackFace{
+		faces: []font.Face{primary},
+		fonts: []*truetype.Font{primaryFont},
+		size:  size,
+	}
+	for _, fallbackName := range fallbackFonts {
+		if fallbackName == name {
+			continue
+		}
+		fallbackFont, err := loadTrueType(dir, fallbackName)
+		if err != nil {
+			if _, logged := missingFallbackFonts.LoadOrStore(fallbackName, true); !logged {
+				NewLog().Err("Fallback font %s couldn't be loaded, skipping it - %s",
+					fallbackName, err)
+			}
+			continue
+		}
+		face.fonts = append(face.fonts, fallbackFont)
+		face.faces = append(face.faces, nil)
+	}
+	if len(face.fonts) == 1 {
+		return primary
+	}
+	return face
+}
+
+// faceFor returns the face to draw the character with. Characters missing
+// from every font use the primary font's missing glyph.
+func (f *fallbackFace) faceFor(r rune) font.Face {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for idx, ttf := range f.fonts {
+		if ttf.Index(r) == 0 {
+			continue
+		}
+		if f.faces[idx] == nil {
+			f.faces[idx] = truetype.NewFace(ttf,
+				&truetype.Options{Size: float64(f.size)})
+		}
+		return f.faces[idx]
+	}
+	return f.faces[0]
+}
+
+func (f *fallbackFace) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, face := range f.faces {
+		if face != nil {
+			face.Close()
+		}
+	}
+	return nil
+}
+
+func (f *fallbackFace) Glyph(dot fixed.Point26_6, r rune) (image.Rectangle,
+	image.Image, image.Point, fixed.Int26_6, bool) {
+	return f.faceFor(r).Glyph(dot, r)
+}
+
+func (f *fallbackFace) GlyphBounds(r rune) (fixed.Rectangle26_6, fixed.Int26_6,
+	bool) {
+	return f.faceFor(r).GlyphBounds(r)
+}
+
+func (f *fallbackFace) GlyphAdvance(r rune) (fixed.Int26_6, bool) {
+	return f.faceFor(r).GlyphAdvance(r)
+}
+
+// Kern only applies between characters drawn with the same font
+func (f *fallbackFace) Kern(r0, r1 rune) fixed.Int26_6 {
+	face := f.faceFor(r0)
+	if face != f.faceFor(r1) {
+		return 0
+	}
+	return face.Kern(r0, r1)
+}
+
+func (f *fallbackFace) Metrics() font.Metrics {
+	return f.faces[0].Metrics()
+}
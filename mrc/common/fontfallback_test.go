@@ -0,0 +1,129 @@
+package common
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fogleman/gg"
+	"golang.org/x/image/font"
+)
+
+const testFontsDir = "../../resources/fonts"
+
+func TestLoadFontWithFallbacks(t *testing.T) {
+	face := loadFontWithFallbacks(testFontsDir, "Dirga.ttf",
+		[]string{"missing.ttf", "Dirga.ttf", "SourceSansPro-Regular.ttf"}, 20)
+	if _, ok := face.(*fallbackFace); !ok {
+		t.Fatalf("Expected a fallback face, got %T", face)
+	}
+	primary := loadFont(testFontsDir, "Dirga.ttf", 20)
+	fallback := loadFont(testFontsDir, "SourceSansPro-Regular.ttf", 20)
+
+	// Latin uses the primary font, Cyrillic isn't in Dirga so uses the fallback
+	if w, _ := measureString(face, "Hello"); w != measureWidth(primary, "Hello") {
+		t.Errorf("Expected primary font width for Hello, got %d", w)
+	}
+	if w, _ := measureString(face, "Жук"); w != measureWidth(fallback, "Жук") {
+		t.Errorf("Expected fallback font width for Жук, got %d", w)
+	}
+	// Mixed strings add up and use the primary font's height
+	w, h := measureString(face, "HelloЖук")
+	if w != measureWidth(primary, "Hello")+measureWidth(fallback, "Жук") {
+		t.Errorf("Unexpected mixed width %d", w)
+	}
+	if _, primaryHeight := measureString(primary, "Hello"); h != primaryHeight {
+		t.Errorf("Expected primary height %d, got %d", primaryHeight, h)
+	}
+	if face.Kern('H', 'Ж') != 0 {
+		t.Error("Expected no kerning between fonts")
+	}
+	if err := face.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func measureWidth(face font.Face, text string) int {
+	w, _ := measureString(face, text)
+	return w
+}
+
+func TestLoadFontWithFallbacks_NoFallbacks(t *testing.T) {
+	for _, fallbacks := range [][]string{nil, {"missing.ttf"}, {"Dirga.ttf"}} {
+		face := loadFontWithFallbacks(testFontsDir, "Dirga.ttf", fallbacks, 20)
+		if _, ok := face.(*fallbackFace); ok {
+			t.Errorf("Expected plain face for fallbacks %v", fallbacks)
+		}
+	}
+}
+
+func TestLoadFontWithFallbacks_LogsMissing(t *testing.T) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+	// Missing fonts are remembered across tests
+	missingFallbackFonts.Delete("logged.ttf")
+	for range 2 {
+		loadFontWithFallbacks(testFontsDir, "Dirga.ttf",
+			[]string{"logged.ttf", "SourceSansPro-Regular.ttf"}, 20)
+	}
+	// Logged once rather than for every face
+	if count := strings.Count(out.String(), "Fallback font logged.ttf"); count != 1 {
+		t.Errorf("Expected the missing font logged once, got %q", out.String())
+	}
+}
+
+func TestFallbackFace_Concurrent(t *testing.T) {
+	face := loadFontWithFallbacks(testFontsDir, "Dirga.ttf",
+		[]string{"SourceSansPro-Regular.ttf"}, 20).(*fallbackFace)
+	// Fallback faces are created on first use from any goroutine
+	var wg sync.WaitGroup
+	faces := make([]font.Face, 8)
+	for idx := range faces {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			faces[idx] = face.faceFor('Ж')
+		}()
+	}
+	wg.Wait()
+	for _, f := range faces {
+		if f != faces[0] || f == face.faces[0] {
+			t.Fatal("Expected every goroutine to get the same fallback face")
+		}
+	}
+}
+
+func TestFontFaceCache_Fallbacks(t *testing.T) {
+	cache := NewFontFaceCache("SourceSansPro-Regular.ttf")
+	face := cache.LoadFont(testFontsDir, "Dirga.ttf", 20)
+	if _, ok := face.(*fallbackFace); !ok {
+		t.Errorf("Expected a fallback face, got %T", face)
+	}
+	if cache.LoadFont(testFontsDir, "Dirga.ttf", 20) != face {
+		t.Error("Expected cached face")
+	}
+
+	// The fallback character is drawn rather than the primary font's tofu
+	draw := func(face font.Face) *gg.Context {
+		dc := gg.NewContext(100, 40)
+		dc.SetFontFace(face)
+		dc.SetHexColor("#000000ff")
+		dc.DrawString("Ж", 10, 30)
+		return dc
+	}
+	withFallback := draw(face).Image()
+	withoutFallback := draw(loadFont(testFontsDir, "Dirga.ttf", 20)).Image()
+	var differs bool
+	for x := 0; x < 100 && !differs; x++ {
+		for y := 0; y < 40 && !differs; y++ {
+			differs = withFallback.At(x, y) != withoutFallback.At(x, y)
+		}
+	}
+	if !differs {
+		t.Error("Expected fallback character drawn instead of missing glyph")
+	}
+}
@@ -3,7 +3,9 @@ package common
 import (
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
@@ -11,10 +13,11 @@ import (
 
 // GameData holds the game's parsed data
 type GameData struct {
-	Logo        string                 `yaml:"Logo"`
-	Regexes     map[string]string      `yaml:"Regexes"`
-	InputMap    DeviceInputTypeMapping `yaml:"InputMap"`
-	InputLabels map[string]string      `yaml:"InputLabels"`
+	Logo           string                 `yaml:"Logo"`
+	Regexes        map[string]string      `yaml:"Regexes"`
+	InputMap       DeviceInputTypeMapping `yaml:"InputMap"`
+	InputLabels    map[string]string      `yaml:"InputLabels"`
+	ContextColours map[string]string      `yaml:"ContextColours"` // Context -> pinned colour
 }
 
 // DeviceInputTypeMapping contains a map of device short names to
@@ -34,10 +37,14 @@ const (
 	ProfileDefault = "default_metarefcard"
 )
 
-// Caser that returns Title case for a string.
+// Caser that returns Title case for a string. A Caser is stateful so access
+// is locked as images are generated concurrently.
 var titleCaser = cases.Title(language.AmericanEnglish)
+var titleCaserLock sync.Mutex
 
 func TitleCaser(text string) string {
+	titleCaserLock.Lock()
+	defer titleCaserLock.Unlock()
 	return titleCaser.String(text)
 }
 
@@ -48,14 +55,55 @@ type RegexByName map[string]*regexp.Regexp
 // Profile -> OverlaysByImage
 type OverlaysByProfile map[string]OverlaysByImage
 
+// HiddenContextsByProfile - contexts filtered out of each image, indexed by
+// profile. Profile -> Image -> Contexts
+type HiddenContextsByProfile map[string]map[string]Set
+
+// hide notes a context filtered out of the profile's image
+func (h HiddenContextsByProfile) hide(profile string, image string, context string) {
+	if h[profile] == nil {
+		h[profile] = make(map[string]Set)
+	}
+	if h[profile][image] == nil {
+		h[profile][image] = make(Set)
+	}
+	h[profile][image][context] = true
+}
+
+// Contexts returns the sorted contexts that were filtered out of the
+// profile's images
+func (h HiddenContextsByProfile) Contexts(profile string, images ...string) []string {
+	contexts := make(Set)
+	for _, image := range images {
+		for context := range h[profile][image] {
+			contexts[context] = true
+		}
+	}
+	hidden := contexts.Keys()
+	sort.Strings(hidden)
+	return hidden
+}
+
 // OverlaysByImage - image overlay data indexed by image name
 // Image -> Device:Input -> OverlayData
 type OverlaysByImage map[string]map[string]OverlayData
 
 // OverlayData - data about what to put in overlay, grouping and location
 type OverlayData struct {
-	ContextToTexts map[string][]string
-	PosAndSize     InputData
+	ContextToTexts   map[string][]string
+	ContextToActions map[string][]OverlayAction // The game actions behind the texts
+	PosAndSize       InputData
+	// Hat widgets only. Direction -> Context -> Texts
+	Directions map[string]map[string][]string
+}
+
+// OverlayAction - a game action and the game inputs bound to it
+type OverlayAction struct {
+	Text      string // Text drawn for the action
+	Action    string
+	Primary   string
+	Secondary string
+	Axis      *AxisSettings `json:",omitempty"` // Nil unless bound to an axis
 }
 
 // GameBindsByProfile - Profile -> Short name -> Context -> Action -> Primary/Secondary -> Key
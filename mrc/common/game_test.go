@@ -3,6 +3,7 @@ package common
 import (
 	"sort"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -15,6 +16,23 @@ func TestTitleCaser(t *testing.T) {
 	}
 }
 
+func TestTitleCaser_Concurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if result := TitleCaser("cockpit camera"); result != "Cockpit Camera" {
+					t.Errorf("Expected Cockpit Camera, got %s", result)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
 func TestContextToColours_Keys(t *testing.T) {
 	c := make(ContextToColours)
 	c["ctx1"] = "red"
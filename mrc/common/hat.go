@@ -0,0 +1,219 @@
+package common
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/fogleman/gg"
+)
+
+// InputTypeHat - input drawn as a compass rose with the actions of its
+// POV directions around it e.g. POV1: { x: 100, y: 300, w: 900, h: 300, type: hat }
+const InputTypeHat = "hat"
+
+// Hat directions
+const (
+	HatUp        = "Up"
+	HatUpRight   = "UpRight"
+	HatRight     = "Right"
+	HatDownRight = "DownRight"
+	HatDown      = "Down"
+	HatDownLeft  = "DownLeft"
+	HatLeft      = "Left"
+	HatUpLeft    = "UpLeft"
+)
+
+// Clockwise from up
+var hatDirections = []string{HatUp, HatUpRight, HatRight, HatDownRight, HatDown,
+	HatDownLeft, HatLeft, HatUpLeft}
+
+// hatCells are the column and row of each direction in the hat's 3x3 grid
+var hatCells = map[string][2]int{
+	HatUpLeft: {0, 0}, HatUp: {1, 0}, HatUpRight: {2, 0},
+	HatLeft: {0, 1}, HatRight: {2, 1},
+	HatDownLeft: {0, 2}, HatDown: {1, 2}, HatDownRight: {2, 2},
+}
+
+var hatInputRegex = regexp.MustCompile(`(?i)^(POV\d*)(up|down|left|right|upright|upleft|downright|downleft)$`)
+
+// hatInput returns the hat and direction for a POV direction input e.g.
+// POV1UpRight -> POV1, UpRight. Only inputs whose hat is a hat widget on the
+// device are matched.
+func hatInput(inputs DeviceInputs, input string) (string, string, bool) {
+	matches := hatInputRegex.FindStringSubmatch(input)
+	if matches == nil || inputs[matches[1]].Type != InputTypeHat {
+		return "", "", false
+	}
+	for _, direction := range hatDirections {
+		if strings.EqualFold(direction, matches[2]) {
+			return matches[1], direction, true
+		}
+	}
+	return "", "", false
+}
+
+// GenerateHatOverlays adds the action to the hat's overlay under its direction.
+// The binding's input is the hat.
+func GenerateHatOverlays(overlaysByImage OverlaysByImage, binding OverlayBinding,
+	direction string, gameData GameData, log *Logger) {
+	GenerateImageOverlays(overlaysByImage, binding, gameData, log)
+
+	text := binding.ActionName
+	if label, found := gameData.InputLabels[binding.ActionName]; found {
+		text = label
+	}
+	deviceAndInput := fmt.Sprintf("%s:%s", binding.ShortName, binding.Input)
+	overlayData := overlaysByImage[binding.Image][deviceAndInput]
+	if overlayData.Directions == nil {
+		overlayData.Directions = make(map[string]map[string][]string)
+		overlaysByImage[binding.Image][deviceAndInput] = overlayData
+	}
+	contextToTexts, found := overlayData.Directions[direction]
+	if !found {
+		contextToTexts = make(map[string][]string)
+		overlayData.Directions[direction] = contextToTexts
+	}
+	contextToTexts[binding.Context] = append(contextToTexts[binding.Context], text)
+}
+
+// hasDiagonals returns true if any diagonal direction has actions
+func hasDiagonals(directions map[string]map[string][]string) bool {
+	for _, direction := range []string{HatUpRight, HatDownRight, HatDownLeft,
+		HatUpLeft} {
+		if len(directions[direction]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// drawHat draws a compass rose in the centre of the hat's box with the actions
+// for each direction at its compass point. The box is split into three rows.
+// Without diagonal actions up and down use the full width. Returns the chips
+// drawn. Directions that don't fit get a marker and are added to overflows.
+func drawHat(dc *gg.Context, overlayData OverlayData, pixelMultiplier float64,
+	categories map[string]string, config *Config, fontCache FontLoader,
+	overflows *[]overflowNote) []CardChip {
+	box := overlayData.PosAndSize
+	x := float64(box.X) * pixelMultiplier
+	y := float64(box.Y) * pixelMultiplier
+	w := float64(box.W) * pixelMultiplier
+	h := float64(box.H) * pixelMultiplier
+	cellW, cellH := w/3, h/3
+	diagonals := hasDiagonals(overlayData.Directions)
+
+	dc.SetHexColor(config.BackgroundColour)
+	dc.DrawRoundedRectangle(x, y, w, h, 6)
+	dc.FillPreserve()
+	dc.SetHexColor(config.DarkColour)
+	dc.SetLineWidth(math.Max(1, 2*pixelMultiplier))
+	dc.Stroke()
+
+	// Rose points, shorter for the diagonals
+	centreX, centreY := x+w/2, y+h/2
+	roseWidth := math.Min(cellW, cellH) // Left and right get the rest of the row
+	radius := 0.4 * roseWidth
+	for _, direction := range hatDirections {
+		cell := hatCells[direction]
+		dx, dy := float64(cell[0]-1), float64(cell[1]-1)
+		length := radius
+		if dx != 0 && dy != 0 {
+			if !diagonals {
+				continue
+			}
+			length *= 0.6
+		}
+		angle := math.Atan2(dy, dx)
+		baseX, baseY := -math.Sin(angle)*radius*0.2, math.Cos(angle)*radius*0.2
+		dc.MoveTo(centreX+math.Cos(angle)*length, centreY+math.Sin(angle)*length)
+		dc.LineTo(centreX+baseX, centreY+baseY)
+		dc.LineTo(centreX-baseX, centreY-baseY)
+		dc.ClosePath()
+		dc.Fill()
+	}
+
+	var chips []CardChip
+	for _, direction := range hatDirections {
+		contextToTexts := overlayData.Directions[direction]
+		if len(contextToTexts) == 0 {
+			continue
+		}
+		cell := hatCells[direction]
+		left, width := x+float64(cell[0])*cellW, cellW
+		switch {
+		case !diagonals && (direction == HatUp || direction == HatDown):
+			left, width = x, w
+		case direction == HatLeft:
+			left, width = x, (w-roseWidth)/2
+		case direction == HatRight:
+			left, width = x+(w+roseWidth)/2, (w-roseWidth)/2
+		}
+		chips = append(chips, drawHatLabel(dc, contextToTexts,
+			overlayData.ContextToActions, left, y+float64(cell[1])*cellH, width,
+			cellH, pixelMultiplier, categories, config, fontCache, overflows)...)
+	}
+	return chips
+}
+
+// drawHatLabel draws a direction's texts centred in its cell
+func drawHatLabel(dc *gg.Context, contextToTexts map[string][]string,
+	contextToActions map[string][]OverlayAction, left float64, top float64,
+	width float64, height float64, pixelMultiplier float64,
+	categories map[string]string, config *Config, fontCache FontLoader,
+	overflows *[]overflowNote) []CardChip {
+	targetWidth := int(math.Round(width - 2*config.InputPixelXInset*pixelMultiplier))
+	targetHeight := int(math.Round(height - 2*config.InputPixelYInset*pixelMultiplier))
+
+	// Same layout as an input box, texts in context order separated by a space
+	fullText := ""
+	incrementalTexts := []string{""}
+	for _, context := range prepareContexts(contextToTexts) {
+		for _, text := range contextToTexts[context] {
+			text = chipText(context, text, contextToActions)
+			if len(fullText) != 0 {
+				fullText = fmt.Sprintf("%s %s", fullText, text)
+			} else {
+				fullText = text
+			}
+			incrementalTexts = append(incrementalTexts, fullText+" ")
+		}
+	}
+	fontSize := calcFontSize(fullText, fontCache, targetHeight, targetWidth,
+		targetHeight, config.FontsDir, config.InputFont, config.InputMinFontSize)
+	largeFont := fontCache.LoadFont(config.FontsDir, config.InputFont, fontSize)
+	smallFont := fontCache.LoadFont(config.FontsDir, config.InputFont, fontSize-1)
+	textWidth, _ := measureString(largeFont, fullText)
+	if len(config.Notes.Location) > 0 && textWidth > targetWidth {
+		// Too long even at the min font size, list it in the notes instead
+		overflow := overflowNote{marker: len(*overflows) + 1,
+			contextToTexts: contextToTexts, contextToActions: contextToActions}
+		*overflows = append(*overflows, overflow)
+		diameter := float64(targetHeight)
+		drawNoteMarker(dc, overflow.marker, left+width/2, top+height/2, diameter,
+			fontCache.LoadFont(config.FontsDir, config.Notes.Font,
+				int(math.Round(diameter*0.7))),
+			config.Notes.MarkerColour, config.Notes.TextColour)
+		return nil
+	}
+	start := left + (width-float64(textWidth))/2 -
+		config.InputPixelXInset*pixelMultiplier
+
+	var chips []CardChip
+	idx := 0
+	location := Point2d{Y: top / pixelMultiplier}
+	for _, context := range prepareContexts(contextToTexts) {
+		for _, text := range contextToTexts[context] {
+			offset, _ := measureString(largeFont, incrementalTexts[idx])
+			idx++
+			rect := drawTextWithBackgroundRec(dc,
+				chipText(context, text, contextToActions), start+float64(offset),
+				location, config.InputPixelXInset, config.InputPixelYInset,
+				targetHeight, pixelMultiplier, largeFont, smallFont,
+				categories[context], config.LightColour)
+			chips = append(chips, newCardChip(rect, context, text, contextToActions))
+		}
+	}
+	return chips
+}
@@ -0,0 +1,170 @@
+package common
+
+import (
+	"image"
+	"testing"
+
+	"github.com/fogleman/gg"
+)
+
+func TestHatInput(t *testing.T) {
+	inputs := DeviceInputs{
+		"POV1":   InputData{X: 10, Y: 10, W: 300, H: 90, Type: InputTypeHat},
+		"POV2":   InputData{X: 10, Y: 200, W: 300, H: 90},
+		"POV2Up": InputData{X: 10, Y: 200, W: 300, H: 30},
+	}
+	for _, test := range []struct {
+		input     string
+		hat       string
+		direction string
+		isHat     bool
+	}{
+		{"POV1Up", "POV1", HatUp, true},
+		{"POV1Upright", "POV1", HatUpRight, true}, // FS2020 title cases the direction
+		{"POV1DOWNLEFT", "POV1", HatDownLeft, true},
+		{"POV2Up", "", "", false}, // Not a hat widget
+		{"POV3Up", "", "", false},
+		{"POV1Forward", "", "", false},
+		{"POV1", "", "", false},
+		{"1", "", "", false},
+	} {
+		hat, direction, isHat := hatInput(inputs, test.input)
+		if hat != test.hat || direction != test.direction || isHat != test.isHat {
+			t.Errorf("%s expected %s %s %v, got %s %s %v", test.input, test.hat,
+				test.direction, test.isHat, hat, direction, isHat)
+		}
+	}
+}
+
+func TestPopulateImageOverlays_Hat(t *testing.T) {
+	log, _ := mockLogger()
+	hatData := InputData{X: 10, Y: 10, W: 300, H: 90, Type: InputTypeHat}
+	config := &Config{
+		Devices: Devices{
+			Index: DeviceMap{"d1": DeviceInputs{
+				"POV1": hatData,
+				"1":    InputData{X: 10, Y: 200, W: 100, H: 30},
+			}},
+			ImageMap: ImageMap{"d1": "d1"},
+		},
+	}
+	binds := GameBindsByProfile{"Default": GameDeviceContextActions{
+		"d1": GameContextActions{
+			"PLANE": GameActions{
+				"VIEW_UP":    {"POV1Up", ""},
+				"LOOK_UP":    {"POV1Up", ""},
+				"VIEW_RIGHT": {"POV1Right", ""},
+				"GEAR":       {"1", ""},
+			},
+			"CAMERA": GameActions{"ZOOM": {"POV1Up", ""}},
+		},
+	}}
+	gameData := GameData{InputLabels: map[string]string{"VIEW_UP": "View Up",
+		"LOOK_UP": "Look Up", "VIEW_RIGHT": "View Right", "GEAR": "Gear",
+		"ZOOM": "Zoom"}}
+	matchFunc := func(deviceName string, actionData GameInput,
+		deviceInputs DeviceInputs, gameInputMap InputTypeMapping, log *Logger) (GameInput, string) {
+		return actionData, "test"
+	}
+
+	overlaysByProfile, _ := PopulateImageOverlays(Set{"d1": true}, config, log,
+		binds, nil, gameData, matchFunc, nil)
+	overlays := overlaysByProfile["Default"]["d1"]
+	if len(overlays) != 2 {
+		t.Fatalf("Expected the hat and a button, got %v", overlays)
+	}
+	hat, found := overlays["d1:POV1"]
+	if !found || hat.PosAndSize != hatData {
+		t.Fatalf("Expected hat overlay, got %+v", overlays)
+	}
+	up := hat.Directions[HatUp]
+	if len(up["PLANE"]) != 2 || up["PLANE"][0] != "Look Up" ||
+		up["PLANE"][1] != "View Up" || up["CAMERA"][0] != "Zoom" {
+		t.Errorf("Unexpected up texts %v", up)
+	}
+	if hat.Directions[HatRight]["PLANE"][0] != "View Right" ||
+		len(hat.Directions) != 2 {
+		t.Errorf("Unexpected directions %v", hat.Directions)
+	}
+	// All the texts and actions are on the hat for the legend, notes and metadata
+	if len(hat.ContextToTexts["PLANE"]) != 3 || len(hat.ContextToActions["PLANE"]) != 3 {
+		t.Errorf("Unexpected hat texts %v", hat.ContextToTexts)
+	}
+}
+
+func testHatConfig() *Config {
+	return &Config{
+		InputPixelXInset: 1,
+		InputPixelYInset: 1,
+		FontsDir:         "../../resources/fonts",
+		InputFont:        "Dirga.ttf",
+		InputMinFontSize: 5,
+		LightColour:      "#000000",
+		DarkColour:       "#333333",
+		BackgroundColour: "#ffffff",
+		Notes: NotesData{Location: NotesPage, Font: "Dirga.ttf",
+			MarkerColour: "#333333", TextColour: "#ffffff"},
+	}
+}
+
+func TestDrawHat(t *testing.T) {
+	overlayData := OverlayData{
+		PosAndSize: InputData{X: 10, Y: 10, W: 300, H: 90, Type: InputTypeHat},
+		Directions: map[string]map[string][]string{
+			HatUp:    {"PLANE": {"Up"}},
+			HatDown:  {"PLANE": {"Down"}},
+			HatLeft:  {"PLANE": {"Left"}},
+			HatRight: {"PLANE": {"Right"}, "CAMERA": {"Zoom"}},
+		},
+		ContextToActions: map[string][]OverlayAction{"PLANE": {
+			{Text: "Up", Action: "UP"}}},
+	}
+	var overflows []overflowNote
+	chips := drawHat(gg.NewContext(400, 200), overlayData, 1.0,
+		map[string]string{"PLANE": "#ff0000", "CAMERA": "#00ff00"},
+		testHatConfig(), NewFontFaceCache(), &overflows)
+	if len(chips) != 5 || len(overflows) != 0 {
+		t.Fatalf("Expected 5 chips, got %d and %d overflows", len(chips), len(overflows))
+	}
+	// Clockwise from up
+	up, right, down, left := chips[0], chips[1], chips[3], chips[4]
+	if up.Actions[0].Action != "UP" || right.Context != "CAMERA" {
+		t.Errorf("Unexpected chips %+v", chips)
+	}
+	box := image.Rect(10, 10, 310, 100)
+	centre := image.Pt(160, 55)
+	for _, chip := range chips {
+		if !chip.Rect.In(box) {
+			t.Errorf("Chip %v outside the hat %v", chip.Rect, box)
+		}
+	}
+	if up.Rect.Max.Y > centre.Y || down.Rect.Min.Y < centre.Y ||
+		left.Rect.Max.X > centre.X || right.Rect.Min.X < centre.X {
+		t.Errorf("Chips not at their compass points %v %v %v %v", up.Rect,
+			right.Rect, down.Rect, left.Rect)
+	}
+}
+
+func TestDrawHat_Overflow(t *testing.T) {
+	overlayData := OverlayData{
+		PosAndSize: InputData{X: 0, Y: 0, W: 90, H: 60, Type: InputTypeHat},
+		Directions: map[string]map[string][]string{
+			HatUp:        {"PLANE": {"Up"}},
+			HatUpRight:   {"PLANE": {"A much longer action than fits"}},
+			HatDownRight: {"PLANE": {"DR"}},
+		},
+	}
+	var overflows []overflowNote
+	chips := drawHat(gg.NewContext(100, 100), overlayData, 1.0,
+		map[string]string{"PLANE": "#ff0000"}, testHatConfig(), NewFontFaceCache(),
+		&overflows)
+	if len(chips) != 2 || len(overflows) != 1 || overflows[0].marker != 1 ||
+		overflows[0].contextToTexts["PLANE"][0] != "A much longer action than fits" {
+		t.Errorf("Expected the long direction in the notes, got %d chips %+v",
+			len(chips), overflows)
+	}
+	// Diagonals split the top row into thirds
+	if chips[0].Rect.Max.X > 60 || chips[0].Rect.Min.X < 30 {
+		t.Errorf("Expected up in the middle third, got %v", chips[0].Rect)
+	}
+}
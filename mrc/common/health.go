@@ -0,0 +1,140 @@
+package common
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+)
+
+// GitCommit is the commit the server was built from. It can be set with
+// -ldflags "-X github.com/ankurkotwal/metarefcard/mrc/common.GitCommit=<commit>",
+// otherwise it's read from the build info.
+var GitCommit string
+
+// maxReadinessErrors - errors listed per readiness check
+const maxReadinessErrors = 5
+
+// ReadinessCheck is the result of one of the server's readiness checks. An
+// empty error passed.
+type ReadinessCheck struct {
+	Name  string
+	Error string `json:",omitempty"`
+}
+
+// VersionInfo describes the running server
+type VersionInfo struct {
+	AppName string
+	Version string
+	Commit  string
+	Games   []string
+	Devices int // Devices in the device index
+}
+
+// GameConfigFile returns the file with a game's data
+func GameConfigFile(label string) string {
+	return fmt.Sprintf("config/%s.yaml", label)
+}
+
+// BuildCommit returns the commit the server was built from, marked dirty if
+// it had local changes, or unknown
+func BuildCommit() string {
+	if len(GitCommit) > 0 {
+		return GitCommit
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	commit, modified := "", false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			commit = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if len(commit) == 0 {
+		return "unknown"
+	}
+	if modified {
+		commit += "-dirty"
+	}
+	return commit
+}
+
+// CheckReadiness checks what the running server loaded i.e. the config, the
+// device index, the fonts in the font cache and each game's data and logo.
+// Files aren't read again so a probe stays cheap, only what's missing from
+// the caches is loaded.
+func CheckReadiness(config *Config, games map[string]GameData) []ReadinessCheck {
+	if config == nil {
+		return []ReadinessCheck{{Name: "config", Error: "the config isn't loaded"}}
+	}
+	checks := []ReadinessCheck{{Name: "config"}}
+	add := func(name string, errs []string) {
+		check := ReadinessCheck{Name: name}
+		if len(errs) > maxReadinessErrors {
+			errs = append(errs[:maxReadinessErrors],
+				fmt.Sprintf("and %d more", len(errs)-maxReadinessErrors))
+		}
+		check.Error = strings.Join(errs, "; ")
+		checks = append(checks, check)
+	}
+
+	add("devices", checkDevices(config))
+	add("fonts", checkFonts(config))
+	for _, game := range sortedKeys(games) {
+		add("game "+game, checkGame(games[game], config))
+	}
+	return checks
+}
+
+// Ready returns true if all the checks passed
+func Ready(checks []ReadinessCheck) bool {
+	for _, check := range checks {
+		if len(check.Error) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func checkDevices(config *Config) []string {
+	if len(config.Devices.Index) == 0 {
+		return []string{"the device index is empty"}
+	}
+	if len(config.Devices.ImageMap) == 0 {
+		return []string{"the image map is empty"}
+	}
+	return nil
+}
+
+func checkFonts(config *Config) []string {
+	names := map[string]bool{config.InputFont: true, config.ImageHeader.Font: true,
+		config.Watermark.Font: true, config.Legend.Font: true, config.Notes.Font: true}
+	for _, name := range config.FallbackFonts {
+		names[name] = true
+	}
+	var errs []string
+	for _, name := range sortedKeys(names) {
+		if len(name) == 0 {
+			continue
+		}
+		if _, err := loadTrueType(config.FontsDir, name); err != nil {
+			errs = append(errs, fmt.Sprintf("font %s - %s", name, err))
+		}
+	}
+	return errs
+}
+
+func checkGame(data GameData, config *Config) []string {
+	if len(data.Regexes) == 0 {
+		return []string{"the game data isn't loaded"}
+	}
+	logo := fmt.Sprintf("%s/%s.jpg", config.LogoImagesDir, data.Logo)
+	if _, err := imageCache.Load(logo, NewLog()); err != nil {
+		return []string{fmt.Sprintf("logo %s", err)}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readinessErrors(checks []ReadinessCheck) map[string]string {
+	errs := make(map[string]string)
+	for _, check := range checks {
+		if len(check.Error) > 0 {
+			errs[check.Name] = check.Error
+		}
+	}
+	return errs
+}
+
+func TestCheckReadiness(t *testing.T) {
+	fontsDir, _ := filepath.Abs(testFontsDir)
+	t.Chdir(t.TempDir())
+	os.Mkdir("logos", 0755)
+	createDummyJpg(t, "logos/test.jpg")
+	config := &Config{LogoImagesDir: "logos", FontsDir: fontsDir,
+		InputFont: "Dirga.ttf"}
+	config.Devices.Index = DeviceMap{"X55Stick": {}}
+	config.Devices.ImageMap = ImageMap{"X55Stick": "x55-stick"}
+	games := map[string]GameData{"test": {Logo: "test",
+		Regexes: map[string]string{"Bind": "^a$"}}}
+
+	checks := CheckReadiness(config, games)
+	if !Ready(checks) || len(checks) != 4 {
+		t.Fatalf("Expected ready, got %v", checks)
+	}
+
+	// What isn't loaded fails its checks
+	config.Legend.Font = "missing.ttf"
+	games["other"] = GameData{}
+	games["test"] = GameData{Logo: "missing", Regexes: games["test"].Regexes}
+	errs := readinessErrors(CheckReadiness(config, games))
+	if len(errs) != 3 || !strings.Contains(errs["fonts"], "missing.ttf") ||
+		errs["game other"] != "the game data isn't loaded" ||
+		!strings.Contains(errs["game test"], "logos/missing.jpg") {
+		t.Errorf("Unexpected errors %v", errs)
+	}
+
+	// An empty device index
+	config.Devices.Index = nil
+	if errs := readinessErrors(CheckReadiness(config, nil)); errs["devices"] != "the device index is empty" {
+		t.Errorf("Expected the devices to fail, got %v", errs)
+	}
+
+	// No config fails before anything else is checked
+	checks = CheckReadiness(nil, games)
+	if Ready(checks) || len(checks) != 1 || checks[0].Name != "config" {
+		t.Errorf("Expected the config to fail, got %v", checks)
+	}
+}
+
+func TestBuildCommit(t *testing.T) {
+	defer func() { GitCommit = "" }()
+	GitCommit = "abc123"
+	if commit := BuildCommit(); commit != "abc123" {
+		t.Errorf("Expected the linked commit, got %s", commit)
+	}
+	GitCommit = ""
+	if commit := BuildCommit(); len(commit) == 0 {
+		t.Error("Expected a commit or unknown")
+	}
+}
+
+func TestLoadDevicesFile(t *testing.T) {
+	if err := LoadDevicesFile("missing.yaml", &Devices{}); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+	dir := t.TempDir()
+	generated := filepath.Join(dir, "generated.yaml")
+	devices := filepath.Join(dir, "devices.yaml")
+	os.WriteFile(generated, []byte("ImageMap:\n  A: a\n  B: b\n"), 0644)
+	os.WriteFile(devices, []byte("GeneratedFile: "+generated+"\nImageMap:\n  B: c\n"), 0644)
+	var loaded Devices
+	if err := LoadDevicesFile(devices, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.ImageMap["A"] != "a" || loaded.ImageMap["B"] != "c" {
+		t.Errorf("Expected the image maps merged, got %v", loaded.ImageMap)
+	}
+}
@@ -4,42 +4,52 @@ import (
 	"bytes"
 	"fmt"
 	"image"
-	"io"
 	"math"
 	"os"
 	"sort"
+	"strings"
 
 	"sync"
 	"sync/atomic"
 
 	"github.com/fogleman/gg"
-	"github.com/pixiv/go-libjpeg/jpeg"
+	xdraw "golang.org/x/image/draw"
 	"golang.org/x/image/font"
 )
 
-// JpegEncoder is the function type for encoding images to JPEG.
-// It's a package-level variable to allow injection during testing.
-type JpegEncoder func(w io.Writer, m image.Image, o *jpeg.EncoderOptions) error
-
-// jpegEncoderFunc is the default JPEG encoder (can be replaced in tests)
-var jpegEncoderFunc JpegEncoder = jpeg.Encode
-
+// ConfigureRendering sizes the image cache and render pool shared by every
+// request from the config
+func ConfigureRendering(config *Config) {
+	imageCache.SetMaxBytes(int64(config.ImageCacheMaxMB) << 20)
+	renderPool.SetWorkers(config.Render.Workers)
+}
 
-// GenerateImages returns the generated images
+// GenerateImages returns the generated images, their thumbnails and the chips
+// drawn on them. Thumbnails are empty if disabled by the config. The hidden
+// contexts are listed in each card's legend.
 func GenerateImages(overlaysByProfile OverlaysByProfile,
-	categories map[string]string,
-	gameLabel string, config *Config, log *Logger) ([]bytes.Buffer, int) {
+	hidden HiddenContextsByProfile, categories map[string]string,
+	gameLabel string, config *Config, log *Logger,
+	opts *RequestOptions) ([]bytes.Buffer, []bytes.Buffer, []CardChips, int) {
 
 	profiles, imageNamesByProfile, numFiles := prepImgGenData(overlaysByProfile)
 	files := make([]bytes.Buffer, 0, numFiles)
 	var numBytes int
+	// The logo and composites are scaled like images of the default size
+	scale := opts.outputScale(config,
+		float64(config.DefaultImage.W)*config.PixelMultiplier)
 
 	// Add game logo
 	logoFilename := fmt.Sprintf("%s/%s.jpg", config.LogoImagesDir, gameLabel)
-	logo, err := decodeJpg(logoFilename, log)
+	logo, err := imageCache.Load(logoFilename, log)
 	if err != nil {
-		log.Err("loadImage %s failed. %v", logoFilename, err)
-		return files, numBytes
+		log.ErrAs(ErrorImage, "loadImage %s failed. %v", logoFilename, err)
+		return files, nil, nil, numBytes
+	}
+	logo = scaleImage(logo, scale)
+	if opts != nil && opts.Composite {
+		return generateComposites(profiles, imageNamesByProfile, overlaysByProfile,
+			hidden, categories, logo, scale, config, log, opts, gameLabel)
 	}
 
 	// Pre-calculate inputs to allow using index for deterministic output order
@@ -62,48 +72,73 @@ func GenerateImages(overlaysByProfile OverlaysByProfile,
 	}
 
 	files = make([]bytes.Buffer, len(workItems))
-	var totalBytes int64
+	thumbnails := make([]bytes.Buffer, len(workItems))
+	cardChips := make([]CardChips, len(workItems))
+	var totalBytes, rendered int64
 
 	var wg sync.WaitGroup
 	for _, item := range workItems {
 		wg.Add(1)
 		go func(item workItem) {
 			defer wg.Done()
+			renderPool.Acquire()
+			defer renderPool.Release()
 			
 			// Create a local font cache for this image generation to ensure thread safety
 			// as font.Face is not thread safe
-			fontCache := NewFontFaceCache()
+			fontCache := NewFontFaceCache(config.FallbackFonts...)
 			
-			pixelMultiplier := getPixelMultiplier(item.imageName, config)
 			imageFilename := fmt.Sprintf("%s/%s.jpg", config.HotasImagesDir,
 				item.imageName)
-			image, err := decodeJpg(imageFilename, log)
+			image, err := imageCache.Load(imageFilename, log)
 			if err != nil || image == nil {
-				log.Err("loadImage %s failed. %v", item.imageName, err)
+				log.ErrAs(ErrorImage, "loadImage %s failed. %v", item.imageName, err)
 				return
 			}
+			// Images with a size override aren't the default size
+			imageScale := opts.outputScale(config, float64(image.Bounds().Dx()))
+			pixelMultiplier := getPixelMultiplier(item.imageName, config, imageScale)
+			image = scaleImage(image, imageScale)
 			dc := gg.NewContextForRGBA(image)
 
 			dc.DrawImage(logo, 0, 0)
 			xOffset := float64(logo.Bounds().Max.X)
-			addImageHeader(dc, &config.ImageHeader, item.profile,
-				config.Devices.DeviceLabelsByImage[item.imageName],
+			addImageHeader(dc, &config.ImageHeader, opts.headerText(
+				config.Devices.DeviceLabelsByImage[item.imageName], item.profile),
 				xOffset, pixelMultiplier, config.FontsDir,
 				config.InputMinFontSize, fontCache)
 			addMRCLogo(dc, &config.Watermark, config.Version, config.Domain,
 				xOffset, float64(config.InputPixelXInset), pixelMultiplier,
 				config.FontsDir, fontCache)
+			overlays := overlaysByProfile[item.profile][item.imageName]
+			addLegend(dc, &config.Legend, imageContexts(overlays),
+				hidden.Contexts(item.profile, item.imageName),
+				categories, config.ImageHeader.BackgroundHeight*pixelMultiplier,
+				pixelMultiplier, config.FontsDir, config.InputMinFontSize, fontCache)
 
 			// Load the image
-			imgBytes := populateImage(dc, imageFilename, image.Bounds().Size(),
-				pixelMultiplier, overlaysByProfile[item.profile][item.imageName],
-				categories, config, log, fontCache)
+			imgBytes, thumbnail, chips := populateImage(dc, imageFilename,
+				image.Bounds().Size(), pixelMultiplier, overlays, categories,
+				config, log, fontCache)
+			chips.Profile = item.profile
+			chips.Image = item.imageName
 			files[item.index] = imgBytes
+			thumbnails[item.index] = thumbnail
+			cardChips[item.index] = chips
 			atomic.AddInt64(&totalBytes, int64(imgBytes.Len()))
+			if imgBytes.Len() > 0 {
+				atomic.AddInt64(&rendered, 1)
+			}
 		}(item)
 	}
 	wg.Wait()
-	return files, int(totalBytes)
+	recordImages(gameLabel, rendered, totalBytes)
+	if config.DebugOutput {
+		hits, misses, usedBytes := imageCache.Stats()
+		log.Dbg("Image cache hits %d misses %d using %d MB", hits, misses,
+			usedBytes>>20)
+	}
+	return files, thumbnails, cardChips, int(totalBytes)
 }
 
 // Returns a sorted list of profile names, a map containing sorted image names
@@ -129,11 +164,24 @@ func prepImgGenData(overlaysByProfile OverlaysByProfile) ([]string,
 	return profiles, imageNamesByProfile, numFiles
 }
 
-// GenerateImage - generates an image with the provided overlays
+// GenerateImage - generates an image with the provided overlays. Returns the
+// encoded image, its thumbnail and the chips drawn on it
 func populateImage(dc *gg.Context, imageFilename string, imgSize image.Point,
 	pixelMultiplier float64, overlayDataRange map[string]OverlayData,
 	categories map[string]string, config *Config, log *Logger,
-	fontCache FontLoader) bytes.Buffer {
+	fontCache FontLoader) (bytes.Buffer, bytes.Buffer, CardChips) {
+	dc, cardChips := drawOverlays(dc, imageFilename, imgSize, pixelMultiplier,
+		overlayDataRange, categories, config, log, fontCache)
+	imgBytes, thumbnail := encodeCard(dc.Image(), config, log)
+	return imgBytes, thumbnail, cardChips
+}
+
+// drawOverlays draws the overlays and notes on the image. The notes might
+// extend the image so the returned context must be used.
+func drawOverlays(dc *gg.Context, imageFilename string, imgSize image.Point,
+	pixelMultiplier float64, overlayDataRange map[string]OverlayData,
+	categories map[string]string, config *Config, log *Logger,
+	fontCache FontLoader) (*gg.Context, CardChips) {
 
 	width := float64(imgSize.X)
 	height := float64(imgSize.Y)
@@ -145,6 +193,8 @@ func populateImage(dc *gg.Context, imageFilename string, imgSize image.Point,
 	}
 	sort.Strings(keys)
 
+	var overflows []overflowNote
+	var chips []CardChip
 	for _, key := range keys {
 		overlayData := overlayDataRange[key]
 		// Skip known bad locations
@@ -155,10 +205,19 @@ func populateImage(dc *gg.Context, imageFilename string, imgSize image.Point,
 		yLoc := float64(overlayData.PosAndSize.Y) * pixelMultiplier
 
 		if xLoc >= width || yLoc >= height {
-			log.Err("Overlay outside bounds. File %s overlayData %v defaults %v",
+			log.ErrAs(ErrorImage, "Overlay outside bounds. File %s overlayData %v defaults %v",
 				imageFilename, overlayData.PosAndSize, config.DefaultImage)
 			continue
 		}
+		if overlayData.PosAndSize.Anchor != nil {
+			drawLeaderLine(dc, overlayData.PosAndSize, &config.LeaderLine,
+				pixelMultiplier, imageFilename, log)
+		}
+		if overlayData.PosAndSize.Type == InputTypeHat {
+			chips = append(chips, drawHat(dc, overlayData, pixelMultiplier,
+				categories, config, fontCache, &overflows)...)
+			continue
+		}
 
 		fontSize := int(math.Round(config.InputFontSize * pixelMultiplier))
 		targetWidth := int(math.Round((float64(overlayData.PosAndSize.W) -
@@ -174,6 +233,7 @@ func populateImage(dc *gg.Context, imageFilename string, imgSize image.Point,
 			texts := overlayData.ContextToTexts[context]
 			// First get the full text to workout font size
 			for _, text := range texts {
+				text = chipText(context, text, overlayData.ContextToActions)
 				padding := " "
 				if len(fullText) != 0 {
 					fullText = fmt.Sprintf("%s%s%s", fullText, padding, text)
@@ -186,6 +246,28 @@ func populateImage(dc *gg.Context, imageFilename string, imgSize image.Point,
 		fontSize = calcFontSize(fullText, fontCache, fontSize, targetWidth,
 			targetHeight, config.FontsDir, config.InputFont,
 			config.InputMinFontSize)
+		if len(config.Notes.Location) > 0 {
+			// calcFontSize stops at the min font size. If the text still
+			// doesn't fit, show a marker and list the text in the notes.
+			textWidth, _ := measureString(fontCache.LoadFont(config.FontsDir,
+				config.InputFont, fontSize), fullText)
+			if textWidth > targetWidth {
+				overflow := overflowNote{marker: len(overflows) + 1,
+					contextToTexts:   overlayData.ContextToTexts,
+					contextToActions: overlayData.ContextToActions}
+				overflows = append(overflows, overflow)
+				diameter := float64(targetHeight)
+				drawNoteMarker(dc, overflow.marker,
+					(float64(overlayData.PosAndSize.X)+config.InputPixelXInset)*
+						pixelMultiplier+diameter/2,
+					(float64(overlayData.PosAndSize.Y)+config.InputPixelYInset)*
+						pixelMultiplier+diameter/2, diameter,
+					fontCache.LoadFont(config.FontsDir, config.Notes.Font,
+						int(math.Round(diameter*0.7))),
+					config.Notes.MarkerColour, config.Notes.TextColour)
+				continue
+			}
+		}
 		// Now create overlays for each text
 		// Ugh, second loop through texts
 		idx := 0
@@ -200,34 +282,58 @@ func populateImage(dc *gg.Context, imageFilename string, imgSize image.Point,
 				idx++
 				location := Point2d{X: float64(overlayData.PosAndSize.X),
 					Y: float64(overlayData.PosAndSize.Y)}
-				drawTextWithBackgroundRec(dc, text, float64(offset),
+				rect := drawTextWithBackgroundRec(dc,
+					chipText(context, text, overlayData.ContextToActions),
+					float64(offset),
 					location, config.InputPixelXInset, config.InputPixelYInset,
 					targetHeight, pixelMultiplier, largeFont, smallFont,
 					categories[context], config.LightColour)
+				chips = append(chips, newCardChip(rect, context, text,
+					overlayData.ContextToActions))
 			}
 		}
 	}
 
+	dc, noteChips := addNotes(dc, &config.Notes, overflows, categories,
+		config.ImageHeader.BackgroundHeight*pixelMultiplier, pixelMultiplier,
+		config.FontsDir, config.BackgroundColour, config.LightColour, fontCache)
+	return dc, CardChips{Width: dc.Width(), Height: dc.Height(),
+		Chips: append(chips, noteChips...)}
+}
+
+// encodeCard returns the JPEG encoded card and its thumbnail. The thumbnail is
+// empty if disabled by the config.
+func encodeCard(card image.Image, config *Config, log *Logger) (bytes.Buffer,
+	bytes.Buffer) {
 	var imgBytes bytes.Buffer
-	err := jpegEncoderFunc(&imgBytes, dc.Image(), &jpeg.EncoderOptions{Quality: config.JpgQuality})
+	err := jpegEncoderFunc(&imgBytes, card, config.JpgQuality)
 	if err != nil {
-		log.Err("jpeg encode failed: %v", err)
+		log.ErrAs(ErrorImage, "jpeg encode failed: %v", err)
+	}
+	var thumbnail bytes.Buffer
+	if config.ThumbnailWidth > 0 {
+		thumbnailImage := scaleImage(card,
+			float64(config.ThumbnailWidth)/float64(card.Bounds().Dx()))
+		err = jpegEncoderFunc(&thumbnail, thumbnailImage, config.JpgQuality)
+		if err != nil {
+			log.ErrAs(ErrorImage, "thumbnail jpeg encode failed: %v", err)
+		}
 	}
-	return imgBytes
+	return imgBytes, thumbnail
 }
 
 func decodeJpg(imageName string, log *Logger) (image *image.RGBA, err error) {
 	var r *os.File
 	r, err = os.Open(imageName)
 	if err != nil {
-		log.Err("failed to open: %v", err)
+		log.ErrAs(ErrorImage, "failed to open: %v", err)
 		return
 	}
 	defer r.Close()
 
-	image, err = jpeg.DecodeIntoRGBA(r, &jpeg.DecoderOptions{})
+	image, err = jpegDecoderFunc(r)
 	if err != nil {
-		log.Err("failed to decode: %v", err)
+		log.ErrAs(ErrorImage, "failed to decode: %v", err)
 		return
 	}
 	return
@@ -297,19 +403,80 @@ func prepareContexts(contextToTexts map[string][]string) []string {
 	return contexts
 }
 
-// Return the multiplier/scale of image based on actual width vs default width
-func getPixelMultiplier(name string, config *Config) float64 {
+// Return the multiplier/scale of image based on actual width vs default width,
+// resized by the requested output scale
+func getPixelMultiplier(name string, config *Config, scale float64) float64 {
 	multiplier := config.PixelMultiplier
 	if dimensions, found := config.Devices.ImageSizeOverride[name]; found {
 		multiplier = float64(dimensions.W) / float64(config.DefaultImage.W)
 	}
-	return multiplier
+	return multiplier * scale
+}
+
+// scaleImage returns the image resized by scale. A scale of 1 returns the
+// image as is. Large reductions are done by repeated halving, which averages
+// pixels like a box filter without the big buffers of the kernel scalers.
+func scaleImage(src image.Image, scale float64) *image.RGBA {
+	if rgba, ok := src.(*image.RGBA); ok && scale == 1 {
+		return rgba
+	}
+	width := int(math.Max(1, math.Round(float64(src.Bounds().Dx())*scale)))
+	height := int(math.Max(1, math.Round(float64(src.Bounds().Dy())*scale)))
+	for src.Bounds().Dx() >= 2*width && src.Bounds().Dy() >= 2*height {
+		src = resizeRGBA(src, src.Bounds().Dx()/2, src.Bounds().Dy()/2)
+	}
+	return resizeRGBA(src, width, height)
+}
+
+func resizeRGBA(src image.Image, width int, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	xdraw.ApproxBiLinear.Scale(dst, dst.Bounds(), src, src.Bounds(), xdraw.Src, nil)
+	return dst
 }
 
+// drawLeaderLine draws a line from the closest point on the input's box to
+// its anchor on the physical control
+func drawLeaderLine(dc *gg.Context, input InputData, leaderLine *LeaderLineData,
+	pixelMultiplier float64, imageFilename string, log *Logger) {
+	if len(leaderLine.Colour) == 0 {
+		return
+	}
+	anchor := *input.Anchor
+	if anchor.X < 0 || anchor.Y < 0 ||
+		anchor.X*pixelMultiplier >= float64(dc.Width()) ||
+		anchor.Y*pixelMultiplier >= float64(dc.Height()) {
+		log.ErrAs(ErrorImage, "Anchor outside bounds. File %s input %d,%d anchor %v",
+			imageFilename, input.X, input.Y, anchor)
+		return
+	}
+	start := Point2d{
+		X: math.Max(float64(input.X), math.Min(anchor.X, float64(input.X+input.W))),
+		Y: math.Max(float64(input.Y), math.Min(anchor.Y, float64(input.Y+input.H))),
+	}
+	if start == anchor {
+		log.ErrAs(ErrorImage, "Anchor inside input box. File %s input %d,%d anchor %v",
+			imageFilename, input.X, input.Y, anchor)
+		return
+	}
+
+	dc.SetHexColor(leaderLine.Colour)
+	dc.SetLineWidth(leaderLine.Width * pixelMultiplier)
+	dc.DrawLine(start.X*pixelMultiplier, start.Y*pixelMultiplier,
+		anchor.X*pixelMultiplier, anchor.Y*pixelMultiplier)
+	dc.Stroke()
+	if leaderLine.DotRadius > 0 {
+		dc.DrawCircle(anchor.X*pixelMultiplier, anchor.Y*pixelMultiplier,
+			leaderLine.DotRadius*pixelMultiplier)
+		dc.Fill()
+	}
+}
+
+// drawTextWithBackgroundRec draws text on a rounded rectangle and returns the
+// rectangle
 func drawTextWithBackgroundRec(dc *gg.Context, text string, xOffset float64,
 	location Point2d, xInset float64, yInset float64, targetHeight int,
 	pixelMultiplier float64, largeFont font.Face, smallFont font.Face,
-	backgroundColour string, textColour string) {
+	backgroundColour string, textColour string) image.Rectangle {
 	x := xOffset + (location.X+xInset)*pixelMultiplier
 	y := (location.Y + yInset) * pixelMultiplier
 	w, h := measureString(largeFont, text)
@@ -324,16 +491,15 @@ func drawTextWithBackgroundRec(dc *gg.Context, text string, xOffset float64,
 	// Decrease font size to fit nicely in the rectangle
 	dc.SetFontFace(smallFont) // Render one font size smaller to fit in rect
 	dc.DrawStringAnchored(text, x+float64(w-w2)/2, y+float64(h-h2)/2, 0, 0.83)
+	return image.Rect(int(math.Round(x)), int(math.Round(y)),
+		int(math.Round(x))+w, int(math.Round(y))+h)
 }
 
-func addImageHeader(dc *gg.Context, imageHeader *HeaderData, profile string,
-	label string, xOffset float64, pixelMultiplier float64, fontsDir string,
+// addImageHeader draws the header bar with the label shrunk to fit its width
+func addImageHeader(dc *gg.Context, imageHeader *HeaderData, label string,
+	xOffset float64, pixelMultiplier float64, fontsDir string,
 	minFontSize int, fontCache FontLoader) {
 	fontSize := int(math.Round(imageHeader.FontSize * pixelMultiplier))
-	// Add profile name to header if its not the MRC default
-	if profile != ProfileDefault {
-		label = fmt.Sprintf("%s (%s)", label, profile)
-	}
 	targetWidth := dc.Width() -
 		int(math.Round(xOffset+2*imageHeader.Inset.X*pixelMultiplier))
 	targetHeight := fontSize // Use fontSize as the targetHeight (max height)
@@ -360,9 +526,12 @@ func addImageHeader(dc *gg.Context, imageHeader *HeaderData, profile string,
 func addMRCLogo(dc *gg.Context, watermark *WatermarkData, version string, domain string,
 	xOffset float64, xInset float64, pixelMultiplier float64, fontsDir string,
 	fontCache FontLoader) {
+	if watermark.Hide {
+		return
+	}
 	fontSize := int(math.Round(watermark.FontSize * pixelMultiplier))
 	// Generate watermark
-	text := fmt.Sprintf("%s v%s (%s)", watermark.Text, version, domain)
+	text := watermarkText(watermark, version, domain)
 	var largeFont, smallFont font.Face
 	if fontCache != nil {
 		largeFont = fontCache.LoadFont(fontsDir, watermark.Font, fontSize)
@@ -378,3 +547,14 @@ func addMRCLogo(dc *gg.Context, watermark *WatermarkData, version string, domain
 		smallFont,
 		watermark.BackgroundColour, watermark.TextColour)
 }
+
+// watermarkText fills in the watermark's format, defaulting to
+// "{text} v{version} ({domain})"
+func watermarkText(watermark *WatermarkData, version string, domain string) string {
+	format := watermark.Format
+	if len(format) == 0 {
+		format = "{text} v{version} ({domain})"
+	}
+	return strings.NewReplacer("{text}", watermark.Text, "{version}", version,
+		"{domain}", domain).Replace(format)
+}
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"bytes"
 	"fmt"
 	"image"
 	"image/jpeg"
@@ -10,7 +11,6 @@ import (
 	"testing"
 
 	"github.com/fogleman/gg"
-	libjpeg "github.com/pixiv/go-libjpeg/jpeg"
 	"golang.org/x/image/font"
 	"golang.org/x/image/math/fixed"
 )
@@ -36,16 +36,64 @@ func TestGetPixelMultiplier(t *testing.T) {
 	}
 	
 	// Case 1: Default
-	m1 := getPixelMultiplier("normal", config)
+	m1 := getPixelMultiplier("normal", config, 1)
 	if m1 != 1.5 {
 		t.Errorf("Expected 1.5, got %f", m1)
 	}
 	
 	// Case 2: Override
-	m2 := getPixelMultiplier("override", config)
+	m2 := getPixelMultiplier("override", config, 1)
 	if m2 != 2.0 {
 		t.Errorf("Expected 2.0 (200/100), got %f", m2)
 	}
+
+	// Case 3: Requested output scale
+	if m3 := getPixelMultiplier("override", config, 0.5); m3 != 1.0 {
+		t.Errorf("Expected 1.0 (200/100 * 0.5), got %f", m3)
+	}
+}
+
+func TestScaleImage(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 100, 50))
+	if scaleImage(src, 1) != src {
+		t.Error("Scale of 1 should return the same image")
+	}
+	scaled := scaleImage(src, 0.5)
+	if scaled.Bounds().Dx() != 50 || scaled.Bounds().Dy() != 25 {
+		t.Errorf("Expected 50x25, got %v", scaled.Bounds())
+	}
+	if tiny := scaleImage(src, 0.001); tiny.Bounds().Dx() != 1 || tiny.Bounds().Dy() != 1 {
+		t.Errorf("Expected at least 1x1, got %v", tiny.Bounds())
+	}
+}
+
+func TestPopulateImage_Thumbnail(t *testing.T) {
+	config := &Config{
+		DefaultImage: Dimensions2d{W: 200, H: 200},
+		JpgQuality:   80,
+	}
+	log, _ := mockLogger()
+	_, thumbnail, _ := populateImage(gg.NewContext(200, 100), "img.jpg",
+		image.Point{X: 200, Y: 100}, 1.0, map[string]OverlayData{}, nil, config,
+		log, nil)
+	if thumbnail.Len() != 0 {
+		t.Error("Thumbnail should be empty when disabled")
+	}
+
+	config.ThumbnailWidth = 50
+	full, thumbnail, _ := populateImage(gg.NewContext(200, 100), "img.jpg",
+		image.Point{X: 200, Y: 100}, 1.0, map[string]OverlayData{}, nil, config,
+		log, nil)
+	img, err := jpeg.Decode(&thumbnail)
+	if err != nil {
+		t.Fatalf("Thumbnail decode failed: %v", err)
+	}
+	if img.Bounds().Dx() != 50 || img.Bounds().Dy() != 25 {
+		t.Errorf("Expected 50x25 thumbnail, got %v", img.Bounds())
+	}
+	if full.Len() <= thumbnail.Len() {
+		t.Error("Thumbnail should be smaller than the full image")
+	}
 }
 
 func TestPrepareContexts(t *testing.T) {
@@ -255,7 +303,7 @@ func TestPopulateImage(t *testing.T) {
 	loader := NewFontFaceCache()
 	
 	// Test
-	buf := populateImage(dc, "img.jpg", image.Point{X: 100, Y: 100}, 1.0, overlays, categories, config, log, loader)
+	buf, _, _ := populateImage(dc, "img.jpg", image.Point{X: 100, Y: 100}, 1.0, overlays, categories, config, log, loader)
 	
 	if buf.Len() == 0 {
 		t.Error("Buffer empty")
@@ -310,7 +358,7 @@ func TestPopulateImage_SkipBadLocation(t *testing.T) {
 	loader := NewFontFaceCache()
 	
 	// Should not panic and should return a valid buffer (empty image)
-	buf := populateImage(dc, "img.jpg", image.Point{X: 100, Y: 100}, 1.0, overlays, categories, config, log, loader)
+	buf, _, _ := populateImage(dc, "img.jpg", image.Point{X: 100, Y: 100}, 1.0, overlays, categories, config, log, loader)
 	
 	if buf.Len() == 0 {
 		t.Error("Buffer should not be empty")
@@ -343,13 +391,56 @@ func TestPopulateImage_MultipleTexts(t *testing.T) {
 	log, _ := mockLogger()
 	loader := NewFontFaceCache()
 	
-	buf := populateImage(dc, "img.jpg", image.Point{X: 200, Y: 200}, 1.0, overlays, categories, config, log, loader)
+	buf, _, _ := populateImage(dc, "img.jpg", image.Point{X: 200, Y: 200}, 1.0, overlays, categories, config, log, loader)
 	
 	if buf.Len() == 0 {
 		t.Error("Buffer should not be empty")
 	}
 }
 
+func TestPopulateImage_Chips(t *testing.T) {
+	overlays := map[string]OverlayData{
+		"btn": {PosAndSize: InputData{X: 10, Y: 20, W: 180, H: 30},
+			ContextToTexts: map[string][]string{"ctx": {"Alpha", "Beta"}},
+			ContextToActions: map[string][]OverlayAction{"ctx": {
+				{Text: "Beta", Action: "BETA", Primary: "Button 2"},
+				{Text: "Alpha", Action: "ALPHA", Primary: "Button 1"}}}},
+	}
+	config := &Config{
+		InputPixelXInset: 1,
+		InputPixelYInset: 1,
+		JpgQuality:       80,
+		FontsDir:         "../../resources/fonts",
+		InputFont:        "Dirga.ttf",
+		InputMinFontSize: 5,
+		LightColour:      "#000000",
+		InputFontSize:    20,
+	}
+	log, _ := mockLogger()
+	_, _, cardChips := populateImage(gg.NewContext(200, 100), "img.jpg",
+		image.Point{X: 200, Y: 100}, 1.0, overlays,
+		map[string]string{"ctx": "#ff0000"}, config, log, NewFontFaceCache())
+
+	if cardChips.Width != 200 || cardChips.Height != 100 {
+		t.Errorf("Expected 200x100 card, got %dx%d", cardChips.Width,
+			cardChips.Height)
+	}
+	if len(cardChips.Chips) != 2 {
+		t.Fatalf("Expected 2 chips, got %+v", cardChips.Chips)
+	}
+	alpha, beta := cardChips.Chips[0], cardChips.Chips[1]
+	if len(alpha.Actions) != 1 || alpha.Actions[0].Action != "ALPHA" ||
+		alpha.Context != "ctx" {
+		t.Errorf("Unexpected first chip %+v", alpha)
+	}
+	// Chips are drawn left to right inside the input box
+	box := image.Rect(11, 21, 189, 49)
+	if !alpha.Rect.In(box) || !beta.Rect.In(box) ||
+		beta.Rect.Min.X < alpha.Rect.Max.X {
+		t.Errorf("Unexpected chip positions %v %v", alpha.Rect, beta.Rect)
+	}
+}
+
 func TestDecodeJpg_Error(t *testing.T) {
 	log, _ := mockLogger()
 	_, err := decodeJpg("nonexistent.jpg", log)
@@ -411,7 +502,7 @@ func TestGenerateImages(t *testing.T) {
 	log, _ := mockLogger()
 	
 	// Run
-	files, size := GenerateImages(overlays, categories, "game", config, log)
+	files, _, _, size := GenerateImages(overlays, nil, categories, "game", config, log, nil)
 	
 	// Verify
 	if len(files) != 1 {
@@ -422,6 +513,43 @@ func TestGenerateImages(t *testing.T) {
 	}
 }
 
+func TestGenerateImages_WidthWithOverride(t *testing.T) {
+	tmpDir := t.TempDir()
+	createDummyJpg(t, filepath.Join(tmpDir, "game.jpg"))
+	createDummyJpg(t, filepath.Join(tmpDir, "dev.jpg"))
+	// A device image twice the default size
+	wide := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	f, err := os.Create(filepath.Join(tmpDir, "wide.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jpeg.Encode(f, wide, nil)
+	f.Close()
+	config := &Config{LogoImagesDir: tmpDir, HotasImagesDir: tmpDir,
+		FontsDir: testFontsDir, InputFont: "Dirga.ttf", InputFontSize: 12,
+		InputMinFontSize: 5, DefaultImage: Dimensions2d{W: 100, H: 100},
+		PixelMultiplier: 1.0,
+		ImageHeader:     HeaderData{Font: "Dirga.ttf", FontSize: 14},
+		Watermark:       WatermarkData{Font: "Dirga.ttf", FontSize: 10},
+		Devices: Devices{ImageSizeOverride: map[string]Dimensions2d{
+			"wide": {W: 200, H: 100}}},
+	}
+	overlays := OverlaysByProfile{"Default": OverlaysByImage{"dev": {}, "wide": {}}}
+	log, _ := mockLogger()
+	files, _, _, _ := GenerateImages(overlays, nil, nil, "game", config, log,
+		&RequestOptions{Width: 50})
+	if len(files) != 2 {
+		t.Fatalf("Expected 2 cards, got %d", len(files))
+	}
+	for idx, file := range files {
+		decoded, err := jpeg.DecodeConfig(bytes.NewReader(file.Bytes()))
+		if err != nil || decoded.Width != 50 {
+			t.Errorf("Expected card %d 50 pixels wide, got %d %v", idx,
+				decoded.Width, err)
+		}
+	}
+}
+
 func createDummyJpg(t *testing.T, path string) {
 	dc := gg.NewContext(100, 100)
 	dc.DrawRectangle(0, 0, 100, 100)
@@ -534,7 +662,7 @@ func TestGenerateImages_MissingLogo(t *testing.T) {
 	log, _ := mockLogger()
 
 	// Run - should return empty due to missing logo
-	files, size := GenerateImages(overlays, categories, "missing_game", config, log)
+	files, _, _, size := GenerateImages(overlays, nil, categories, "missing_game", config, log, nil)
 
 	if len(files) != 0 {
 		t.Errorf("Expected 0 files when logo is missing, got %d", len(files))
@@ -586,7 +714,7 @@ func TestGenerateImages_MissingDeviceImage(t *testing.T) {
 	log, _ := mockLogger()
 
 	// Run - the goroutine should log error and return early
-	files, size := GenerateImages(overlays, categories, "game", config, log)
+	files, _, _, size := GenerateImages(overlays, nil, categories, "game", config, log, nil)
 
 	// Files slice is pre-allocated, but the entry should be empty
 	if len(files) != 1 {
@@ -618,7 +746,7 @@ func TestAddImageHeader_NilCache(t *testing.T) {
 	}
 
 	// Call with nil fontCache - should use loadFont directly
-	addImageHeader(dc, header, ProfileDefault, "Test Device", 50, 1.0, fontDir, 5, nil)
+	addImageHeader(dc, header, "Test Device", 50, 1.0, fontDir, 5, nil)
 
 	// If no panic, success
 }
@@ -642,8 +770,8 @@ func TestAddImageHeader_CustomProfile(t *testing.T) {
 
 	cache := NewFontFaceCache()
 
-	// Call with custom profile (not ProfileDefault) to trigger label formatting
-	addImageHeader(dc, header, "CustomProfile", "Test Device", 50, 1.0, fontDir, 5, cache)
+	// Call with a profile in the label
+	addImageHeader(dc, header, "Test Device (CustomProfile)", 50, 1.0, fontDir, 5, cache)
 
 	// If no panic, success
 }
@@ -654,7 +782,7 @@ func TestPopulateImage_JpegEncodeError(t *testing.T) {
 	defer func() { jpegEncoderFunc = originalEncoder }()
 	
 	// Inject a failing encoder
-	jpegEncoderFunc = func(w io.Writer, m image.Image, o *libjpeg.EncoderOptions) error {
+	jpegEncoderFunc = func(w io.Writer, m image.Image, quality int) error {
 		return fmt.Errorf("mock jpeg encode error")
 	}
 	
@@ -682,7 +810,7 @@ func TestPopulateImage_JpegEncodeError(t *testing.T) {
 	loader := NewFontFaceCache()
 	
 	// This should trigger the error path
-	buf := populateImage(dc, "img.jpg", image.Point{X: 200, Y: 200}, 1.0, overlays, categories, config, log, loader)
+	buf, _, _ := populateImage(dc, "img.jpg", image.Point{X: 200, Y: 200}, 1.0, overlays, categories, config, log, loader)
 	
 	// Buffer should be empty since encode failed
 	if buf.Len() != 0 {
@@ -701,3 +829,65 @@ func TestPopulateImage_JpegEncodeError(t *testing.T) {
 		t.Error("Expected error to be logged for jpeg encode failure")
 	}
 }
+
+func TestDrawLeaderLine(t *testing.T) {
+	leaderLine := &LeaderLineData{Colour: "#ff0000ff", Width: 2, DotRadius: 3}
+	input := InputData{X: 10, Y: 10, W: 20, H: 10, Anchor: &Point2d{X: 80, Y: 15}}
+	log, _ := mockLogger()
+
+	dc := gg.NewContext(100, 100)
+	drawLeaderLine(dc, input, leaderLine, 1.0, "img.jpg", log)
+	// Line runs from the right edge of the box to the anchor
+	for _, x := range []int{40, 60, 80} {
+		if r, _, _, _ := dc.Image().At(x, 15).RGBA(); r == 0 {
+			t.Errorf("Expected leader line at %d,15", x)
+		}
+	}
+	if len(log.Entries) != 0 {
+		t.Errorf("Unexpected log entries %v", log.Entries)
+	}
+
+	// Disabled draws nothing
+	dc = gg.NewContext(100, 100)
+	drawLeaderLine(dc, input, &LeaderLineData{}, 1.0, "img.jpg", log)
+	if _, _, _, a := dc.Image().At(60, 15).RGBA(); a != 0 {
+		t.Error("Expected nothing drawn when disabled")
+	}
+}
+
+func TestDrawLeaderLine_BadAnchor(t *testing.T) {
+	leaderLine := &LeaderLineData{Colour: "#ff0000ff", Width: 2}
+	for _, anchor := range []Point2d{{X: 200, Y: 15}, {X: -1, Y: 15}, {X: 15, Y: 15}} {
+		log, _ := mockLogger()
+		input := InputData{X: 10, Y: 10, W: 20, H: 10, Anchor: &anchor}
+		drawLeaderLine(gg.NewContext(100, 100), input, leaderLine, 1.0,
+			"img.jpg", log)
+		if len(log.Entries) != 1 || !log.Entries[0].IsError {
+			t.Errorf("Expected error for anchor %v", anchor)
+		}
+	}
+}
+
+func TestWatermarkText(t *testing.T) {
+	watermark := &WatermarkData{Text: "MetaRefCard"}
+	if text := watermarkText(watermark, "1.2", "example.com"); text != "MetaRefCard v1.2 (example.com)" {
+		t.Errorf("Unexpected default watermark %q", text)
+	}
+	watermark.Format = "{text} - {domain}"
+	if text := watermarkText(watermark, "1.2", "example.com"); text != "MetaRefCard - example.com" {
+		t.Errorf("Unexpected formatted watermark %q", text)
+	}
+}
+
+func TestAddMRCLogo_Hide(t *testing.T) {
+	dc := gg.NewContext(100, 50)
+	dc.SetHexColor("#336699")
+	dc.Clear()
+	before := bytes.Clone(dc.Image().(*image.RGBA).Pix)
+	// Hidden watermarks don't load fonts or draw anything
+	addMRCLogo(dc, &WatermarkData{Hide: true, Font: "missing.ttf", FontSize: 10,
+		BackgroundColour: "#ffffff"}, "1.0", "example.com", 0, 0, 1.0, "missing", nil)
+	if !bytes.Equal(dc.Image().(*image.RGBA).Pix, before) {
+		t.Fatal("Expected nothing drawn for a hidden watermark")
+	}
+}
@@ -0,0 +1,149 @@
+package common
+
+import (
+	"container/list"
+	"image"
+	"os"
+	"sync"
+	"time"
+)
+
+// imageCache holds decoded device and logo images shared across requests
+var imageCache = NewImageCache(0)
+
+// ImageCache is a bounded, least recently used cache of decoded JPEG images.
+// Entries are keyed by path and dropped when the file's modification time
+// changes. Callers get their own copy of the image to draw on.
+type ImageCache struct {
+	lock      sync.Mutex
+	maxBytes  int64
+	usedBytes int64
+	entries   map[string]*list.Element
+	order     *list.List            // Most recently used at the front
+	loading   map[string]*imageLoad // Decodes in progress by path
+	hits      int64
+	misses    int64
+}
+
+type imageCacheEntry struct {
+	path    string
+	modTime time.Time
+	image   *image.RGBA
+}
+
+// imageLoad is a decode that requests missing the same image wait for
+type imageLoad struct {
+	done  chan struct{} // Closed once decoded
+	image *image.RGBA
+	err   error
+}
+
+// NewImageCache returns a cache holding at most maxBytes of decoded images.
+// A maxBytes of 0 disables caching.
+func NewImageCache(maxBytes int64) *ImageCache {
+	return &ImageCache{
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		loading:  make(map[string]*imageLoad),
+	}
+}
+
+// SetMaxBytes changes the cache size, evicting images that no longer fit
+func (c *ImageCache) SetMaxBytes(maxBytes int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.maxBytes = maxBytes
+	c.evict(0)
+}
+
+// Stats returns the number of cache hits, misses and bytes used
+func (c *ImageCache) Stats() (hits int64, misses int64, usedBytes int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.hits, c.misses, c.usedBytes
+}
+
+// Load returns a copy of the decoded image, decoding and caching it on a miss.
+// Concurrent misses for the same image wait for one decode.
+func (c *ImageCache) Load(path string, log *Logger) (*image.RGBA, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		log.ErrAs(ErrorImage, "failed to open: %v", err)
+		return nil, err
+	}
+
+	c.lock.Lock()
+	if element, found := c.entries[path]; found {
+		entry := element.Value.(*imageCacheEntry)
+		if entry.modTime.Equal(info.ModTime()) {
+			c.hits++
+			c.order.MoveToFront(element)
+			c.lock.Unlock()
+			return copyRGBA(entry.image), nil
+		}
+		c.remove(element)
+	}
+	if load, found := c.loading[path]; found {
+		c.lock.Unlock()
+		<-load.done
+		if load.err != nil {
+			return nil, load.err
+		}
+		return copyRGBA(load.image), nil
+	}
+	c.misses++
+	load := &imageLoad{done: make(chan struct{})}
+	c.loading[path] = load
+	c.lock.Unlock()
+
+	load.image, load.err = decodeJpg(path, log)
+	if load.err == nil {
+		c.add(&imageCacheEntry{path: path, modTime: info.ModTime(), image: load.image})
+	}
+	c.lock.Lock()
+	delete(c.loading, path)
+	c.lock.Unlock()
+	close(load.done)
+	if load.err != nil {
+		return nil, load.err
+	}
+	return copyRGBA(load.image), nil
+}
+
+// add stores the entry if it fits, evicting least recently used images
+func (c *ImageCache) add(entry *imageCacheEntry) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	size := int64(len(entry.image.Pix))
+	if size > c.maxBytes {
+		return
+	}
+	// Another request may have decoded the same image
+	if element, found := c.entries[entry.path]; found {
+		c.remove(element)
+	}
+	c.evict(size)
+	c.entries[entry.path] = c.order.PushFront(entry)
+	c.usedBytes += size
+}
+
+// evict removes least recently used images until size more bytes would fit
+func (c *ImageCache) evict(size int64) {
+	for c.usedBytes+size > c.maxBytes && c.order.Len() > 0 {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *ImageCache) remove(element *list.Element) {
+	entry := c.order.Remove(element).(*imageCacheEntry)
+	delete(c.entries, entry.path)
+	c.usedBytes -= int64(len(entry.image.Pix))
+}
+
+// copyRGBA returns a copy of the image that can be drawn on
+func copyRGBA(src *image.RGBA) *image.RGBA {
+	pix := make([]uint8, len(src.Pix))
+	copy(pix, src.Pix)
+	return &image.RGBA{Pix: pix, Stride: src.Stride, Rect: src.Rect}
+}
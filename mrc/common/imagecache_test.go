@@ -0,0 +1,119 @@
+package common
+
+import (
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestImageCache_Load(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "img.jpg")
+	createDummyJpg(t, path)
+	cache := NewImageCache(1 << 20)
+	log, _ := mockLogger()
+
+	first, err := cache.Load(path, log)
+	if err != nil || first == nil {
+		t.Fatalf("Load failed %v", err)
+	}
+	// Drawing on a copy must not change the cached image
+	first.Set(0, 0, color.RGBA{255, 0, 0, 255})
+	second, _ := cache.Load(path, log)
+	if second.RGBAAt(0, 0) == first.RGBAAt(0, 0) {
+		t.Error("Expected an unmodified copy")
+	}
+	if hits, misses, used := cache.Stats(); hits != 1 || misses != 1 ||
+		used != int64(len(second.Pix)) {
+		t.Errorf("Unexpected stats hits %d misses %d used %d", hits, misses, used)
+	}
+
+	// A changed file is decoded again
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(path, later, later)
+	cache.Load(path, log)
+	if hits, misses, _ := cache.Stats(); hits != 1 || misses != 2 {
+		t.Errorf("Expected miss after file changed, hits %d misses %d", hits, misses)
+	}
+}
+
+func TestImageCache_ConcurrentMisses(t *testing.T) {
+	// Large enough that the decodes overlap
+	path := filepath.Join(t.TempDir(), "img.jpg")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jpeg.Encode(f, image.NewRGBA(image.Rect(0, 0, 2000, 2000)), nil)
+	f.Close()
+	cache := NewImageCache(64 << 20)
+	log, _ := mockLogger()
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if img, err := cache.Load(path, log); err != nil || img == nil {
+				t.Errorf("Load failed %v", err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	// Requests missing at the same time wait for the one decode
+	if hits, misses, _ := cache.Stats(); misses != 1 {
+		t.Errorf("Expected one decode, hits %d misses %d", hits, misses)
+	}
+}
+
+func TestImageCache_Evict(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{filepath.Join(dir, "a.jpg"), filepath.Join(dir, "b.jpg")}
+	for _, path := range paths {
+		createDummyJpg(t, path)
+	}
+	log, _ := mockLogger()
+	// Room for one 100x100 image
+	cache := NewImageCache(100 * 100 * 4)
+
+	cache.Load(paths[0], log)
+	cache.Load(paths[1], log) // Evicts a.jpg
+	cache.Load(paths[1], log)
+	cache.Load(paths[0], log)
+	if hits, misses, used := cache.Stats(); hits != 1 || misses != 3 ||
+		used != 100*100*4 {
+		t.Errorf("Unexpected stats hits %d misses %d used %d", hits, misses, used)
+	}
+
+	cache.SetMaxBytes(0)
+	if _, _, used := cache.Stats(); used != 0 {
+		t.Errorf("Expected empty cache, using %d", used)
+	}
+	cache.Load(paths[0], log)
+	if hits, _, _ := cache.Stats(); hits != 1 {
+		t.Error("Expected disabled cache to miss")
+	}
+}
+
+func TestImageCache_Errors(t *testing.T) {
+	cache := NewImageCache(1 << 20)
+	log, _ := mockLogger()
+	if _, err := cache.Load("nonexistent.jpg", log); err == nil {
+		t.Error("Expected error for missing file")
+	}
+
+	path := filepath.Join(t.TempDir(), "bad.jpg")
+	os.WriteFile(path, []byte("not a jpeg"), 0644)
+	if _, err := cache.Load(path, log); err == nil {
+		t.Error("Expected error for invalid file")
+	}
+	if _, _, used := cache.Stats(); used != 0 {
+		t.Error("Expected nothing cached")
+	}
+}
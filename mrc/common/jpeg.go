@@ -0,0 +1,36 @@
+package common
+
+import (
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"io"
+)
+
+// JpegEncoder is the function type for encoding images to JPEG.
+// It's a package-level variable to allow injection during testing.
+type JpegEncoder func(w io.Writer, m image.Image, quality int) error
+
+// JpegDecoder is the function type for decoding JPEGs into RGBA images
+type JpegDecoder func(r io.Reader) (*image.RGBA, error)
+
+// encodeStdJpeg encodes using the pure Go image/jpeg package
+func encodeStdJpeg(w io.Writer, m image.Image, quality int) error {
+	return jpeg.Encode(w, m, &jpeg.Options{Quality: quality})
+}
+
+// decodeStdJpeg decodes using the pure Go image/jpeg package. JPEGs decode to
+// YCbCr so they are converted to RGBA to be drawn on.
+func decodeStdJpeg(r io.Reader) (*image.RGBA, error) {
+	decoded, err := jpeg.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	if rgba, ok := decoded.(*image.RGBA); ok {
+		return rgba, nil
+	}
+	bounds := decoded.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), decoded, bounds.Min, draw.Src)
+	return rgba, nil
+}
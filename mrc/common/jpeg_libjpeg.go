@@ -0,0 +1,29 @@
+//go:build cgo && !purego
+
+package common
+
+import (
+	"image"
+	"io"
+
+	"github.com/pixiv/go-libjpeg/jpeg"
+)
+
+// JpegCodec names the JPEG implementation built in
+const JpegCodec = "libjpeg"
+
+// jpegEncoderFunc is the default JPEG encoder (can be replaced in tests)
+var jpegEncoderFunc JpegEncoder = encodeLibJpeg
+
+// jpegDecoderFunc is the default JPEG decoder
+var jpegDecoderFunc JpegDecoder = decodeLibJpeg
+
+// encodeLibJpeg encodes using libjpeg-turbo
+func encodeLibJpeg(w io.Writer, m image.Image, quality int) error {
+	return jpeg.Encode(w, m, &jpeg.EncoderOptions{Quality: quality})
+}
+
+// decodeLibJpeg decodes straight into RGBA using libjpeg-turbo
+func decodeLibJpeg(r io.Reader) (*image.RGBA, error) {
+	return jpeg.DecodeIntoRGBA(r, &jpeg.DecoderOptions{})
+}
@@ -0,0 +1,45 @@
+//go:build cgo && !purego
+
+package common
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDecodeLibJpeg_MatchesStd(t *testing.T) {
+	open := func() *os.File {
+		file, err := os.Open(benchJpeg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	libFile, stdFile := open(), open()
+	defer libFile.Close()
+	defer stdFile.Close()
+	lib, err := decodeLibJpeg(libFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	std, err := decodeStdJpeg(stdFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lib.Bounds() != std.Bounds() {
+		t.Fatalf("Bounds differ %v %v", lib.Bounds(), std.Bounds())
+	}
+	// Decoders may round differently but should be visually the same
+	for _, p := range []struct{ x, y int }{{10, 10}, {500, 300}, {1000, 700}} {
+		l, s := lib.RGBAAt(p.x, p.y), std.RGBAAt(p.x, p.y)
+		for _, diff := range []int{int(l.R) - int(s.R), int(l.G) - int(s.G),
+			int(l.B) - int(s.B)} {
+			if diff > 8 || diff < -8 {
+				t.Errorf("Pixel %v differs %v %v", p, l, s)
+			}
+		}
+	}
+}
+
+func BenchmarkJpegDecode_Libjpeg(b *testing.B) { benchmarkDecode(b, decodeLibJpeg) }
+func BenchmarkJpegEncode_Libjpeg(b *testing.B) { benchmarkEncode(b, encodeLibJpeg) }
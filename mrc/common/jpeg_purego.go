@@ -0,0 +1,12 @@
+//go:build !cgo || purego
+
+package common
+
+// JpegCodec names the JPEG implementation built in
+const JpegCodec = "image/jpeg"
+
+// jpegEncoderFunc is the default JPEG encoder (can be replaced in tests)
+var jpegEncoderFunc JpegEncoder = encodeStdJpeg
+
+// jpegDecoderFunc is the default JPEG decoder
+var jpegDecoderFunc JpegDecoder = decodeStdJpeg
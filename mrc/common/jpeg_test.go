@@ -0,0 +1,80 @@
+package common
+
+import (
+	"bytes"
+	"image"
+	"os"
+	"testing"
+)
+
+const benchJpeg = "../../resources/hotas-images/warthog.jpg"
+
+func TestDecodeStdJpeg(t *testing.T) {
+	file, err := os.Open(benchJpeg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	decoded, err := decodeStdJpeg(file)
+	if err != nil {
+		t.Fatalf("Decode failed %v", err)
+	}
+	if decoded.Bounds().Min != (image.Point{}) || decoded.Bounds().Dx() == 0 {
+		t.Errorf("Unexpected bounds %v", decoded.Bounds())
+	}
+	// Opaque image should stay opaque after conversion
+	if decoded.RGBAAt(10, 10).A != 255 {
+		t.Error("Expected opaque pixels")
+	}
+
+	if _, err := decodeStdJpeg(bytes.NewReader([]byte("not a jpeg"))); err == nil {
+		t.Error("Expected error for invalid jpeg")
+	}
+}
+
+func TestEncodeStdJpeg(t *testing.T) {
+	var buf bytes.Buffer
+	if err := encodeStdJpeg(&buf, image.NewRGBA(image.Rect(0, 0, 20, 10)), 80); err != nil {
+		t.Fatalf("Encode failed %v", err)
+	}
+	decoded, err := decodeStdJpeg(&buf)
+	if err != nil || decoded.Bounds().Dx() != 20 || decoded.Bounds().Dy() != 10 {
+		t.Errorf("Round trip failed %v", err)
+	}
+}
+
+// benchmarkDecode and benchmarkEncode are shared with the libjpeg benchmarks
+// so `go test -bench Jpeg ./mrc/common` compares the codecs side by side
+func benchmarkDecode(b *testing.B, decode JpegDecoder) {
+	data, err := os.ReadFile(benchJpeg)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := decode(bytes.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkEncode(b *testing.B, encode JpegEncoder) {
+	data, err := os.ReadFile(benchJpeg)
+	if err != nil {
+		b.Fatal(err)
+	}
+	decoded, err := decodeStdJpeg(bytes.NewReader(data))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var buf bytes.Buffer
+		if err := encode(&buf, decoded, 90); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkJpegDecode_Std(b *testing.B) { benchmarkDecode(b, decodeStdJpeg) }
+func BenchmarkJpegEncode_Std(b *testing.B) { benchmarkEncode(b, encodeStdJpeg) }
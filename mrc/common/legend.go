@@ -0,0 +1,193 @@
+package common
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/fogleman/gg"
+	"golang.org/x/image/font"
+)
+
+const (
+	// LegendTopLeft - legend in the top left corner, below the header
+	LegendTopLeft = "TopLeft"
+	// LegendTopRight - legend in the top right corner, below the header
+	LegendTopRight = "TopRight"
+	// LegendBottomLeft - legend in the bottom left corner
+	LegendBottomLeft = "BottomLeft"
+	// LegendBottomRight - legend in the bottom right corner
+	LegendBottomRight = "BottomRight"
+	// LegendFooter - legend in rows along the bottom of the image
+	LegendFooter = "Footer"
+)
+
+// imageContexts returns the sorted contexts that will be drawn on an image
+func imageContexts(overlayDataRange map[string]OverlayData) []string {
+	seen := make(Set)
+	for _, overlayData := range overlayDataRange {
+		// Skip known bad locations, they aren't drawn
+		if overlayData.PosAndSize.X == -1 || overlayData.PosAndSize.Y == -1 {
+			continue
+		}
+		for context := range overlayData.ContextToTexts {
+			seen[context] = true
+		}
+	}
+	contexts := seen.Keys()
+	sort.Strings(contexts)
+	return contexts
+}
+
+// legendLabel makes a game context name readable e.g. COCKPIT_CAMERA -> Cockpit Camera
+func legendLabel(context string) string {
+	return TitleCaser(strings.ReplaceAll(context, "_", " "))
+}
+
+// addLegend draws a swatch and name for each context on the image so the
+// reader knows what each colour means. Contexts hidden by the request are
+// listed in a final row without a swatch.
+func addLegend(dc *gg.Context, legend *LegendData, contexts []string,
+	hidden []string, categories map[string]string, headerHeight float64,
+	pixelMultiplier float64, fontsDir string, minFontSize int,
+	fontCache FontLoader) {
+	if len(legend.Location) == 0 || len(contexts)+len(hidden) == 0 {
+		return
+	}
+
+	// Each entry is a label with a swatch colour. Empty colour means no swatch
+	labels := make([]string, 0, len(contexts)+1)
+	colours := make([]string, 0, len(contexts)+1)
+	for _, context := range contexts {
+		labels = append(labels, legendLabel(context))
+		colours = append(colours, categories[context])
+	}
+	if len(hidden) > 0 {
+		hiddenLabels := make([]string, len(hidden))
+		for idx, context := range hidden {
+			hiddenLabels[idx] = legendLabel(context)
+		}
+		labels = append(labels, fmt.Sprintf("Hidden: %s",
+			strings.Join(hiddenLabels, ", ")))
+		colours = append(colours, "")
+	}
+
+	rowHeight := int(math.Round(legend.FontSize * pixelMultiplier))
+	swatch := float64(rowHeight)
+	padding := swatch / 2
+	insetX := legend.Inset.X * pixelMultiplier
+	insetY := legend.Inset.Y * pixelMultiplier
+	footer := legend.Location == LegendFooter
+	swatchWidth := func(idx int) float64 {
+		if len(colours[idx]) == 0 {
+			return 0
+		}
+		return swatch + padding
+	}
+
+	// Work out the font sizes that fit the labels in the available width. The
+	// context rows share a size and the hidden row is sized on its own.
+	fontSizes := make([]int, len(labels))
+	rowWidth := math.Max(0, float64(dc.Width())-2*insetX-2*padding)
+	if footer {
+		// Shrink the font to fit one row. At the min font size, entries that
+		// still don't fit wrap onto more rows.
+		var entriesWidth float64
+		for idx := range labels {
+			entriesWidth += swatchWidth(idx)
+		}
+		spacing := float64(len(labels)-1) * swatch
+		targetWidth := int(math.Max(0, rowWidth-entriesWidth-spacing))
+		fontSize := calcFontSize(strings.Join(labels, ""), fontCache, rowHeight,
+			targetWidth, rowHeight, fontsDir, legend.Font, minFontSize)
+		for idx := range labels {
+			fontSizes[idx] = fontSize
+		}
+	} else {
+		contextFontSize := rowHeight
+		for idx, label := range labels {
+			targetWidth := int(math.Round(legend.MaxWidth*pixelMultiplier -
+				2*padding - swatchWidth(idx)))
+			fontSizes[idx] = calcFontSize(label, fontCache, rowHeight, targetWidth,
+				rowHeight, fontsDir, legend.Font, minFontSize)
+			if idx < len(contexts) && fontSizes[idx] < contextFontSize {
+				contextFontSize = fontSizes[idx]
+			}
+		}
+		for idx := range contexts {
+			fontSizes[idx] = contextFontSize
+		}
+	}
+	fonts := make([]font.Face, len(labels))
+	textWidths := make([]float64, len(labels))
+	var maxEntryWidth float64
+	for idx, label := range labels {
+		if fontCache != nil {
+			fonts[idx] = fontCache.LoadFont(fontsDir, legend.Font, fontSizes[idx])
+		} else {
+			fonts[idx] = loadFont(fontsDir, legend.Font, fontSizes[idx])
+		}
+		w, _ := measureString(fonts[idx], label)
+		textWidths[idx] = float64(w)
+		maxEntryWidth = math.Max(maxEntryWidth, swatchWidth(idx)+float64(w))
+	}
+
+	// Lay out the entries relative to the panel's top left corner. The corners
+	// list an entry per row, the footer fills each row before the next.
+	entryXs := make([]float64, len(labels))
+	entryYs := make([]float64, len(labels))
+	entryX, entryY := padding, padding
+	for idx := range labels {
+		entryWidth := swatchWidth(idx) + textWidths[idx]
+		if footer && entryX > padding && entryX-padding+entryWidth > rowWidth {
+			entryX = padding
+			entryY += swatch + padding/2
+		}
+		entryXs[idx], entryYs[idx] = entryX, entryY
+		if footer {
+			entryX += entryWidth + swatch
+		} else {
+			entryY += swatch + padding/2
+		}
+	}
+	if footer {
+		entryY += swatch + padding/2
+	}
+
+	// Size and place the panel
+	panelW := 2*padding + maxEntryWidth
+	if footer {
+		panelW = float64(dc.Width()) - 2*insetX
+	}
+	panelH := entryY - padding/2 + padding
+	x := insetX
+	y := float64(dc.Height()) - insetY - panelH
+	switch legend.Location {
+	case LegendTopLeft:
+		y = headerHeight + insetY
+	case LegendTopRight:
+		x = float64(dc.Width()) - insetX - panelW
+		y = headerHeight + insetY
+	case LegendBottomRight:
+		x = float64(dc.Width()) - insetX - panelW
+	}
+
+	dc.SetHexColor(legend.BackgroundColour)
+	dc.DrawRoundedRectangle(x, y, panelW, panelH, 6)
+	dc.Fill()
+
+	for idx, label := range labels {
+		entryX, entryY := x+entryXs[idx], y+entryYs[idx]
+		if len(colours[idx]) > 0 {
+			dc.SetHexColor(colours[idx])
+			dc.DrawRoundedRectangle(entryX, entryY, swatch, swatch, 6)
+			dc.Fill()
+		}
+		_, textHeight := measureString(fonts[idx], label)
+		dc.SetFontFace(fonts[idx])
+		dc.SetHexColor(legend.TextColour)
+		dc.DrawStringAnchored(label, entryX+swatchWidth(idx),
+			entryY+(swatch-float64(textHeight))/2, 0, 0.83)
+	}
+}
@@ -0,0 +1,124 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/fogleman/gg"
+)
+
+func TestImageContexts(t *testing.T) {
+	overlays := map[string]OverlayData{
+		"a": {PosAndSize: InputData{X: 10, Y: 10},
+			ContextToTexts: map[string][]string{"PLANE": {"x"}, "CAMERA": {"y"}}},
+		"b": {PosAndSize: InputData{X: 20, Y: 20},
+			ContextToTexts: map[string][]string{"PLANE": {"z"}}},
+		"skipped": {PosAndSize: InputData{X: -1, Y: 10},
+			ContextToTexts: map[string][]string{"MENU": {"m"}}},
+	}
+	contexts := imageContexts(overlays)
+	if len(contexts) != 2 || contexts[0] != "CAMERA" || contexts[1] != "PLANE" {
+		t.Errorf("Unexpected contexts %v", contexts)
+	}
+}
+
+func TestLegendLabel(t *testing.T) {
+	if label := legendLabel("COCKPIT_CAMERA"); label != "Cockpit Camera" {
+		t.Errorf("Expected Cockpit Camera, got %s", label)
+	}
+}
+
+func TestAddLegend(t *testing.T) {
+	categories := map[string]string{"PLANE": "#ff0000ff", "CAMERA": "#00ff00ff"}
+	for _, location := range []string{LegendTopLeft, LegendTopRight,
+		LegendBottomLeft, LegendBottomRight, LegendFooter} {
+		dc := gg.NewContext(400, 200)
+		dc.SetHexColor("#000000ff")
+		dc.Clear()
+		legend := &LegendData{
+			Location:         location,
+			Font:             "Dirga.ttf",
+			FontSize:         20,
+			Inset:            Point2d{X: 5, Y: 5},
+			MaxWidth:         150,
+			TextColour:       "#000000ff",
+			BackgroundColour: "#ffffffff",
+		}
+		addLegend(dc, legend, []string{"CAMERA", "PLANE"}, nil, categories, 20, 1.0,
+			"../../resources/fonts", 5, NewFontFaceCache())
+
+		// The panel is drawn inside the inset of the expected corner
+		var x, y int
+		switch location {
+		case LegendTopLeft:
+			x, y = 8, 28
+		case LegendTopRight:
+			x, y = 391, 28
+		case LegendBottomRight:
+			x, y = 391, 191
+		default:
+			x, y = 8, 191
+		}
+		if r, _, _, _ := dc.Image().At(x, y).RGBA(); r == 0 {
+			t.Errorf("%s legend not drawn at %d,%d", location, x, y)
+		}
+	}
+}
+
+func TestAddLegend_Hidden(t *testing.T) {
+	for _, location := range []string{LegendBottomLeft, LegendFooter} {
+		dc := gg.NewContext(400, 200)
+		legend := &LegendData{
+			Location:         location,
+			Font:             "Dirga.ttf",
+			FontSize:         20,
+			MaxWidth:         150,
+			TextColour:       "#000000ff",
+			BackgroundColour: "#ffffffff",
+		}
+		// Only hidden contexts still draws the legend
+		addLegend(dc, legend, nil, []string{"MENU", "DRONE"}, nil, 0, 1.0,
+			"../../resources/fonts", 5, NewFontFaceCache())
+		if _, _, _, a := dc.Image().At(2, 198).RGBA(); a == 0 {
+			t.Errorf("%s legend with hidden contexts not drawn", location)
+		}
+	}
+}
+
+func TestAddLegend_FooterWraps(t *testing.T) {
+	contexts := []string{"PLANE", "CAMERA", "COCKPIT", "INSTRUMENTS", "MENU"}
+	categories := make(map[string]string)
+	for _, context := range contexts {
+		categories[context] = "#ff0000ff"
+	}
+	legend := &LegendData{
+		Location:         LegendFooter,
+		Font:             "Dirga.ttf",
+		FontSize:         20,
+		Inset:            Point2d{X: 5, Y: 5},
+		TextColour:       "#000000ff",
+		BackgroundColour: "#ffffffff",
+	}
+	dc := gg.NewContext(200, 300)
+	dc.SetHexColor("#000000ff")
+	dc.Clear()
+	addLegend(dc, legend, contexts, nil, categories, 0, 1.0,
+		"../../resources/fonts", 12, NewFontFaceCache())
+	// A single row panel is 40 pixels high, wrapped rows make it taller
+	if r, _, _, _ := dc.Image().At(2+5, 300-5-50).RGBA(); r == 0 {
+		t.Error("Expected the footer legend to wrap onto more rows")
+	}
+
+	// Images narrower than the insets and padding don't break the layout
+	addLegend(gg.NewContext(10, 300), legend, contexts, nil, categories, 0, 1.0,
+		"../../resources/fonts", 12, NewFontFaceCache())
+}
+
+func TestAddLegend_Disabled(t *testing.T) {
+	dc := gg.NewContext(100, 100)
+	// No location and no fonts - would panic if it tried to draw
+	addLegend(dc, &LegendData{}, []string{"PLANE"}, nil, nil, 0, 1.0, "", 5, nil)
+	addLegend(dc, &LegendData{Location: LegendFooter}, nil, nil, nil, 0, 1.0, "", 5, nil)
+	if _, _, _, a := dc.Image().At(50, 50).RGBA(); a != 0 {
+		t.Error("Expected nothing drawn")
+	}
+}
@@ -11,6 +11,8 @@ type Logger struct {
 	Entries   []*LogEntry
 	FatalFunc func(format string, v ...interface{})
 	mu        sync.Mutex
+
+	unsupportedDevices []string // Input file devices the game doesn't support
 }
 
 // Dbg prints an informational message
@@ -27,15 +29,37 @@ func (l *Logger) Msg(format string, v ...interface{}) {
 	l.Entries = append(l.Entries, &LogEntry{false, msg})
 }
 
-// Err logs an error message
+// Err logs an error message, counted in the other category of the metrics
 func (l *Logger) Err(format string, v ...interface{}) {
+	l.ErrAs(ErrorOther, format, v...)
+}
+
+// ErrAs logs an error message, counted in the category of the metrics
+func (l *Logger) ErrAs(category ErrorCategory, format string, v ...interface{}) {
 	msg := fmt.Sprintf(format, v...)
 	log.Printf("%s\n", fmt.Sprintf("Error: %s", msg))
+	recordError(category)
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	l.Entries = append(l.Entries, &LogEntry{true, msg})
 }
 
+// UnsupportedDevice notes an input file device that the game doesn't support,
+// for the usage statistics
+func (l *Logger) UnsupportedDevice(device string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.unsupportedDevices = append(l.unsupportedDevices, device)
+}
+
+// UnsupportedDevices returns the input file devices found that the game
+// doesn't support
+func (l *Logger) UnsupportedDevices() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.unsupportedDevices
+}
+
 // Fatal calls log.Fatalf
 func (l *Logger) Fatal(format string, v ...interface{}) {
 	l.FatalFunc(format, v...)
@@ -16,3 +16,13 @@ func TestLogger_Msg(t *testing.T) {
 		t.Error("Expected not error")
 	}
 }
+
+func TestLogger_UnsupportedDevices(t *testing.T) {
+	log := NewLog()
+	log.UnsupportedDevice("Mystery Stick")
+	log.UnsupportedDevice("Other Stick")
+	if devices := log.UnsupportedDevices(); len(devices) != 2 ||
+		devices[0] != "Mystery Stick" || devices[1] != "Other Stick" {
+		t.Errorf("Expected the unsupported devices in the log, got %v", devices)
+	}
+}
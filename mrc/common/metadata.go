@@ -0,0 +1,214 @@
+package common
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// MetadataGenerator identifies metadata written by MetaRefCard
+const MetadataGenerator = "MetaRefCard"
+
+// metadataPrefix starts every JPEG comment holding card metadata. Metadata
+// larger than a comment is split across consecutive comments.
+const metadataPrefix = "metarefcard:"
+
+// Largest JPEG comment payload, the segment length includes its own 2 bytes
+const maxJpegComment = 0xffff - 2
+
+// CardMetadata is the machine readable description of a card embedded in its
+// image. It has everything needed to render the card again.
+type CardMetadata struct {
+	Generator string
+	Version   string // MetaRefCard version that generated the card
+	Game      string // Game label e.g. fs2020
+	Profile   string
+	Image     string   // Device image name. Empty for composite cards
+	Sources   []string // SHA-256 of the input files, hex encoded
+	Title     string   `json:",omitempty"` // Requested header text
+	Subtitle  string   `json:",omitempty"`
+	Pilot     string   `json:",omitempty"`
+	// Device -> Context -> Action -> Primary/Secondary. Only the contexts drawn
+	Bindings GameDeviceContextActions
+	// Device -> Context -> Action -> Settings of the drawn actions bound to axes
+	Axes GameDeviceContextAxes `json:",omitempty"`
+	// Device:Input -> Context -> Actions drawn on the input
+	Inputs map[string]map[string][]OverlayAction
+	// Colours of the contexts drawn. A context's colour depends on the other
+	// contexts in the request so it's kept to render the card the same again.
+	Colours ContextToColours `json:",omitempty"`
+}
+
+// HashSources returns the hex encoded SHA-256 of each input file
+func HashSources(files [][]byte) []string {
+	hashes := make([]string, 0, len(files))
+	for _, file := range files {
+		sum := sha256.Sum256(file)
+		hashes = append(hashes, hex.EncodeToString(sum[:]))
+	}
+	return hashes
+}
+
+// NewCardMetadata describes the card for the profile and device image. Only
+// the devices on the image and contexts allowed by the request are included.
+// An empty image name describes a composite card of all the profile's devices.
+func NewCardMetadata(game string, version string, sources []string,
+	profile string, imageName string, gameBindsByProfile GameBindsByProfile,
+	gameAxes GameAxesByProfile, overlaysByProfile OverlaysByProfile, imageMap ImageMap,
+	colours ContextToColours, opts *RequestOptions) CardMetadata {
+	metadata := CardMetadata{
+		Generator: MetadataGenerator,
+		Version:   version,
+		Game:      game,
+		Profile:   profile,
+		Image:     imageName,
+		Sources:   sources,
+		Bindings:  make(GameDeviceContextActions),
+		Inputs:    make(map[string]map[string][]OverlayAction),
+	}
+	if opts != nil {
+		metadata.Title = opts.Title
+		metadata.Subtitle = opts.Subtitle
+		metadata.Pilot = opts.Pilot
+	}
+	for shortName, gameDevice := range gameBindsByProfile[profile] {
+		if len(imageName) > 0 && imageMap[shortName] != imageName {
+			continue
+		}
+		contexts := make(GameContextActions)
+		for context, actions := range gameDevice {
+			if opts.allowsContext(context) {
+				contexts[context] = actions
+				if colour, found := colours[context]; found {
+					if metadata.Colours == nil {
+						metadata.Colours = make(ContextToColours)
+					}
+					metadata.Colours[context] = colour
+				}
+			}
+		}
+		metadata.Bindings[shortName] = contexts
+		for context, axes := range gameAxes[profile][shortName] {
+			if !opts.allowsContext(context) {
+				continue
+			}
+			if metadata.Axes == nil {
+				metadata.Axes = make(GameDeviceContextAxes)
+			}
+			if metadata.Axes[shortName] == nil {
+				metadata.Axes[shortName] = make(GameContextAxes)
+			}
+			metadata.Axes[shortName][context] = axes
+		}
+	}
+	for overlaysImage, overlays := range overlaysByProfile[profile] {
+		if len(imageName) > 0 && overlaysImage != imageName {
+			continue
+		}
+		for deviceAndInput, overlay := range overlays {
+			metadata.Inputs[deviceAndInput] = overlay.ContextToActions
+		}
+	}
+	return metadata
+}
+
+// GameBinds returns the card's bindings as game binds for rendering
+func (m *CardMetadata) GameBinds() GameBindsByProfile {
+	return GameBindsByProfile{m.Profile: m.Bindings}
+}
+
+// GameAxes returns the settings of the card's axis bindings for rendering
+func (m *CardMetadata) GameAxes() GameAxesByProfile {
+	return GameAxesByProfile{m.Profile: m.Axes}
+}
+
+// RequestOptions returns opts with the card's header text filled in where
+// opts doesn't set it. Composite cards are rendered as composites again.
+func (m *CardMetadata) RequestOptions(opts *RequestOptions) *RequestOptions {
+	merged := RequestOptions{}
+	if opts != nil {
+		merged = *opts
+	}
+	if len(merged.Title) == 0 {
+		merged.Title = m.Title
+	}
+	if len(merged.Subtitle) == 0 {
+		merged.Subtitle = m.Subtitle
+	}
+	if len(merged.Pilot) == 0 {
+		merged.Pilot = m.Pilot
+	}
+	if len(m.Image) == 0 {
+		merged.Composite = true
+	}
+	return &merged
+}
+
+// EmbedCardMetadata returns the JPEG with the metadata added as comments
+// straight after the start of image marker
+func EmbedCardMetadata(jpg []byte, metadata *CardMetadata) ([]byte, error) {
+	if len(jpg) < 2 || jpg[0] != 0xff || jpg[1] != 0xd8 {
+		return nil, errors.New("not a JPEG image")
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+	var embedded bytes.Buffer
+	embedded.Grow(len(jpg) + len(data) + 64)
+	embedded.Write(jpg[:2])
+	chunkSize := maxJpegComment - len(metadataPrefix)
+	for start := 0; start < len(data); start += chunkSize {
+		chunk := data[start:min(start+chunkSize, len(data))]
+		embedded.Write([]byte{0xff, 0xfe})
+		binary.Write(&embedded, binary.BigEndian,
+			uint16(2+len(metadataPrefix)+len(chunk)))
+		embedded.WriteString(metadataPrefix)
+		embedded.Write(chunk)
+	}
+	embedded.Write(jpg[2:])
+	return embedded.Bytes(), nil
+}
+
+// ReadCardMetadata returns the metadata embedded in a JPEG card
+func ReadCardMetadata(jpg []byte) (*CardMetadata, error) {
+	if len(jpg) < 2 || jpg[0] != 0xff || jpg[1] != 0xd8 {
+		return nil, errors.New("not a JPEG image")
+	}
+	var data []byte
+	// Walk the segments up to the start of the image data
+	for pos := 2; pos+4 <= len(jpg); {
+		if jpg[pos] != 0xff {
+			return nil, fmt.Errorf("invalid JPEG marker at %d", pos)
+		}
+		marker := jpg[pos+1]
+		if marker == 0xda || marker == 0xd9 { // Start of scan or end of image
+			break
+		}
+		length := int(binary.BigEndian.Uint16(jpg[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(jpg) {
+			return nil, fmt.Errorf("invalid JPEG segment length at %d", pos)
+		}
+		payload := jpg[pos+4 : end]
+		if marker == 0xfe && bytes.HasPrefix(payload, []byte(metadataPrefix)) {
+			data = append(data, payload[len(metadataPrefix):]...)
+		}
+		pos = end
+	}
+	if len(data) == 0 {
+		return nil, errors.New("no MetaRefCard metadata found")
+	}
+	var metadata CardMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("invalid MetaRefCard metadata - %w", err)
+	}
+	if metadata.Generator != MetadataGenerator {
+		return nil, fmt.Errorf("unknown metadata generator %s", metadata.Generator)
+	}
+	return &metadata, nil
+}
@@ -0,0 +1,192 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"strings"
+	"testing"
+)
+
+func testJpeg(t *testing.T) []byte {
+	var jpg bytes.Buffer
+	if err := jpeg.Encode(&jpg, image.NewRGBA(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return jpg.Bytes()
+}
+
+func TestHashSources(t *testing.T) {
+	hashes := HashSources([][]byte{[]byte("abc"), nil})
+	if len(hashes) != 2 ||
+		hashes[0] != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" ||
+		hashes[1] != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Errorf("Unexpected hashes %v", hashes)
+	}
+}
+
+func TestNewCardMetadata(t *testing.T) {
+	log, _ := mockLogger()
+	binds := GameBindsByProfile{
+		"Default": GameDeviceContextActions{
+			"stick": GameContextActions{
+				"PLANE":  GameActions{"GEAR": {"Button 1", ""}},
+				"CAMERA": GameActions{"ZOOM": {"Button 2", ""}},
+			},
+			"throttle": GameContextActions{
+				"PLANE": GameActions{"FLAPS": {"Button 3", ""}},
+			},
+			"pedals": GameContextActions{
+				"PLANE": GameActions{"RUDDER": {"Axis X", ""}},
+			},
+		},
+		"Other": GameDeviceContextActions{},
+	}
+	overlays := OverlaysByProfile{"Default": OverlaysByImage{"hotas": {
+		"stick:1": {ContextToActions: map[string][]OverlayAction{
+			"PLANE": {{Text: "Gear", Action: "GEAR", Primary: "Button 1"}}}},
+	}}}
+	imageMap := ImageMap{"stick": "hotas", "throttle": "hotas", "pedals": "pedals"}
+	axes := make(GameAxesByProfile)
+	axes.Set("Default", "stick", "CAMERA", "ZOOM", AxisSettings{})
+	axes.Set("Default", "stick", "PLANE", "GEAR", AxisSettings{Inverted: true})
+	axes.Set("Default", "pedals", "PLANE", "RUDDER", AxisSettings{})
+	opts := &RequestOptions{Contexts: ParseContextFilter("", "CAMERA", log)}
+
+	metadata := NewCardMetadata("game", "1.0", []string{"hash"}, "Default",
+		"hotas", binds, axes, overlays, imageMap,
+		ContextToColours{"PLANE": "c1", "CAMERA": "c2", "OTHER": "c3"}, opts)
+	if metadata.Generator != MetadataGenerator || metadata.Game != "game" ||
+		metadata.Version != "1.0" || metadata.Profile != "Default" ||
+		metadata.Image != "hotas" || metadata.Sources[0] != "hash" {
+		t.Errorf("Unexpected metadata %+v", metadata)
+	}
+	// Only the devices on the image and the contexts drawn
+	if len(metadata.Bindings) != 2 || len(metadata.Bindings["stick"]) != 1 ||
+		metadata.Bindings["stick"]["PLANE"]["GEAR"][InputPrimary] != "Button 1" ||
+		metadata.Bindings["throttle"] == nil {
+		t.Errorf("Unexpected bindings %v", metadata.Bindings)
+	}
+	if metadata.Inputs["stick:1"]["PLANE"][0].Action != "GEAR" {
+		t.Errorf("Unexpected inputs %v", metadata.Inputs)
+	}
+	if binds := metadata.GameBinds(); len(binds) != 1 || len(binds["Default"]) != 2 {
+		t.Errorf("Unexpected game binds %v", binds)
+	}
+	// Axes of the devices on the image and the contexts drawn
+	if len(metadata.Axes) != 1 || len(metadata.Axes["stick"]) != 1 ||
+		metadata.GameAxes().Lookup("Default", "stick", "PLANE", "GEAR") == nil {
+		t.Errorf("Unexpected axes %v", metadata.Axes)
+	}
+	// Colours of the contexts drawn
+	if len(metadata.Colours) != 1 || metadata.Colours["PLANE"] != "c1" {
+		t.Errorf("Unexpected colours %v", metadata.Colours)
+	}
+
+	// Composite cards have all the profile's devices
+	composite := NewCardMetadata("game", "1.0", nil, "Default", "", binds,
+		nil, overlays, imageMap, nil, nil)
+	if len(composite.Bindings) != 3 || len(composite.Inputs) != 1 {
+		t.Errorf("Unexpected composite metadata %+v", composite)
+	}
+	if !composite.RequestOptions(nil).Composite || metadata.RequestOptions(nil).Composite {
+		t.Error("Only composite cards should be rendered as composites again")
+	}
+}
+
+func TestCardMetadata_RoundTrip(t *testing.T) {
+	jpg := testJpeg(t)
+	metadata := &CardMetadata{Generator: MetadataGenerator, Game: "game",
+		Profile: "Default", Bindings: GameDeviceContextActions{
+			"stick": GameContextActions{"PLANE": GameActions{
+				"GEAR": {"Button 1", "Button 2"}}}}}
+	embedded, err := EmbedCardMetadata(jpg, metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Still a valid image
+	if _, err := jpeg.Decode(bytes.NewReader(embedded)); err != nil {
+		t.Fatalf("Embedded image doesn't decode: %v", err)
+	}
+	read, err := ReadCardMetadata(embedded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Game != "game" ||
+		read.Bindings["stick"]["PLANE"]["GEAR"][InputSecondary] != "Button 2" {
+		t.Errorf("Unexpected metadata %+v", read)
+	}
+}
+
+func TestCardMetadata_LargeRoundTrip(t *testing.T) {
+	// Metadata larger than a JPEG comment is split across comments
+	actions := make(GameActions)
+	for i := 0; i < 5000; i++ {
+		actions[fmt.Sprintf("ACTION_%04d_%s", i, strings.Repeat("X", 20))] =
+			GameInput{"Button 1", ""}
+	}
+	metadata := &CardMetadata{Generator: MetadataGenerator, Profile: "Default",
+		Bindings: GameDeviceContextActions{"stick": {"PLANE": actions}}}
+	embedded, err := EmbedCardMetadata(testJpeg(t), metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Count(embedded, []byte(metadataPrefix)) < 2 {
+		t.Fatal("Expected metadata split across comments")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(embedded)); err != nil {
+		t.Fatalf("Embedded image doesn't decode: %v", err)
+	}
+	read, err := ReadCardMetadata(embedded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read.Bindings["stick"]["PLANE"]) != len(actions) {
+		t.Errorf("Expected %d actions, got %d", len(actions),
+			len(read.Bindings["stick"]["PLANE"]))
+	}
+}
+
+func TestCardMetadata_Errors(t *testing.T) {
+	if _, err := EmbedCardMetadata([]byte("not a jpeg"), &CardMetadata{}); err == nil {
+		t.Error("Expected error embedding in a non JPEG")
+	}
+	if _, err := ReadCardMetadata([]byte("not a jpeg")); err == nil {
+		t.Error("Expected error reading a non JPEG")
+	}
+	if _, err := ReadCardMetadata(testJpeg(t)); err == nil {
+		t.Error("Expected error without metadata")
+	}
+	// Truncated segment
+	if _, err := ReadCardMetadata([]byte{0xff, 0xd8, 0xff, 0xfe, 0x10, 0x00}); err == nil {
+		t.Error("Expected error for a truncated segment")
+	}
+	// Comment from another generator
+	embedded, _ := EmbedCardMetadata(testJpeg(t), &CardMetadata{Generator: "Other"})
+	if _, err := ReadCardMetadata(embedded); err == nil {
+		t.Error("Expected error for another generator")
+	}
+}
+
+func TestCardMetadata_RequestOptions(t *testing.T) {
+	metadata := NewCardMetadata("game", "1.0", nil, "Default", "hotas", nil, nil,
+		nil, nil, nil, &RequestOptions{Title: "A320", Subtitle: "Captain side", Pilot: "Maverick"})
+	if metadata.Title != "A320" || metadata.Subtitle != "Captain side" ||
+		metadata.Pilot != "Maverick" {
+		t.Errorf("Expected header text in metadata %+v", metadata)
+	}
+
+	opts := metadata.RequestOptions(nil)
+	if opts.Title != "A320" || opts.Subtitle != "Captain side" || opts.Pilot != "Maverick" {
+		t.Errorf("Expected the card's header text, got %+v", opts)
+	}
+	requested := &RequestOptions{Title: "A321", Scale: 0.5}
+	opts = metadata.RequestOptions(requested)
+	if opts.Title != "A321" || opts.Subtitle != "Captain side" || opts.Scale != 0.5 {
+		t.Errorf("Expected requested options to win, got %+v", opts)
+	}
+	if requested.Subtitle != "" {
+		t.Error("Requested options should not be changed")
+	}
+}
@@ -0,0 +1,211 @@
+package common
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// maxUnsupportedDevices - distinct unsupported device names counted. Names
+// come from uploaded files so they're capped to keep the metrics small.
+const maxUnsupportedDevices = 500
+
+// otherLabel replaces label values once there are too many
+const otherLabel = "other"
+
+// ErrorCategory groups error log entries in the metrics
+type ErrorCategory string
+
+// Error categories, entries logged without one are counted as other
+const (
+	ErrorOther             ErrorCategory = otherLabel
+	ErrorUnsupportedDevice ErrorCategory = "unsupported_device"
+	ErrorUnknownInput      ErrorCategory = "unknown_input"
+	ErrorParse             ErrorCategory = "parse"
+	ErrorImage             ErrorCategory = "image"
+	ErrorOption            ErrorCategory = "option"
+	ErrorUpload            ErrorCategory = "upload"
+	ErrorTemplate          ErrorCategory = "template"
+	ErrorStorage           ErrorCategory = "storage"
+)
+
+// registry holds the server's metrics. It's not the default registry so
+// only the server's metrics are exposed.
+var registry = prometheus.NewRegistry()
+
+// fontCacheHits and fontCacheMisses count font face lookups across the
+// per-image font caches
+var fontCacheHits, fontCacheMisses atomic.Int64
+
+// Histogram buckets
+var (
+	durationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+	overlayBuckets  = []float64{0, 10, 25, 50, 100, 250, 500, 1000}
+)
+
+var (
+	requestsTotal = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "mrc_requests_total",
+		Help: "HTTP requests by route, method and status.",
+	}, []string{"route", "method", "status"})
+	requestDuration = promauto.With(registry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mrc_request_duration_seconds",
+		Help:    "HTTP request latency by route.",
+		Buckets: durationBuckets,
+	}, []string{"route"})
+	parseDuration = promauto.With(registry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mrc_parse_duration_seconds",
+		Help:    "Time parsing a request's input files by game.",
+		Buckets: durationBuckets,
+	}, []string{"game"})
+	overlaysPerRequest = promauto.With(registry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mrc_overlays_per_request",
+		Help:    "Overlays drawn on a request's cards by game.",
+		Buckets: overlayBuckets,
+	}, []string{"game"})
+	imagesRendered = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "mrc_images_rendered_total",
+		Help: "Card images rendered by game.",
+	}, []string{"game"})
+	imageBytes = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "mrc_image_bytes_total",
+		Help: "Bytes of card images rendered by game.",
+	}, []string{"game"})
+	unsupportedDevicesTotal = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "mrc_unsupported_devices_total",
+		Help: "Input files with a device that isn't supported, by game and device.",
+	}, []string{"game", "device"})
+	logErrors = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "mrc_log_errors_total",
+		Help: "Error log entries by category.",
+	}, []string{"category"})
+)
+
+// The caches and render pool keep their own counts, read when scraped
+var _ = []prometheus.Collector{
+	promauto.With(registry).NewCounterFunc(prometheus.CounterOpts{
+		Name: "mrc_image_cache_hits_total",
+		Help: "Device and logo image cache hits.",
+	}, func() float64 {
+		hits, _, _ := imageCache.Stats()
+		return float64(hits)
+	}),
+	promauto.With(registry).NewCounterFunc(prometheus.CounterOpts{
+		Name: "mrc_image_cache_misses_total",
+		Help: "Device and logo image cache misses.",
+	}, func() float64 {
+		_, misses, _ := imageCache.Stats()
+		return float64(misses)
+	}),
+	promauto.With(registry).NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "mrc_image_cache_bytes",
+		Help: "Bytes of decoded images in the image cache.",
+	}, func() float64 {
+		_, _, usedBytes := imageCache.Stats()
+		return float64(usedBytes)
+	}),
+	promauto.With(registry).NewCounterFunc(prometheus.CounterOpts{
+		Name: "mrc_font_cache_hits_total",
+		Help: "Font face cache hits.",
+	}, func() float64 { return float64(fontCacheHits.Load()) }),
+	promauto.With(registry).NewCounterFunc(prometheus.CounterOpts{
+		Name: "mrc_font_cache_misses_total",
+		Help: "Font face cache misses.",
+	}, func() float64 { return float64(fontCacheMisses.Load()) }),
+	promauto.With(registry).NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "mrc_render_workers_busy",
+		Help: "Images being rendered.",
+	}, func() float64 {
+		running, _ := renderPool.Stats()
+		return float64(running)
+	}),
+	promauto.With(registry).NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "mrc_render_workers_waiting",
+		Help: "Images waiting for a render worker.",
+	}, func() float64 {
+		_, waiting := renderPool.Stats()
+		return float64(waiting)
+	}),
+}
+
+// unsupportedDevices limits the device label values counted
+var unsupportedDevices = labelLimiter{limit: maxUnsupportedDevices}
+
+// labelLimiter allows at most limit distinct label sets
+type labelLimiter struct {
+	limit int
+	lock  sync.Mutex
+	seen  Set
+}
+
+// allow returns true if the label set was seen before or there's room for it
+func (l *labelLimiter) allow(key string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.seen[key] {
+		return true
+	}
+	if len(l.seen) >= l.limit {
+		return false
+	}
+	if l.seen == nil {
+		l.seen = make(Set)
+	}
+	l.seen[key] = true
+	return true
+}
+
+// MetricsHandler serves the server's metrics in the Prometheus formats
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// RecordRequest counts an HTTP request and its latency. The route is the
+// matched route pattern so there's a label value per endpoint, not per URL.
+func RecordRequest(route string, method string, status int, duration time.Duration) {
+	requestsTotal.WithLabelValues(route, method, strconv.Itoa(status)).Inc()
+	requestDuration.WithLabelValues(route).Observe(duration.Seconds())
+}
+
+// RecordParse records the time a game took to parse a request's input files
+func RecordParse(game string, duration time.Duration) {
+	parseDuration.WithLabelValues(game).Observe(duration.Seconds())
+}
+
+// RecordOverlays records the number of overlays drawn for a request's cards
+func RecordOverlays(game string, overlaysByProfile OverlaysByProfile) {
+	count := 0
+	for _, overlaysByImage := range overlaysByProfile {
+		for _, overlays := range overlaysByImage {
+			count += len(overlays)
+		}
+	}
+	overlaysPerRequest.WithLabelValues(game).Observe(float64(count))
+}
+
+// RecordUnsupportedDevice counts an input file device that a game doesn't
+// support. Once too many names are counted, new ones are counted as other.
+func RecordUnsupportedDevice(game string, device string) {
+	label := device
+	if !unsupportedDevices.allow(game + "\x00" + device) {
+		label = otherLabel
+	}
+	unsupportedDevicesTotal.WithLabelValues(game, label).Inc()
+}
+
+// recordImages counts the rendered card images and their bytes
+func recordImages(game string, rendered int64, totalBytes int64) {
+	imagesRendered.WithLabelValues(game).Add(float64(rendered))
+	imageBytes.WithLabelValues(game).Add(float64(totalBytes))
+}
+
+// recordError counts an error log entry in its category
+func recordError(category ErrorCategory) {
+	logErrors.WithLabelValues(string(category)).Inc()
+}
@@ -0,0 +1,108 @@
+package common
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scrapeMetrics returns the metrics in the Prometheus text format
+func scrapeMetrics() string {
+	w := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	return w.Body.String()
+}
+
+// counterValue returns the value of the counter with the label values, 0 if
+// it hasn't been counted
+func counterValue(t *testing.T, name string, labels ...string) float64 {
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			matched := true
+			for idx, label := range metric.GetLabel() {
+				if idx >= len(labels) || label.GetValue() != labels[idx] {
+					matched = false
+				}
+			}
+			if matched && len(metric.GetLabel()) == len(labels) {
+				return metric.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+func TestLabelLimiter(t *testing.T) {
+	limiter := labelLimiter{limit: 2}
+	for _, test := range []struct {
+		key     string
+		allowed bool
+	}{{"a", true}, {"b", true}, {"c", false}} {
+		if limiter.allow(test.key) != test.allowed {
+			t.Errorf("Expected %s allowed %t", test.key, test.allowed)
+		}
+	}
+	// Label sets already counted keep counting
+	if !limiter.allow("a") || limiter.allow("d") {
+		t.Error("Expected only the counted label sets allowed")
+	}
+}
+
+func TestLogger_ErrAs(t *testing.T) {
+	log := NewLog()
+	for _, test := range []struct {
+		category ErrorCategory
+		logf     func(format string, v ...interface{})
+	}{
+		{ErrorParse, func(format string, v ...interface{}) {
+			log.ErrAs(ErrorParse, format, v...)
+		}},
+		{ErrorOther, log.Err},
+	} {
+		before := counterValue(t, "mrc_log_errors_total", string(test.category))
+		test.logf("FS2020 duplicate context: %s", "Foo")
+		after := counterValue(t, "mrc_log_errors_total", string(test.category))
+		if after != before+1 {
+			t.Errorf("Expected the entry counted as %s", test.category)
+		}
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	RecordRequest("/api/sws", "POST", 200, 20*time.Millisecond)
+	RecordParse("sws", time.Millisecond)
+	RecordOverlays("sws", OverlaysByProfile{"Default": OverlaysByImage{
+		"image": {"a": OverlayData{}, "b": OverlayData{}}}})
+	RecordUnsupportedDevice("sws", "Stick")
+	recordImages("sws", 2, 1000)
+	fontCache := NewFontFaceCache()
+	fontCache.LoadFont(testFontsDir, "Dirga.ttf", 10)
+	fontCache.LoadFont(testFontsDir, "Dirga.ttf", 10)
+	out := scrapeMetrics()
+	for _, line := range []string{
+		"# TYPE mrc_requests_total counter",
+		`mrc_requests_total{method="POST",route="/api/sws",status="200"}`,
+		`mrc_request_duration_seconds_bucket{route="/api/sws",le="0.025"}`,
+		`mrc_parse_duration_seconds_count{game="sws"}`,
+		`mrc_overlays_per_request_sum{game="sws"}`,
+		`mrc_unsupported_devices_total{device="Stick",game="sws"}`,
+		`mrc_images_rendered_total{game="sws"}`,
+		`mrc_image_bytes_total{game="sws"}`,
+		"mrc_image_cache_hits_total ",
+		"mrc_font_cache_hits_total ",
+		"mrc_font_cache_misses_total ",
+		"mrc_render_workers_waiting ",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("Expected %s in\n%s", line, out)
+		}
+	}
+}
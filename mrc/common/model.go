@@ -2,6 +2,8 @@ package common
 
 import (
 	"fmt"
+	"hash/fnv"
+	"slices"
 	"sort"
 )
 
@@ -19,7 +21,7 @@ func FilterDevices(neededDevices Set, config *Config, log *Logger) DeviceMap {
 	// Filter for only the device groups we're interested in
 	for shortName := range neededDevices {
 		if _, found := config.Devices.Index[shortName]; !found {
-			log.Err("device not found in config %s", shortName)
+			log.ErrAs(ErrorUnsupportedDevice, "device not found in config %s", shortName)
 			continue
 		}
 		neededDevices[shortName] = true
@@ -36,41 +38,78 @@ func FilterDevices(neededDevices Set, config *Config, log *Logger) DeviceMap {
 	return filteredDevices
 }
 
-// GenerateContextColours - basic utility function to generate colours
-func GenerateContextColours(contexts ContextToColours, config *Config) {
+// GenerateContextColours - assigns a colour to each context. Contexts that
+// already have a colour, e.g. from a card's metadata, keep it. Contexts pinned
+// by the game config always get their pinned colour. Other contexts get a
+// colour picked by a stable hash of their name so they look the same across
+// requests. If that colour is already taken, the next least used colour in the
+// palette is used.
+func GenerateContextColours(contexts ContextToColours, pins map[string]string,
+	config *Config) {
 	contextKeys := contexts.Keys()
 	sort.Strings(contextKeys)
-	i := 0
+	used := make(map[string]int)
+	unassigned := make([]string, 0, len(contextKeys))
+	// Kept and pinned colours first so they're never taken by a hashed context
 	for _, context := range contextKeys {
-		if i >= len(config.AlternateColours) {
-			// Ran out of colours, repeat
-			i = 0
+		if colour := contexts[context]; len(colour) > 0 {
+			used[colour]++
+		} else if colour, found := pins[context]; found {
+			contexts[context] = colour
+			used[colour]++
+		} else {
+			unassigned = append(unassigned, context)
 		}
-		// Only move to next colour if this is an unseen category
-		contexts[context] = config.AlternateColours[i]
-		i++
 	}
+	numColours := len(config.AlternateColours)
+	if numColours == 0 {
+		return
+	}
+	for _, context := range unassigned {
+		start := contextColourIndex(context, numColours)
+		colour := config.AlternateColours[start]
+		for i := 1; i < numColours && used[colour] > 0; i++ {
+			candidate := config.AlternateColours[(start+i)%numColours]
+			if used[candidate] < used[colour] {
+				colour = candidate
+			}
+		}
+		contexts[context] = colour
+		used[colour]++
+	}
+}
+
+// contextColourIndex returns a stable palette index for a context name
+func contextColourIndex(context string, numColours int) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(context))
+	return int(hash.Sum32() % uint32(numColours))
 }
 
 // FuncRequestHandler - handles incoming requests and returns game data, game binds,
-// neededDevices and a context to colour mapping
+// the settings of axis binds, neededDevices and a context to colour mapping
 type FuncRequestHandler func(files [][]byte, config *Config, log *Logger) (GameData,
-	GameBindsByProfile, Set, ContextToColours, string)
+	GameBindsByProfile, GameAxesByProfile, Set, ContextToColours, string)
 
 // FuncMatchGameInputToModel takes the game provided bindings with the device map to
 // build a list of image overlays.
 type FuncMatchGameInputToModel func(deviceName string, actionData GameInput,
 	deviceInputs DeviceInputs, gameInputMap InputTypeMapping, log *Logger) (GameInput, string)
 
-// PopulateImageOverlays returns a list of image overlays to put on device images
+// PopulateImageOverlays returns a list of image overlays to put on device images.
+// Contexts filtered out by the request options are skipped and returned by
+// image so the legend can list them.
 func PopulateImageOverlays(neededDevices Set, config *Config, log *Logger,
-	gameBindsByProfile GameBindsByProfile, gameData GameData, matchFunc FuncMatchGameInputToModel) OverlaysByProfile {
+	gameBindsByProfile GameBindsByProfile, gameAxes GameAxesByProfile, gameData GameData,
+	matchFunc FuncMatchGameInputToModel, opts *RequestOptions) (OverlaysByProfile,
+	HiddenContextsByProfile) {
 
 	deviceMap := FilterDevices(neededDevices, config, log)
 	imageMap := config.Devices.ImageMap
 
 	// Iterate through our game binds
 	overlaysByProfile := make(OverlaysByProfile)
+	hidden := make(HiddenContextsByProfile)
 	for profile, gameBinds := range gameBindsByProfile {
 		overlaysByImage := make(OverlaysByImage)
 		overlaysByProfile[profile] = overlaysByImage
@@ -78,7 +117,12 @@ func PopulateImageOverlays(neededDevices Set, config *Config, log *Logger,
 			inputs := deviceMap[shortName]
 			image := imageMap[shortName]
 			for context, actions := range gameDevice {
+				if !opts.allowsContext(context) {
+					hidden.hide(profile, image, context)
+					continue
+				}
 				for actionName, gameInput := range actions {
+					axis := gameAxes.Lookup(profile, shortName, context, actionName)
 
 					inputLookups, label := matchFunc(shortName, gameInput, inputs,
 						gameData.InputMap[shortName], log)
@@ -90,9 +134,20 @@ func PopulateImageOverlays(neededDevices Set, config *Config, log *Logger,
 							continue
 						}
 
+						binding := OverlayBinding{Input: input,
+							ActionName: actionName, GameInput: gameInput,
+							Axis: axis, Context: context, ShortName: shortName,
+							Image: image, GameLabel: label}
+						if hat, direction, isHat := hatInput(inputs, input); isHat {
+							binding.Input, binding.InputData, binding.Axis =
+								hat, inputs[hat], nil
+							GenerateHatOverlays(overlaysByImage, binding, direction,
+								gameData, log)
+							continue
+						}
 						inputData, found := inputs[input]
 						if !found {
-							log.Err("%s unknown input to lookup %s for device %s",
+							log.ErrAs(ErrorUnknownInput, "%s unknown input to lookup %s for device %s",
 								label, input, shortName)
 						}
 						if inputData.X == 0 && inputData.Y == 0 {
@@ -100,43 +155,68 @@ func PopulateImageOverlays(neededDevices Set, config *Config, log *Logger,
 								label, actionName, shortName, inputData)
 							continue
 						}
-						GenerateImageOverlays(overlaysByImage, input, inputData,
-							gameData, actionName, context, shortName, image, label, log)
+						binding.InputData = inputData
+						GenerateImageOverlays(overlaysByImage, binding, gameData, log)
 					}
 				}
 			}
 		}
+		sortOverlays(overlaysByImage)
 	}
 
-	return overlaysByProfile
+	return overlaysByProfile, hidden
 }
 
-// GenerateImageOverlays - creates the image overlays into overlaysByImage
-func GenerateImageOverlays(overlaysByImage OverlaysByImage, input string, inputData InputData,
-	gameData GameData, actionName string, context string, shortName string,
-	image string, gameLabel string, log *Logger) {
+// OverlayBinding - a game action bound to a device input, to be drawn in
+// the input's overlay
+type OverlayBinding struct {
+	Input      string // Device input e.g. 1 or POV1
+	InputData  InputData
+	ActionName string
+	GameInput  GameInput
+	Axis       *AxisSettings // Nil unless bound to an axis
+	Context    string
+	ShortName  string // Device short name
+	Image      string // Device image name
+	GameLabel  string
+}
+
+// GenerateImageOverlays - creates the image overlays into overlaysByImage. Actions
+// bound to an axis keep their settings for the axis marker drawn with them.
+func GenerateImageOverlays(overlaysByImage OverlaysByImage, binding OverlayBinding,
+	gameData GameData, log *Logger) {
+	context := binding.Context
 	var overlayData OverlayData
 	overlayData.ContextToTexts = make(map[string][]string)
-	overlayData.PosAndSize = inputData
+	overlayData.ContextToActions = make(map[string][]OverlayAction)
+	overlayData.PosAndSize = binding.InputData
 	var text string
 	// Game data might have a better label for this text
-	if label, found := gameData.InputLabels[actionName]; found {
+	if label, found := gameData.InputLabels[binding.ActionName]; found {
 		text = label
 	} else {
-		text = actionName
-		log.Err("%s label not found. %s context %s device %s",
-			gameLabel, actionName, context, shortName)
+		text = binding.ActionName
+		log.ErrAs(ErrorUnknownInput, "%s label not found. %s context %s device %s",
+			binding.GameLabel, binding.ActionName, context, binding.ShortName)
 	}
 	texts := make([]string, 1)
 	texts[0] = text
 	overlayData.ContextToTexts[context] = texts
+	action := OverlayAction{Text: text, Action: binding.ActionName, Axis: binding.Axis}
+	if len(binding.GameInput) > InputPrimary {
+		action.Primary = binding.GameInput[InputPrimary]
+	}
+	if len(binding.GameInput) > InputSecondary {
+		action.Secondary = binding.GameInput[InputSecondary]
+	}
+	overlayData.ContextToActions[context] = []OverlayAction{action}
 
 	// Find by Image first
-	deviceAndInput := fmt.Sprintf("%s:%s", shortName, input)
-	if overlay, found := overlaysByImage[image]; !found {
+	deviceAndInput := fmt.Sprintf("%s:%s", binding.ShortName, binding.Input)
+	if overlay, found := overlaysByImage[binding.Image]; !found {
 		// First time adding this image
 		overlay := make(map[string]OverlayData)
-		overlaysByImage[image] = overlay
+		overlaysByImage[binding.Image] = overlay
 		overlay[deviceAndInput] = overlayData
 	} else {
 		// Now find by input
@@ -144,10 +224,57 @@ func GenerateImageOverlays(overlaysByImage OverlaysByImage, input string, inputD
 			// Not new image but new overlayData
 			overlay[deviceAndInput] = overlayData
 		} else {
-			// Concatenate input
-			texts = append(previousOverlayData.ContextToTexts[context], text)
-			sort.Strings(texts)
-			previousOverlayData.ContextToTexts[context] = texts
+			// Concatenate input, sorted once all are added by sortOverlays
+			previousOverlayData.ContextToTexts[context] = append(
+				previousOverlayData.ContextToTexts[context], text)
+			if previousOverlayData.ContextToActions == nil {
+				previousOverlayData.ContextToActions = make(map[string][]OverlayAction)
+				overlay[deviceAndInput] = previousOverlayData
+			}
+			previousOverlayData.ContextToActions[context] = append(
+				previousOverlayData.ContextToActions[context], action)
+		}
+	}
+}
+
+// sortOverlays sorts the texts and actions of each overlay by text. A text is
+// drawn once per context as its chip lists every action with that text.
+// Repeated actions e.g. the primary and secondary inputs bound to the same
+// device input are dropped.
+func sortOverlays(overlaysByImage OverlaysByImage) {
+	for _, overlays := range overlaysByImage {
+		for key, overlayData := range overlays {
+			for context, texts := range overlayData.ContextToTexts {
+				sort.Strings(texts)
+				overlayData.ContextToTexts[context] = slices.Compact(texts)
+			}
+			for context, actions := range overlayData.ContextToActions {
+				sort.Slice(actions, func(i, j int) bool {
+					a, b := actions[i], actions[j]
+					if a.Text != b.Text {
+						return a.Text < b.Text
+					}
+					if a.Action != b.Action {
+						return a.Action < b.Action
+					}
+					if a.Primary != b.Primary {
+						return a.Primary < b.Primary
+					}
+					return a.Secondary < b.Secondary
+				})
+				overlayData.ContextToActions[context] = slices.CompactFunc(actions,
+					func(a, b OverlayAction) bool {
+						return a.Text == b.Text && a.Action == b.Action &&
+							a.Primary == b.Primary && a.Secondary == b.Secondary
+					})
+			}
+			for _, contextToTexts := range overlayData.Directions {
+				for context, texts := range contextToTexts {
+					sort.Strings(texts)
+					contextToTexts[context] = slices.Compact(texts)
+				}
+			}
+			overlays[key] = overlayData
 		}
 	}
 }
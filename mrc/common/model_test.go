@@ -3,6 +3,8 @@ package common
 import (
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
 	"sort"
 	"testing"
 
@@ -67,15 +69,109 @@ func TestGenerateContextColours(t *testing.T) {
 	contexts["A"] = ""
 	contexts["B"] = ""
 	contexts["C"] = ""
-	
-	GenerateContextColours(contexts, config)
-	
-	// keys sorted: A, B, C
-	// A -> c1 (i=0 -> 1)
-	// B -> c2 (i=1 -> 2)
-	// C -> c1 (i=2 -> 0 -> 1)
-	
-	if contexts["A"] != "c1" || contexts["B"] != "c2" || contexts["C"] != "c1" {
+
+	GenerateContextColours(contexts, nil, config)
+
+	// Two colours for three contexts - A and B are sorted first so they get
+	// distinct colours and C has to repeat one
+	if contexts["A"] == contexts["B"] {
+		t.Errorf("A and B collide: %v", contexts)
+	}
+	for context, colour := range contexts {
+		if colour != "c1" && colour != "c2" {
+			t.Errorf("Unexpected colour for %s: %v", context, contexts)
+		}
+	}
+}
+
+func TestGenerateContextColours_Stable(t *testing.T) {
+	config := &Config{
+		AlternateColours: []string{"c1", "c2", "c3", "c4", "c5", "c6", "c7", "c8"},
+	}
+	// A context keeps its colour regardless of the other contexts present
+	// as long as they don't collide with it
+	alone := ContextToColours{"PLANE": ""}
+	GenerateContextColours(alone, nil, config)
+	expected := config.AlternateColours[contextColourIndex("PLANE", 8)]
+	if alone["PLANE"] != expected {
+		t.Errorf("Expected %s, got %s", expected, alone["PLANE"])
+	}
+	withOthers := ContextToColours{"PLANE": "", "ZZZ": ""}
+	GenerateContextColours(withOthers, nil, config)
+	if withOthers["PLANE"] != expected {
+		t.Errorf("PLANE changed colour to %s", withOthers["PLANE"])
+	}
+}
+
+func TestGenerateContextColours_Pins(t *testing.T) {
+	config := &Config{
+		AlternateColours: []string{"c1", "c2"},
+	}
+	contexts := ContextToColours{"A": "", "B": ""}
+	// Pin A to the colour B would hash to
+	hashed := config.AlternateColours[contextColourIndex("B", 2)]
+	pins := map[string]string{"A": hashed, "Unused": "c9"}
+
+	GenerateContextColours(contexts, pins, config)
+
+	if contexts["A"] != hashed {
+		t.Errorf("Expected pinned colour %s, got %s", hashed, contexts["A"])
+	}
+	// B can't have its hashed colour as it's pinned to A
+	if contexts["B"] == hashed {
+		t.Errorf("B collides with pinned A: %v", contexts)
+	}
+	if _, found := contexts["Unused"]; found {
+		t.Error("Pins should not add contexts")
+	}
+}
+
+func TestGenerateContextColours_Collision(t *testing.T) {
+	config := &Config{
+		AlternateColours: []string{"c1", "c2", "c3", "c4", "c5", "c6", "c7", "c8"},
+	}
+	// CAMERA and GEAR hash to the same colour
+	if contextColourIndex("CAMERA", 8) != contextColourIndex("GEAR", 8) {
+		t.Fatal("Expected CAMERA and GEAR to collide")
+	}
+	both := ContextToColours{"CAMERA": "", "GEAR": ""}
+	GenerateContextColours(both, nil, config)
+	if both["CAMERA"] == both["GEAR"] {
+		t.Errorf("CAMERA and GEAR collide: %v", both)
+	}
+
+	// A card with only GEAR gets the hashed colour without the other context
+	alone := ContextToColours{"GEAR": ""}
+	GenerateContextColours(alone, nil, config)
+	if alone["GEAR"] != both["CAMERA"] {
+		t.Errorf("Expected the hashed colour %s, got %s", both["CAMERA"], alone["GEAR"])
+	}
+	// but keeps its colour when rendered again with the card's colours
+	kept := ContextToColours{"GEAR": both["GEAR"], "SLEW": ""}
+	GenerateContextColours(kept, nil, config)
+	if kept["GEAR"] != both["GEAR"] || kept["SLEW"] == kept["GEAR"] {
+		t.Errorf("Expected GEAR to keep %s, got %v", both["GEAR"], kept)
+	}
+}
+
+func TestGenerateContextColours_LeastUsed(t *testing.T) {
+	config := &Config{AlternateColours: []string{"c1", "c2"}}
+	contexts := ContextToColours{"A": "", "B": "", "C": "", "D": ""}
+	GenerateContextColours(contexts, nil, config)
+	// Once every colour is used, colours are shared evenly
+	counts := make(map[string]int)
+	for _, colour := range contexts {
+		counts[colour]++
+	}
+	if counts["c1"] != 2 || counts["c2"] != 2 {
+		t.Errorf("Expected each colour used twice, got %v", contexts)
+	}
+}
+
+func TestGenerateContextColours_NoPalette(t *testing.T) {
+	contexts := ContextToColours{"A": "", "B": ""}
+	GenerateContextColours(contexts, map[string]string{"A": "c1"}, &Config{})
+	if contexts["A"] != "c1" || contexts["B"] != "" {
 		t.Errorf("Unexpected colours: %v", contexts)
 	}
 }
@@ -143,7 +239,7 @@ func TestPopulateImageOverlays(t *testing.T) {
 		return []string{}, ""
 	}
 	
-	overlays := PopulateImageOverlays(needed, config, log, binds, gameData, matchFunc)
+	overlays, _ := PopulateImageOverlays(needed, config, log, binds, nil, gameData, matchFunc, nil)
 	
 	// Verify
 	imgOverlays := overlays[profile]["d1.jpg"]
@@ -186,7 +282,7 @@ func TestPopulateImageOverlays(t *testing.T) {
 	inputData := InputData{X: 10, Y: 10}
 	
 	// First call
-	GenerateImageOverlays(existing, "btn_x", inputData, gameData, "UnknownAction", "ctxA", "devX", "imgX.jpg", "label", log2)
+	GenerateImageOverlays(existing, OverlayBinding{Input: "btn_x", InputData: inputData, ActionName: "UnknownAction", Context: "ctxA", ShortName: "devX", Image: "imgX.jpg", GameLabel: "label"}, gameData, log2)
 	
 	// Should use "UnknownAction" as text since not in InputLabels
 	odX := existing["imgX.jpg"]["devX:btn_x"]
@@ -195,7 +291,7 @@ func TestPopulateImageOverlays(t *testing.T) {
 	}
 	
 	// Second call - append
-	GenerateImageOverlays(existing, "btn_x", inputData, gameData, "action1", "ctxA", "devX", "imgX.jpg", "label", log2)
+	GenerateImageOverlays(existing, OverlayBinding{Input: "btn_x", InputData: inputData, ActionName: "action1", Context: "ctxA", ShortName: "devX", Image: "imgX.jpg", GameLabel: "label"}, gameData, log2)
 	// action1 maps to "Start" in gameData above
 	
 	texts := existing["imgX.jpg"]["devX:btn_x"].ContextToTexts["ctxA"]
@@ -250,7 +346,7 @@ func TestPopulateImageOverlays_EmptySecondaryInput(t *testing.T) {
 		return []string{"btn1", ""}, "TestLabel"
 	}
 	
-	overlays := PopulateImageOverlays(needed, config, log, binds, gameData, matchFunc)
+	overlays, _ := PopulateImageOverlays(needed, config, log, binds, nil, gameData, matchFunc, nil)
 	
 	// Should only have btn1, secondary empty input should be skipped
 	imgOverlays := overlays[profile]["d1.jpg"]
@@ -286,10 +382,10 @@ func TestGenerateImageOverlays_NewOverlayForExistingImage(t *testing.T) {
 	inputData2 := InputData{X: 100, Y: 100, W: 50, H: 30}
 	
 	// First call - creates image entry
-	GenerateImageOverlays(existing, "btn1", inputData1, gameData, "action1", "ctx1", "dev1", "shared.jpg", "label", log)
+	GenerateImageOverlays(existing, OverlayBinding{Input: "btn1", InputData: inputData1, ActionName: "action1", Context: "ctx1", ShortName: "dev1", Image: "shared.jpg", GameLabel: "label"}, gameData, log)
 	
 	// Second call - same image, different device:input (triggers line 143-145)
-	GenerateImageOverlays(existing, "btn2", inputData2, gameData, "action2", "ctx1", "dev1", "shared.jpg", "label", log)
+	GenerateImageOverlays(existing, OverlayBinding{Input: "btn2", InputData: inputData2, ActionName: "action2", Context: "ctx1", ShortName: "dev1", Image: "shared.jpg", GameLabel: "label"}, gameData, log)
 	
 	// Verify both overlays exist on the same image
 	imgOverlays := existing["shared.jpg"]
@@ -312,3 +408,152 @@ func TestGenerateImageOverlays_NewOverlayForExistingImage(t *testing.T) {
 		t.Errorf("Wrong text for btn2: %v", imgOverlays["dev1:btn2"].ContextToTexts)
 	}
 }
+
+func TestPopulateImageOverlays_ContextFilter(t *testing.T) {
+	log, _ := mockLogger()
+	config := &Config{
+		Devices: Devices{
+			Index: DeviceMap{
+				"d1": DeviceInputs{"btn1": InputData{X: 10, Y: 10}},
+				"d2": DeviceInputs{"btn1": InputData{X: 10, Y: 10}},
+			},
+			ImageMap: ImageMap{"d1": "d1.jpg", "d2": "d2.jpg"},
+		},
+	}
+	binds := GameBindsByProfile{
+		"Default": GameDeviceContextActions{
+			"d1": GameContextActions{
+				"PLANE":          GameActions{"action1": {"input1", ""}},
+				"COCKPIT_CAMERA": GameActions{"action2": {"input1", ""}},
+			},
+			"d2": GameContextActions{
+				"EXTERNAL_CAMERA": GameActions{"action3": {"input1", ""}},
+			},
+		},
+	}
+	matchFunc := func(deviceName string, actionData GameInput,
+		deviceInputs DeviceInputs, gameInputMap InputTypeMapping, log *Logger) (GameInput, string) {
+		return []string{"btn1"}, "label"
+	}
+	opts := &RequestOptions{Contexts: ParseContextFilter("", "*camera", log)}
+
+	before := *opts
+	overlays, hidden := PopulateImageOverlays(Set{"d1": true, "d2": true}, config,
+		log, binds, nil, GameData{}, matchFunc, opts)
+
+	contextToTexts := overlays["Default"]["d1.jpg"]["d1:btn1"].ContextToTexts
+	if _, found := contextToTexts["PLANE"]; !found {
+		t.Error("PLANE should not be filtered")
+	}
+	if _, found := contextToTexts["COCKPIT_CAMERA"]; found {
+		t.Error("COCKPIT_CAMERA should be filtered")
+	}
+	// Each card lists the contexts filtered out of it
+	for _, test := range []struct {
+		images   []string
+		expected []string
+	}{
+		{[]string{"d1.jpg"}, []string{"COCKPIT_CAMERA"}},
+		{[]string{"d2.jpg"}, []string{"EXTERNAL_CAMERA"}},
+		{[]string{"d1.jpg", "d2.jpg"}, []string{"COCKPIT_CAMERA", "EXTERNAL_CAMERA"}},
+	} {
+		if contexts := hidden.Contexts("Default", test.images...); !slices.Equal(
+			contexts, test.expected) {
+			t.Errorf("%v expected hidden %v, got %v", test.images, test.expected,
+				contexts)
+		}
+	}
+	if contexts := hidden.Contexts("Other", "d1.jpg"); len(contexts) != 0 {
+		t.Errorf("Expected no hidden contexts, got %v", contexts)
+	}
+	// The options aren't changed so requests can share them
+	if !reflect.DeepEqual(before, *opts) {
+		t.Errorf("Options changed from %+v to %+v", before, *opts)
+	}
+}
+
+func TestGenerateImageOverlays_Actions(t *testing.T) {
+	log, _ := mockLogger()
+	gameData := GameData{InputLabels: map[string]string{"GEAR": "Gear"}}
+	existing := make(OverlaysByImage)
+	inputData := InputData{X: 10, Y: 10}
+
+	binding := OverlayBinding{Input: "btn1", InputData: inputData, Context: "PLANE",
+		ShortName: "dev1", Image: "img.jpg", GameLabel: "label"}
+	binding.ActionName, binding.GameInput = "GEAR", GameInput{"Button 1", "Button 2"}
+	GenerateImageOverlays(existing, binding, gameData, log)
+	// The same action again e.g. its secondary input is the same device input
+	GenerateImageOverlays(existing, binding, gameData, log)
+	binding.ActionName, binding.GameInput = "FLAPS", GameInput{"Button 1"}
+	GenerateImageOverlays(existing, binding, gameData, log)
+	sortOverlays(existing)
+
+	actions := existing["img.jpg"]["dev1:btn1"].ContextToActions["PLANE"]
+	if len(actions) != 2 {
+		t.Fatalf("Expected 2 actions, got %v", actions)
+	}
+	// Sorted by text like the texts
+	if actions[0] != (OverlayAction{Text: "FLAPS", Action: "FLAPS",
+		Primary: "Button 1"}) {
+		t.Errorf("Unexpected action %+v", actions[0])
+	}
+	if actions[1] != (OverlayAction{Text: "Gear", Action: "GEAR",
+		Primary: "Button 1", Secondary: "Button 2"}) {
+		t.Errorf("Unexpected action %+v", actions[1])
+	}
+	// Each text is drawn once
+	texts := existing["img.jpg"]["dev1:btn1"].ContextToTexts["PLANE"]
+	if len(texts) != 2 || texts[0] != "FLAPS" || texts[1] != "Gear" {
+		t.Errorf("Expected each text once, got %v", texts)
+	}
+}
+
+func TestPopulateImageOverlays_Axis(t *testing.T) {
+	log, _ := mockLogger()
+	config := &Config{
+		Devices: Devices{
+			Index: DeviceMap{"d1": DeviceInputs{
+				"XAxis": InputData{X: 10, Y: 10, W: 100, H: 30},
+				"1":     InputData{X: 10, Y: 50, W: 100, H: 30},
+			}},
+			ImageMap: ImageMap{"d1": "d1.jpg"},
+		},
+	}
+	binds := GameBindsByProfile{"Default": GameDeviceContextActions{
+		"d1": GameContextActions{"PLANE": GameActions{
+			"AILERONS": {"XAxis", ""},
+			"GEAR":     {"1", ""},
+		}},
+	}}
+	axes := make(GameAxesByProfile)
+	axes.Set("Default", "d1", "PLANE", "AILERONS",
+		AxisSettings{Inverted: true, Sensitivity: "-50"})
+	gameData := GameData{InputLabels: map[string]string{"AILERONS": "Ailerons",
+		"GEAR": "Gear"}}
+	matchFunc := func(deviceName string, actionData GameInput,
+		deviceInputs DeviceInputs, gameInputMap InputTypeMapping, log *Logger) (GameInput, string) {
+		return actionData, "label"
+	}
+
+	overlaysByProfile, _ := PopulateImageOverlays(Set{"d1": true}, config, log,
+		binds, axes, gameData, matchFunc, nil)
+	overlays := overlaysByProfile["Default"]["d1.jpg"]
+	axis := overlays["d1:XAxis"]
+	if axis.ContextToTexts["PLANE"][0] != "Ailerons" {
+		t.Errorf("Expected text without the axis marker, got %v",
+			axis.ContextToTexts)
+	}
+	action := axis.ContextToActions["PLANE"][0]
+	if action.Text != "Ailerons" || action.Axis == nil ||
+		!action.Axis.Inverted {
+		t.Errorf("Unexpected axis action %+v", action)
+	}
+	if text := chipText("PLANE", "Ailerons", axis.ContextToActions); text !=
+		"Ailerons ↑↓ inv s-50" {
+		t.Errorf("Expected the axis marker drawn, got %q", text)
+	}
+	button := overlays["d1:1"].ContextToActions["PLANE"][0]
+	if button.Text != "Gear" || button.Axis != nil {
+		t.Errorf("Unexpected button action %+v", button)
+	}
+}
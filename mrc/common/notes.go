@@ -0,0 +1,165 @@
+package common
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/fogleman/gg"
+	"golang.org/x/image/font"
+)
+
+// NotesPage - notes panel appended below the image
+const NotesPage = "Page"
+
+// overflowNote is an input whose actions didn't fit in its box
+type overflowNote struct {
+	marker           int
+	contextToTexts   map[string][]string
+	contextToActions map[string][]OverlayAction
+}
+
+// noteChip is a single action laid out in the notes panel
+type noteChip struct {
+	text    string
+	label   string // Text drawn, with any axis marker
+	context string
+	x, y    float64
+	actions map[string][]OverlayAction
+}
+
+// drawNoteMarker draws a numbered circle (like ①) centered on x, y. The
+// bundled fonts don't have circled digits so the circle is drawn separately.
+func drawNoteMarker(dc *gg.Context, marker int, x float64, y float64,
+	diameter float64, fontFace font.Face, markerColour string,
+	textColour string) {
+	dc.SetHexColor(markerColour)
+	dc.DrawCircle(x, y, diameter/2)
+	dc.Fill()
+	dc.SetFontFace(fontFace)
+	dc.SetHexColor(textColour)
+	dc.DrawStringAnchored(strconv.Itoa(marker), x, y, 0.5, 0.35)
+}
+
+// loadNotesFont loads the notes font, using the cache if there is one
+func loadNotesFont(notes *NotesData, fontsDir string, fontSize int,
+	fontCache FontLoader) font.Face {
+	if fontCache != nil {
+		return fontCache.LoadFont(fontsDir, notes.Font, fontSize)
+	}
+	return loadFont(fontsDir, notes.Font, fontSize)
+}
+
+// truncateText shortens the text with an ellipsis until it fits the width
+func truncateText(fontFace font.Face, text string, width float64) string {
+	if w, _ := measureString(fontFace, text); float64(w) <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 {
+		runes = runes[:len(runes)-1]
+		truncated := string(runes) + "…"
+		if w, _ := measureString(fontFace, truncated); float64(w) <= width {
+			return truncated
+		}
+	}
+	return ""
+}
+
+// addNotes draws the full action list of each overflowing input next to its
+// marker. Actions wrap onto new lines to fit the panel width and an action
+// wider than the panel is truncated. For the Page
+// location the image is extended and the returned context must be used.
+// Returns the chips drawn in the panel.
+func addNotes(dc *gg.Context, notes *NotesData, overflows []overflowNote,
+	categories map[string]string, headerHeight float64, pixelMultiplier float64,
+	fontsDir string, pageColour string, lightColour string,
+	fontCache FontLoader) (*gg.Context, []CardChip) {
+	if len(notes.Location) == 0 || len(overflows) == 0 {
+		return dc, nil
+	}
+
+	rowHeight := int(math.Round(notes.FontSize * pixelMultiplier))
+	row := float64(rowHeight)
+	padding := row / 2
+	gap := padding / 2
+	insetX := notes.Inset.X * pixelMultiplier
+	insetY := notes.Inset.Y * pixelMultiplier
+	page := notes.Location == NotesPage
+
+	panelW := notes.MaxWidth * pixelMultiplier
+	if page {
+		panelW = float64(dc.Width()) - 2*insetX
+	}
+	// The text is one size smaller than the row, the same as the input boxes
+	largeFont := loadNotesFont(notes, fontsDir, rowHeight, fontCache)
+	smallFont := loadNotesFont(notes, fontsDir, rowHeight-1, fontCache)
+	markerFont := loadNotesFont(notes, fontsDir,
+		int(math.Round(row*0.7)), fontCache)
+
+	// Lay out the chips relative to the panel's top left corner
+	var chips []noteChip
+	var markerYs []float64
+	textLeft := padding + row + gap
+	textRight := panelW - padding
+	y := padding
+	for _, overflow := range overflows {
+		markerYs = append(markerYs, y)
+		x := textLeft
+		for _, context := range prepareContexts(overflow.contextToTexts) {
+			for _, text := range overflow.contextToTexts[context] {
+				label := truncateText(largeFont,
+					chipText(context, text, overflow.contextToActions),
+					textRight-textLeft)
+				w, _ := measureString(largeFont, label)
+				if x > textLeft && x+float64(w) > textRight {
+					x = textLeft
+					y += row + gap
+				}
+				chips = append(chips, noteChip{text: text, label: label, context: context,
+					x: x, y: y, actions: overflow.contextToActions})
+				x += float64(w) + gap
+			}
+		}
+		y += row + gap
+	}
+	panelH := y - gap + padding
+
+	// Size and place the panel
+	x := insetX
+	y = float64(dc.Height()) - insetY - panelH
+	switch notes.Location {
+	case LegendTopLeft:
+		y = headerHeight + insetY
+	case LegendTopRight:
+		x = float64(dc.Width()) - insetX - panelW
+		y = headerHeight + insetY
+	case LegendBottomRight:
+		x = float64(dc.Width()) - insetX - panelW
+	case NotesPage:
+		extended := gg.NewContext(dc.Width(),
+			dc.Height()+int(math.Ceil(panelH+insetY)))
+		extended.SetHexColor(pageColour)
+		extended.Clear()
+		extended.DrawImage(dc.Image(), 0, 0)
+		y = float64(dc.Height())
+		dc = extended
+	}
+
+	dc.SetHexColor(notes.BackgroundColour)
+	dc.DrawRoundedRectangle(x, y, panelW, panelH, 6)
+	dc.Fill()
+	for idx, overflow := range overflows {
+		drawNoteMarker(dc, overflow.marker, x+padding+row/2,
+			y+markerYs[idx]+row/2, row, markerFont, notes.MarkerColour,
+			notes.TextColour)
+	}
+	cardChips := make([]CardChip, 0, len(chips))
+	for _, chip := range chips {
+		rect := drawTextWithBackgroundRec(dc, chip.label, 0,
+			Point2d{X: x + chip.x, Y: y + chip.y}, 0, 0, rowHeight, 1.0,
+			largeFont, smallFont, categories[chip.context], lightColour)
+		cardChips = append(cardChips, newCardChip(rect, chip.context, chip.text,
+			chip.actions))
+	}
+	return dc, cardChips
+}
@@ -0,0 +1,168 @@
+package common
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"strings"
+	"testing"
+
+	"github.com/fogleman/gg"
+)
+
+func testNotesData(location string) *NotesData {
+	return &NotesData{
+		Location:         location,
+		Font:             "Dirga.ttf",
+		FontSize:         20,
+		Inset:            Point2d{X: 5, Y: 5},
+		MaxWidth:         150,
+		MarkerColour:     "#000000ff",
+		TextColour:       "#ffffffff",
+		BackgroundColour: "#ffffffff",
+	}
+}
+
+func TestAddNotes(t *testing.T) {
+	overflows := []overflowNote{{marker: 1,
+		contextToTexts: map[string][]string{"PLANE": {"First", "Second", "Third"}}}}
+	categories := map[string]string{"PLANE": "#ff0000ff"}
+	for _, location := range []string{LegendTopLeft, LegendTopRight,
+		LegendBottomLeft, LegendBottomRight} {
+		dc := gg.NewContext(400, 200)
+		result, _ := addNotes(dc, testNotesData(location), overflows, categories,
+			20, 1.0, "../../resources/fonts", "#000000ff", "#000000ff",
+			NewFontFaceCache())
+		if result != dc {
+			t.Errorf("%s notes should draw on the same image", location)
+		}
+		// The panel background is drawn inside the inset of the expected corner
+		var x, y int
+		switch location {
+		case LegendTopLeft:
+			x, y = 7, 27
+		case LegendTopRight:
+			x, y = 392, 27
+		case LegendBottomRight:
+			x, y = 392, 192
+		default:
+			x, y = 7, 192
+		}
+		if _, _, _, a := dc.Image().At(x, y).RGBA(); a == 0 {
+			t.Errorf("%s notes not drawn at %d,%d", location, x, y)
+		}
+	}
+}
+
+func TestAddNotes_Page(t *testing.T) {
+	dc := gg.NewContext(400, 200)
+	overflows := []overflowNote{
+		{marker: 1, contextToTexts: map[string][]string{"A": {"First"}},
+			contextToActions: map[string][]OverlayAction{"A": {{Text: "First",
+				Action: "FIRST_ACTION", Primary: "Button 1"}}}},
+		{marker: 2, contextToTexts: map[string][]string{"B": {"Second"}}},
+	}
+	result, chips := addNotes(dc, testNotesData(NotesPage), overflows, nil, 0, 1.0,
+		"../../resources/fonts", "#00ff00ff", "#000000ff", NewFontFaceCache())
+	if result.Width() != 400 || result.Height() <= 200 {
+		t.Errorf("Expected image extended below, got %dx%d", result.Width(),
+			result.Height())
+	}
+	// Extended area is filled with the page colour
+	if _, g, _, _ := result.Image().At(1, result.Height()-1).RGBA(); g == 0 {
+		t.Error("Expected page colour in extended area")
+	}
+	// Chips are recorded where they were drawn in the extended area
+	if len(chips) != 2 || chips[0].Context != "A" || chips[0].Rect.Min.Y < 200 ||
+		len(chips[0].Actions) != 1 || chips[0].Actions[0].Action != "FIRST_ACTION" ||
+		len(chips[1].Actions) != 0 {
+		t.Errorf("Unexpected chips %+v", chips)
+	}
+}
+
+func TestAddNotes_LongChip(t *testing.T) {
+	dc := gg.NewContext(400, 200)
+	long := "An action label far too long for the notes panel"
+	overflows := []overflowNote{{marker: 1,
+		contextToTexts: map[string][]string{"A": {long}}}}
+	_, chips := addNotes(dc, testNotesData(LegendTopLeft), overflows, nil, 0, 1.0,
+		"../../resources/fonts", "#000000ff", "#000000ff", NewFontFaceCache())
+	// Truncated to fit in the panel
+	if len(chips) != 1 || chips[0].Rect.Max.X > 5+150 {
+		t.Errorf("Expected the chip inside the panel, got %+v", chips)
+	}
+}
+
+func TestTruncateText(t *testing.T) {
+	fontFace := loadFont("../../resources/fonts", "Dirga.ttf", 20)
+	if text := truncateText(fontFace, "Short", 1000); text != "Short" {
+		t.Errorf("Expected the text unchanged, got %s", text)
+	}
+	text := truncateText(fontFace, "Much longer text", 60)
+	if w, _ := measureString(fontFace, text); w > 60 ||
+		!strings.HasSuffix(text, "…") {
+		t.Errorf("Expected the text truncated to fit, got %s", text)
+	}
+	if text := truncateText(fontFace, "Text", 1); text != "" {
+		t.Errorf("Expected nothing to fit, got %s", text)
+	}
+}
+
+func TestAddNotes_Disabled(t *testing.T) {
+	dc := gg.NewContext(100, 100)
+	overflows := []overflowNote{{marker: 1}}
+	// No location and no fonts - would panic if it tried to draw
+	if result, _ := addNotes(dc, &NotesData{}, overflows, nil, 0, 1.0, "", "",
+		"", nil); result != dc {
+		t.Error("Expected the same image")
+	}
+	if result, _ := addNotes(dc, testNotesData(NotesPage), nil, nil, 0, 1.0, "",
+		"", "", nil); result != dc {
+		t.Error("Expected the same image without overflows")
+	}
+}
+
+func TestPopulateImage_Overflow(t *testing.T) {
+	overlays := map[string]OverlayData{
+		"fits": {PosAndSize: InputData{X: 10, Y: 10, W: 150, H: 30},
+			ContextToTexts: map[string][]string{"ctx": {"Fits"}}},
+		"overflows": {PosAndSize: InputData{X: 10, Y: 50, W: 30, H: 30},
+			ContextToTexts: map[string][]string{
+				"ctx": {"Far too much text", "for this small box"}}},
+	}
+	categories := map[string]string{"ctx": "#ff0000ff"}
+	config := &Config{
+		InputPixelXInset: 1,
+		InputPixelYInset: 1,
+		JpgQuality:       80,
+		FontsDir:         "../../resources/fonts",
+		InputFont:        "Dirga.ttf",
+		InputFontSize:    20,
+		InputMinFontSize: 10,
+		LightColour:      "#ffffffff",
+		BackgroundColour: "#ffffffff",
+		Notes:            *testNotesData(NotesPage),
+	}
+	log, _ := mockLogger()
+
+	buf, _, _ := populateImage(gg.NewContext(200, 200), "img.jpg",
+		image.Point{X: 200, Y: 200}, 1.0, overlays, categories, config, log,
+		NewFontFaceCache())
+	img, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to decode image %v", err)
+	}
+	if img.Bounds().Dy() <= 200 {
+		t.Errorf("Expected notes page appended, got height %d", img.Bounds().Dy())
+	}
+
+	// Without notes the text is squeezed in at the min font size
+	config.Notes = NotesData{}
+	buf, _, _ = populateImage(gg.NewContext(200, 200), "img.jpg",
+		image.Point{X: 200, Y: 200}, 1.0, overlays, categories, config, log,
+		NewFontFaceCache())
+	img, _ = jpeg.Decode(bytes.NewReader(buf.Bytes()))
+	if img.Bounds().Dy() != 200 {
+		t.Errorf("Expected unchanged height, got %d", img.Bounds().Dy())
+	}
+}
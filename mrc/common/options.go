@@ -0,0 +1,166 @@
+package common
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"strings"
+)
+
+// MinOutputScale is the smallest requested output size relative to the
+// configured size
+const MinOutputScale = 0.1
+
+// RequestOptions are the per request choices that change what gets rendered.
+// A nil *RequestOptions renders everything using the config defaults.
+type RequestOptions struct {
+	Contexts ContextFilter
+	Scale    float64 // Output size relative to the configured size. 0 for default
+	Width    int     // Output width in pixels, overrides Scale. 0 for default
+	Format   string  // Binding report format (see ReportFormats). Empty for images
+
+	// Header text. Title replaces the device label and profile.
+	Title    string
+	Subtitle string
+	Pilot    string // Pilot or squadron name
+
+	// Interactive web cards show chip details on hover. Not used by the CLI.
+	Interactive bool
+	// Tile all of a profile's device images onto one card. Scale and Width
+	// size each device image.
+	Composite bool
+}
+
+// MaxHeaderTextLength is the most characters accepted for each header text
+const MaxHeaderTextLength = 80
+
+// ContextFilter selects which game contexts are drawn on the cards. Patterns
+// are case insensitive globs (see path.Match) e.g. PLANE or *CAMERA*
+type ContextFilter struct {
+	Include []string // Only draw contexts matching one of these. Empty means all
+	Exclude []string // Never draw contexts matching one of these
+}
+
+// ParseContextFilter builds a filter from comma separated include and exclude
+// pattern lists. Invalid patterns are logged and ignored.
+func ParseContextFilter(include string, exclude string, log *Logger) ContextFilter {
+	return ContextFilter{
+		Include: parseContextPatterns(include, log),
+		Exclude: parseContextPatterns(exclude, log),
+	}
+}
+
+func parseContextPatterns(value string, log *Logger) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(value, ",") {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if len(pattern) == 0 {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			log.ErrAs(ErrorOption, "Ignoring invalid context pattern \"%s\"", pattern)
+			continue
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
+// IsEmpty returns true if the filter allows every context
+func (f *ContextFilter) IsEmpty() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0
+}
+
+// Allows returns true if the context should be drawn
+func (f *ContextFilter) Allows(context string) bool {
+	context = strings.ToLower(context)
+	if len(f.Include) > 0 && !matchesAnyPattern(context, f.Include) {
+		return false
+	}
+	return !matchesAnyPattern(context, f.Exclude)
+}
+
+func matchesAnyPattern(context string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, context); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsContext returns true if the context should be drawn for this request
+func (o *RequestOptions) allowsContext(context string) bool {
+	return o == nil || o.Contexts.Allows(context)
+}
+
+// ShownContexts returns the contexts of the binds drawn for this request,
+// with their colour in colours if they have one. Contexts filtered out are
+// left out so they don't take a colour from the palette.
+func (o *RequestOptions) ShownContexts(gameBinds GameBindsByProfile,
+	colours ContextToColours) ContextToColours {
+	shown := make(ContextToColours)
+	for _, devices := range gameBinds {
+		for _, contexts := range devices {
+			for context := range contexts {
+				if o.allowsContext(context) {
+					shown[context] = colours[context]
+				}
+			}
+		}
+	}
+	return shown
+}
+
+// outputScale returns how much to resize an image sourceWidth pixels wide by,
+// limited to between MinOutputScale and MaxOutputScale. A requested width is
+// relative to the image's own width so every card comes out that wide.
+func (o *RequestOptions) outputScale(config *Config, sourceWidth float64) float64 {
+	scale := 1.0
+	if o != nil && o.Width > 0 {
+		scale = float64(o.Width) / sourceWidth
+	} else if o != nil && o.Scale > 0 {
+		scale = o.Scale
+	}
+	if maxScale := math.Max(config.MaxOutputScale, 1); scale > maxScale {
+		scale = maxScale
+	}
+	if scale < MinOutputScale {
+		scale = MinOutputScale
+	}
+	return scale
+}
+
+// headerText returns the text for a card's header. By default it's the device
+// label, followed by the profile if it isn't the default profile. A title goes
+// before the device label so cards of different devices can be told apart.
+func (o *RequestOptions) headerText(deviceLabel string, profile string) string {
+	text := deviceLabel
+	if profile != ProfileDefault {
+		text = fmt.Sprintf("%s (%s)", text, profile)
+	}
+	if o == nil {
+		return text
+	}
+	if len(o.Title) > 0 && len(text) > 0 {
+		text = fmt.Sprintf("%s — %s", o.Title, text)
+	} else if len(o.Title) > 0 {
+		text = o.Title
+	}
+	if len(o.Subtitle) > 0 {
+		text = fmt.Sprintf("%s — %s", text, o.Subtitle)
+	}
+	if len(o.Pilot) > 0 {
+		text = fmt.Sprintf("%s | %s", text, o.Pilot)
+	}
+	return text
+}
+
+// CleanHeaderText trims a requested header text and limits its length
+func CleanHeaderText(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > MaxHeaderTextLength {
+		text = strings.TrimSpace(string(runes[:MaxHeaderTextLength]))
+	}
+	return text
+}
@@ -0,0 +1,146 @@
+package common
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseContextFilter(t *testing.T) {
+	log, _ := mockLogger()
+	filter := ParseContextFilter(" PLANE, *Camera* ,", "[bad, MENU", log)
+	if len(filter.Include) != 2 || filter.Include[0] != "plane" ||
+		filter.Include[1] != "*camera*" {
+		t.Errorf("Unexpected include patterns %v", filter.Include)
+	}
+	if len(filter.Exclude) != 1 || filter.Exclude[0] != "menu" {
+		t.Errorf("Unexpected exclude patterns %v", filter.Exclude)
+	}
+	if len(log.Entries) != 1 || !log.Entries[0].IsError {
+		t.Errorf("Expected invalid pattern to be logged %v", log.Entries)
+	}
+}
+
+func TestContextFilter_Allows(t *testing.T) {
+	log, _ := mockLogger()
+	var empty ContextFilter
+	if !empty.IsEmpty() || !empty.Allows("PLANE") {
+		t.Error("Empty filter should allow everything")
+	}
+
+	include := ParseContextFilter("plane,*CAMERA", "", log)
+	for context, expected := range map[string]bool{
+		"PLANE": true, "COCKPIT_CAMERA": true, "MENU": false} {
+		if include.Allows(context) != expected {
+			t.Errorf("Include filter allows %s should be %v", context, expected)
+		}
+	}
+
+	exclude := ParseContextFilter("", "MENU,DRONE*", log)
+	for context, expected := range map[string]bool{
+		"PLANE": true, "MENU": false, "DRONE_CAMERA": false} {
+		if exclude.Allows(context) != expected {
+			t.Errorf("Exclude filter allows %s should be %v", context, expected)
+		}
+	}
+
+	// Exclude wins over include
+	both := ParseContextFilter("*CAMERA", "DRONE*", log)
+	if both.Allows("DRONE_CAMERA") || !both.Allows("COCKPIT_CAMERA") {
+		t.Error("Exclude should override include")
+	}
+}
+
+func TestRequestOptions_ShownContexts(t *testing.T) {
+	var nilOpts *RequestOptions
+	if !nilOpts.allowsContext("ANY") {
+		t.Error("nil options should allow everything")
+	}
+
+	log, _ := mockLogger()
+	opts := &RequestOptions{Contexts: ParseContextFilter("", "*CAMERA", log)}
+	binds := GameBindsByProfile{
+		"Default": GameDeviceContextActions{"d1": GameContextActions{
+			"PLANE": nil, "EXTERNAL_CAMERA": nil}},
+		"Hornet": GameDeviceContextActions{"d2": GameContextActions{
+			"MENU": nil, "COCKPIT_CAMERA": nil}},
+	}
+	shown := opts.ShownContexts(binds, ContextToColours{"PLANE": "#ff0000ff",
+		"COCKPIT_CAMERA": "#00ff00ff"})
+	if len(shown) != 2 || shown["PLANE"] != "#ff0000ff" || shown["MENU"] != "" {
+		t.Errorf("Unexpected shown contexts %v", shown)
+	}
+	if shown := nilOpts.ShownContexts(binds, nil); len(shown) != 4 {
+		t.Errorf("Expected every context shown, got %v", shown)
+	}
+}
+
+func TestRequestOptions_OutputScale(t *testing.T) {
+	config := &Config{DefaultImage: Dimensions2d{W: 3840, H: 2160},
+		PixelMultiplier: 0.5, MaxOutputScale: 2}
+	var nilOpts *RequestOptions
+	for _, test := range []struct {
+		opts     *RequestOptions
+		expected float64
+	}{
+		{nilOpts, 1},
+		{&RequestOptions{}, 1},
+		{&RequestOptions{Scale: 0.5}, 0.5},
+		{&RequestOptions{Width: 3840}, 2},
+		{&RequestOptions{Width: 960, Scale: 2}, 0.5}, // Width wins
+		{&RequestOptions{Scale: 10}, 2},
+		{&RequestOptions{Scale: 0.01}, MinOutputScale},
+	} {
+		if scale := test.opts.outputScale(config, 1920); scale != test.expected {
+			t.Errorf("Scale for %+v should be %v, got %v", test.opts,
+				test.expected, scale)
+		}
+	}
+
+	// A width is relative to the image's own width
+	if scale := (&RequestOptions{Width: 1280}).outputScale(config, 2560); scale != 0.5 {
+		t.Errorf("Expected scale 0.5 for a wider image, got %v", scale)
+	}
+
+	// Without a maximum, outputs can only be shrunk
+	config.MaxOutputScale = 0
+	if scale := (&RequestOptions{Scale: 3}).outputScale(config, 1920); scale != 1 {
+		t.Errorf("Expected scale 1 without a maximum, got %v", scale)
+	}
+	if scale := (&RequestOptions{Scale: 0.5}).outputScale(config, 1920); scale != 0.5 {
+		t.Errorf("Expected scale 0.5 without a maximum, got %v", scale)
+	}
+}
+
+func TestRequestOptions_HeaderText(t *testing.T) {
+	var nilOpts *RequestOptions
+	for _, test := range []struct {
+		opts     *RequestOptions
+		profile  string
+		expected string
+	}{
+		{nilOpts, ProfileDefault, "Stick"},
+		{nilOpts, "Airbus", "Stick (Airbus)"},
+		{&RequestOptions{}, "Airbus", "Stick (Airbus)"},
+		{&RequestOptions{Title: "A320"}, "Airbus", "A320 — Stick (Airbus)"},
+		{&RequestOptions{Title: "A320", Subtitle: "Captain side"}, ProfileDefault,
+			"A320 — Stick — Captain side"},
+		{&RequestOptions{Subtitle: "Captain side", Pilot: "Maverick"}, ProfileDefault,
+			"Stick — Captain side | Maverick"},
+	} {
+		if text := test.opts.headerText("Stick", test.profile); text != test.expected {
+			t.Errorf("Header for %+v should be %q, got %q", test.opts,
+				test.expected, text)
+		}
+	}
+}
+
+func TestCleanHeaderText(t *testing.T) {
+	if text := CleanHeaderText("  A320 \n Captain\tside "); text != "A320 Captain side" {
+		t.Errorf("Expected whitespace collapsed, got %q", text)
+	}
+	long := strings.Repeat("ä", MaxHeaderTextLength+10)
+	if text := CleanHeaderText(long); len([]rune(text)) != MaxHeaderTextLength {
+		t.Errorf("Expected %d characters, got %d", MaxHeaderTextLength,
+			len([]rune(text)))
+	}
+}
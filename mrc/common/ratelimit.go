@@ -0,0 +1,76 @@
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimitSweepInterval - how often idle clients' buckets are dropped
+const rateLimitSweepInterval = time.Minute
+
+// RateLimiter limits each client's requests with a token bucket. A bucket
+// holds up to burst tokens, refills at a steady rate and each request takes a
+// token. Buckets of clients idle long enough to have refilled are dropped.
+type RateLimiter struct {
+	lock      sync.Mutex
+	rate      float64 // Tokens per second
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewRateLimiter returns the configured rate limiter or nil if rate limiting
+// is disabled
+func NewRateLimiter(data RateLimitData) *RateLimiter {
+	if data.RequestsPerMinute <= 0 {
+		return nil
+	}
+	return &RateLimiter{
+		rate:    float64(data.RequestsPerMinute) / 60,
+		burst:   float64(max(data.Burst, 1)),
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token from the client's bucket. If the bucket is empty the
+// request isn't allowed and the wait until there's a token is returned.
+func (l *RateLimiter) Allow(client string) (bool, time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := l.now()
+	l.sweep(now)
+	bucket, found := l.buckets[client]
+	if !found {
+		bucket = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[client] = bucket
+	}
+	bucket.tokens = min(l.burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*l.rate)
+	bucket.updated = now
+	if bucket.tokens < 1 {
+		wait := (1 - bucket.tokens) / l.rate
+		return false, time.Duration(wait * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// sweep drops the buckets of clients idle long enough to have refilled
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	l.lastSweep = now
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+	for client, bucket := range l.buckets {
+		if now.Sub(bucket.updated) >= refill {
+			delete(l.buckets, client)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	if NewRateLimiter(RateLimitData{}) != nil {
+		t.Error("Expected no limiter when disabled")
+	}
+	limiter := NewRateLimiter(RateLimitData{RequestsPerMinute: 60, Burst: 2})
+	now := time.Unix(1000, 0)
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if allowed, _ := limiter.Allow("a"); !allowed {
+			t.Fatalf("Expected request %d allowed", i)
+		}
+	}
+	allowed, wait := limiter.Allow("a")
+	if allowed || wait != time.Second {
+		t.Errorf("Expected a 1s wait, got %v %v", allowed, wait)
+	}
+	// Other clients have their own bucket
+	if allowed, _ := limiter.Allow("b"); !allowed {
+		t.Error("Expected another client allowed")
+	}
+	// Tokens refill over time
+	now = now.Add(500 * time.Millisecond)
+	if allowed, wait := limiter.Allow("a"); allowed || wait != 500*time.Millisecond {
+		t.Errorf("Expected a 500ms wait, got %v %v", allowed, wait)
+	}
+	now = now.Add(500 * time.Millisecond)
+	if allowed, _ := limiter.Allow("a"); !allowed {
+		t.Error("Expected a refilled token")
+	}
+
+	// Idle clients are swept once their bucket has refilled
+	now = now.Add(time.Hour)
+	limiter.Allow("c")
+	if _, found := limiter.buckets["a"]; found || len(limiter.buckets) != 1 {
+		t.Errorf("Expected idle buckets swept, got %d", len(limiter.buckets))
+	}
+}
+
+func TestRateLimiter_MinimumBurst(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitData{RequestsPerMinute: 1})
+	if allowed, _ := limiter.Allow("a"); !allowed {
+		t.Error("Expected the first request allowed")
+	}
+	if allowed, _ := limiter.Allow("a"); allowed {
+		t.Error("Expected the second request limited")
+	}
+}
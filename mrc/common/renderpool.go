@@ -0,0 +1,60 @@
+package common
+
+import "sync"
+
+// renderPool bounds the images rendered at once across requests
+var renderPool = NewRenderPool(0)
+
+// RenderPool bounds how many images are rendered at once. Each render holds
+// a full size decoded image so this bounds memory as well as CPU.
+type RenderPool struct {
+	lock    sync.Mutex
+	cond    *sync.Cond
+	workers int // 0 for no limit
+	running int
+	waiting int
+}
+
+// NewRenderPool returns a pool running at most workers renders at once. 0
+// workers doesn't limit renders.
+func NewRenderPool(workers int) *RenderPool {
+	pool := &RenderPool{workers: workers}
+	pool.cond = sync.NewCond(&pool.lock)
+	return pool
+}
+
+// SetWorkers changes the number of workers, starting waiting renders if there
+// are more
+func (p *RenderPool) SetWorkers(workers int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.workers = workers
+	p.cond.Broadcast()
+}
+
+// Stats returns the number of renders running and waiting for a worker
+func (p *RenderPool) Stats() (running int, waiting int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.running, p.waiting
+}
+
+// Acquire waits for a free worker. Call Release once the render is done.
+func (p *RenderPool) Acquire() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.waiting++
+	for p.workers > 0 && p.running >= p.workers {
+		p.cond.Wait()
+	}
+	p.waiting--
+	p.running++
+}
+
+// Release frees the worker for the next render
+func (p *RenderPool) Release() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.running--
+	p.cond.Signal()
+}
@@ -0,0 +1,59 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRenderPool(t *testing.T) {
+	pool := NewRenderPool(1)
+	pool.Acquire()
+	acquired := make(chan bool)
+	go func() {
+		pool.Acquire()
+		acquired <- true
+	}()
+	// The second render waits for the first
+	for deadline := time.Now().Add(time.Second); ; {
+		if _, waiting := pool.Stats(); waiting == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected a waiting render")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-acquired:
+		t.Fatal("Expected the second render to wait")
+	default:
+	}
+	pool.Release()
+	<-acquired
+	if running, waiting := pool.Stats(); running != 1 || waiting != 0 {
+		t.Errorf("Expected 1 running, got %d running %d waiting", running, waiting)
+	}
+	pool.Release()
+
+	// More workers start waiting renders
+	pool.Acquire()
+	go func() {
+		pool.Acquire()
+		acquired <- true
+	}()
+	pool.SetWorkers(2)
+	<-acquired
+	if running, _ := pool.Stats(); running != 2 {
+		t.Errorf("Expected 2 running, got %d", running)
+	}
+}
+
+func TestRenderPool_Unlimited(t *testing.T) {
+	pool := NewRenderPool(0)
+	for i := 0; i < 100; i++ {
+		pool.Acquire()
+	}
+	if running, waiting := pool.Stats(); running != 100 || waiting != 0 {
+		t.Errorf("Expected 100 running, got %d running %d waiting", running, waiting)
+	}
+}
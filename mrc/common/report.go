@@ -0,0 +1,200 @@
+package common
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	// ReportMarkdown - Markdown tables grouped by profile, device and context
+	ReportMarkdown = "markdown"
+	// ReportCSV - one CSV row per binding
+	ReportCSV = "csv"
+	// ReportText - aligned plain text grouped by profile, device and context
+	ReportText = "text"
+)
+
+// ReportFormats maps report formats to their file extension and content type
+var ReportFormats = map[string]struct{ Extension, ContentType string }{
+	ReportMarkdown: {"md", "text/markdown; charset=utf-8"},
+	ReportCSV:      {"csv", "text/csv; charset=utf-8"},
+	ReportText:     {"txt", "text/plain; charset=utf-8"},
+}
+
+// BindingRow is a single game action and the inputs it is bound to
+type BindingRow struct {
+	Profile   string
+	Device    string
+	Context   string
+	Action    string
+	Label     string // Game label for the action, the action if there isn't one
+	Primary   string // Game's name for the primary input
+	Secondary string // Game's name for the secondary input. Might be empty
+	Input     string // MetaRefCard inputs the game inputs resolved to
+}
+
+// BindingRows flattens the game binds into rows sorted by profile, device,
+// context and action. Contexts filtered out by the request options are skipped.
+func BindingRows(neededDevices Set, config *Config, log *Logger,
+	gameBindsByProfile GameBindsByProfile, gameData GameData,
+	matchFunc FuncMatchGameInputToModel, opts *RequestOptions) []BindingRow {
+
+	deviceMap := FilterDevices(neededDevices, config, log)
+	var rows []BindingRow
+	for profile, gameBinds := range gameBindsByProfile {
+		for shortName, gameDevice := range gameBinds {
+			for context, actions := range gameDevice {
+				if !opts.allowsContext(context) {
+					continue
+				}
+				for actionName, gameInput := range actions {
+					row := BindingRow{Profile: profile, Device: shortName,
+						Context: context, Action: actionName, Label: actionName}
+					if label, found := gameData.InputLabels[actionName]; found {
+						row.Label = label
+					}
+					if len(gameInput) > InputPrimary {
+						row.Primary = gameInput[InputPrimary]
+					}
+					if len(gameInput) > InputSecondary {
+						row.Secondary = gameInput[InputSecondary]
+					}
+					inputLookups, _ := matchFunc(shortName, gameInput,
+						deviceMap[shortName], gameData.InputMap[shortName], log)
+					var inputs []string
+					for _, input := range inputLookups {
+						if len(input) > 0 {
+							inputs = append(inputs, input)
+						}
+					}
+					row.Input = strings.Join(inputs, " / ")
+					rows = append(rows, row)
+				}
+			}
+		}
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if a.Profile != b.Profile {
+			return a.Profile < b.Profile
+		}
+		if a.Device != b.Device {
+			return a.Device < b.Device
+		}
+		if a.Context != b.Context {
+			return a.Context < b.Context
+		}
+		return a.Action < b.Action
+	})
+	return rows
+}
+
+// WriteBindingReport writes the rows in the report format
+func WriteBindingReport(w io.Writer, format string, rows []BindingRow) error {
+	switch format {
+	case ReportMarkdown:
+		return writeMarkdownReport(w, rows)
+	case ReportCSV:
+		return writeCSVReport(w, rows)
+	case ReportText:
+		return writeTextReport(w, rows)
+	}
+	return fmt.Errorf("unsupported report format %s", format)
+}
+
+func writeCSVReport(w io.Writer, rows []BindingRow) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"Profile", "Device", "Context", "Action", "Label",
+		"Primary", "Secondary", "Input"})
+	for _, row := range rows {
+		// Names come from uploaded files
+		writer.Write([]string{csvSafe(row.Profile), csvSafe(row.Device),
+			csvSafe(row.Context), csvSafe(row.Action), csvSafe(row.Label),
+			row.Primary, row.Secondary, row.Input})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func writeMarkdownReport(w io.Writer, rows []BindingRow) error {
+	cell := strings.NewReplacer("|", "\\|", "\n", " ").Replace
+	var b strings.Builder
+	forEachGroup(rows, func(level int, heading string) {
+		if level == groupContext {
+			fmt.Fprintf(&b, "#### %s\n\n", cell(heading))
+			b.WriteString("| Label | Action | Primary | Secondary | Input |\n")
+			b.WriteString("| --- | --- | --- | --- | --- |\n")
+			return
+		}
+		fmt.Fprintf(&b, "%s %s\n\n", strings.Repeat("#", level+2), cell(heading))
+	}, func(row BindingRow, last bool) {
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %s |\n", cell(row.Label),
+			cell(row.Action), cell(row.Primary), cell(row.Secondary),
+			cell(row.Input))
+		if last {
+			b.WriteString("\n")
+		}
+	})
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeTextReport(w io.Writer, rows []BindingRow) error {
+	var b strings.Builder
+	var table *tabwriter.Writer
+	forEachGroup(rows, func(level int, heading string) {
+		fmt.Fprintf(&b, "%s%s\n", strings.Repeat("  ", level), heading)
+		if level == groupContext {
+			table = tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+		}
+	}, func(row BindingRow, last bool) {
+		fmt.Fprintf(table, "      %s\t%s\t%s\t%s\n", row.Label, row.Primary,
+			row.Secondary, row.Input)
+		if last {
+			table.Flush()
+		}
+	})
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+const (
+	groupProfile = iota
+	groupDevice
+	groupContext
+)
+
+// forEachGroup calls heading for every new profile, device and context and
+// row for every row, flagging the last row of a context
+func forEachGroup(rows []BindingRow, heading func(level int, heading string),
+	row func(row BindingRow, last bool)) {
+	for idx, current := range rows {
+		var previous BindingRow
+		if idx > 0 {
+			previous = rows[idx-1]
+		}
+		newProfile := idx == 0 || current.Profile != previous.Profile
+		newDevice := newProfile || current.Device != previous.Device
+		if newProfile {
+			heading(groupProfile, current.Profile)
+		}
+		if newDevice {
+			heading(groupDevice, current.Device)
+		}
+		if newDevice || current.Context != previous.Context {
+			heading(groupContext, current.Context)
+		}
+		last := idx == len(rows)-1
+		if !last {
+			next := rows[idx+1]
+			last = next.Profile != current.Profile ||
+				next.Device != current.Device || next.Context != current.Context
+		}
+		row(current, last)
+	}
+}
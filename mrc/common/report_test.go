@@ -0,0 +1,137 @@
+package common
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func testBindingRows(t *testing.T, opts *RequestOptions) []BindingRow {
+	log, _ := mockLogger()
+	config := &Config{
+		Devices: Devices{
+			Index: DeviceMap{"d1": DeviceInputs{"btn1": InputData{X: 10, Y: 10}}},
+		},
+	}
+	binds := GameBindsByProfile{
+		"Default": GameDeviceContextActions{
+			"d1": GameContextActions{
+				"PLANE": GameActions{
+					"GEAR":  {"Button 1", "Button 2"},
+					"FLAPS": {"Button 3", ""},
+				},
+				"COCKPIT_CAMERA": GameActions{"ZOOM": {"Button 4", ""}},
+			},
+		},
+	}
+	gameData := GameData{InputLabels: map[string]string{"GEAR": "Gear | Toggle"}}
+	matchFunc := func(deviceName string, actionData GameInput,
+		deviceInputs DeviceInputs, gameInputMap InputTypeMapping, log *Logger) (GameInput, string) {
+		inputs := GameInput{strings.TrimPrefix(actionData[InputPrimary], "Button "), ""}
+		if len(actionData[InputSecondary]) > 0 {
+			inputs[InputSecondary] = strings.TrimPrefix(actionData[InputSecondary], "Button ")
+		}
+		return inputs, "label"
+	}
+	return BindingRows(Set{"d1": true}, config, log, binds, gameData, matchFunc, opts)
+}
+
+func TestBindingRows(t *testing.T) {
+	rows := testBindingRows(t, nil)
+	if len(rows) != 3 {
+		t.Fatalf("Expected 3 rows, got %d", len(rows))
+	}
+	// Sorted by context then action
+	if rows[0].Action != "ZOOM" || rows[1].Action != "FLAPS" || rows[2].Action != "GEAR" {
+		t.Errorf("Unexpected order %v", rows)
+	}
+	gear := rows[2]
+	if gear.Label != "Gear | Toggle" || gear.Primary != "Button 1" ||
+		gear.Secondary != "Button 2" || gear.Input != "1 / 2" {
+		t.Errorf("Unexpected row %+v", gear)
+	}
+	if rows[1].Label != "FLAPS" || rows[1].Input != "3" {
+		t.Errorf("Missing label should fall back to the action, got %+v", rows[1])
+	}
+
+	log, _ := mockLogger()
+	filtered := testBindingRows(t,
+		&RequestOptions{Contexts: ParseContextFilter("", "*camera", log)})
+	if len(filtered) != 2 || filtered[0].Context != "PLANE" {
+		t.Errorf("Expected camera context filtered, got %v", filtered)
+	}
+}
+
+func TestWriteBindingReport_CSV(t *testing.T) {
+	var report bytes.Buffer
+	if err := WriteBindingReport(&report, ReportCSV, testBindingRows(t, nil)); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(report.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Expected header and 3 rows, got %v", lines)
+	}
+	if lines[0] != "Profile,Device,Context,Action,Label,Primary,Secondary,Input" {
+		t.Errorf("Unexpected header %s", lines[0])
+	}
+	if lines[3] != "Default,d1,PLANE,GEAR,Gear | Toggle,Button 1,Button 2,1 / 2" {
+		t.Errorf("Unexpected row %s", lines[3])
+	}
+	// Names that a spreadsheet would run as formulas are quoted
+	report.Reset()
+	WriteBindingReport(&report, ReportCSV, []BindingRow{{Profile: "+p", Device: "=d",
+		Context: "@c", Action: "-a", Label: "=HYPERLINK(\"x\")", Primary: "Button 1"}})
+	lines = strings.Split(strings.TrimSpace(report.String()), "\n")
+	if lines[1] != `'+p,'=d,'@c,'-a,"'=HYPERLINK(""x"")",Button 1,,` {
+		t.Errorf("Expected the names made safe, got %s", lines[1])
+	}
+}
+
+func TestWriteBindingReport_Markdown(t *testing.T) {
+	var report bytes.Buffer
+	if err := WriteBindingReport(&report, ReportMarkdown, testBindingRows(t, nil)); err != nil {
+		t.Fatal(err)
+	}
+	text := report.String()
+	for _, expected := range []string{
+		"## Default\n", "### d1\n", "#### COCKPIT_CAMERA\n", "#### PLANE\n",
+		"| Gear \\| Toggle | GEAR | Button 1 | Button 2 | 1 / 2 |\n",
+	} {
+		if !strings.Contains(text, expected) {
+			t.Errorf("Expected %q in\n%s", expected, text)
+		}
+	}
+	if strings.Count(text, "## Default") != 1 || strings.Count(text, "| Label |") != 2 {
+		t.Errorf("Expected one profile heading and a table per context\n%s", text)
+	}
+}
+
+func TestWriteBindingReport_Text(t *testing.T) {
+	var report bytes.Buffer
+	if err := WriteBindingReport(&report, ReportText, testBindingRows(t, nil)); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(report.String(), "\n")
+	if lines[0] != "Default" || lines[1] != "  d1" || lines[2] != "    COCKPIT_CAMERA" {
+		t.Errorf("Unexpected headings %v", lines[:3])
+	}
+	// Columns are aligned within a context
+	flaps, gear := lines[5], lines[6]
+	if strings.Index(flaps, "Button 3") != strings.Index(gear, "Button 1") {
+		t.Errorf("Columns not aligned\n%s\n%s", flaps, gear)
+	}
+}
+
+func TestWriteBindingReport_Unsupported(t *testing.T) {
+	var report bytes.Buffer
+	if err := WriteBindingReport(&report, "pdf", nil); err == nil {
+		t.Error("Expected error for unsupported format")
+	}
+	for format := range ReportFormats {
+		report.Reset()
+		if err := WriteBindingReport(&report, format, nil); err != nil ||
+			(format != ReportCSV && report.Len() != 0) {
+			t.Errorf("Empty %s report failed: %v %q", format, err, report.String())
+		}
+	}
+}
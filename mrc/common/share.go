@@ -0,0 +1,281 @@
+package common
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Share store backends
+const (
+	ShareStoreFilesystem = "Filesystem"
+	ShareStoreSQLite     = "SQLite"
+)
+
+// SharePath is where shared cards are viewed
+const SharePath = "/card/"
+
+// shareIDBytes - random bytes in a share ID, 8 characters once encoded
+const shareIDBytes = 6
+
+// shareIDAttempts - new IDs tried before giving up on saving a shared card
+const shareIDAttempts = 5
+
+// sharePruneInterval - how often expired shared cards are removed
+const sharePruneInterval = time.Hour
+
+// shareIDRegex matches the IDs newShareID returns. Anything else isn't looked
+// up so IDs can't escape the store's directory.
+var shareIDRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{8}$`)
+
+// ErrShareNotFound is returned for shared cards that don't exist or expired
+var ErrShareNotFound = errors.New("shared card not found")
+
+// errShareExists is returned by backends when the new ID is already used
+var errShareExists = errors.New("shared card already exists")
+
+// SharedCard is what's saved for a shareable link, the parsed bindings and
+// how to render them. Cards are rendered again on demand so they use the
+// current layouts. The uploaded files aren't kept.
+type SharedCard struct {
+	Game     string
+	Version  string   // MetaRefCard version that saved the card
+	Sources  []string // SHA-256 of the input files, hex encoded
+	Bindings GameBindsByProfile
+	Axes     GameAxesByProfile `json:",omitempty"`
+	Options  RequestOptions
+	Created  time.Time
+	Expires  time.Time // Zero for no expiry
+	// SHA-256 of the token needed to delete the card, hex encoded
+	DeleteTokenHash string
+}
+
+// ShareResponse is sent after saving a shared card. The delete token is only
+// ever sent here.
+type ShareResponse struct {
+	ID          string     `json:",omitempty"`
+	URL         string     `json:",omitempty"`
+	DeleteToken string     `json:",omitempty"`
+	Expires     *time.Time `json:",omitempty"`
+	Error       string     `json:",omitempty"`
+	Logs        []*LogEntry
+}
+
+// ShareStore saves shared cards under short random IDs
+type ShareStore interface {
+	// Create saves the card under a new ID and returns the ID
+	Create(card *SharedCard) (string, error)
+	// Load returns the card or ErrShareNotFound if it doesn't exist or expired
+	Load(id string) (*SharedCard, error)
+	// Delete removes the card or returns ErrShareNotFound
+	Delete(id string) error
+}
+
+// NewShareStore returns the configured share store or nil if sharing is
+// disabled
+func NewShareStore(data ShareData) (ShareStore, error) {
+	switch data.Backend {
+	case "":
+		return nil, nil
+	case ShareStoreFilesystem:
+		store, err := NewFileShareStore(data.Path)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	case ShareStoreSQLite:
+		return newSQLiteShareStore(data.Path)
+	}
+	return nil, fmt.Errorf("unknown share store backend %s", data.Backend)
+}
+
+// NewSharedCard returns the card to save for the bindings and options, and
+// the token that deletes it. A ttl of 0 never expires.
+func NewSharedCard(game string, version string, sources []string,
+	gameBinds GameBindsByProfile, gameAxes GameAxesByProfile,
+	opts *RequestOptions, ttl time.Duration) (*SharedCard, string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, "", err
+	}
+	deleteToken := hex.EncodeToString(token)
+	card := &SharedCard{
+		Game:            game,
+		Version:         version,
+		Sources:         sources,
+		Bindings:        gameBinds,
+		Axes:            gameAxes,
+		Created:         time.Now().UTC(),
+		DeleteTokenHash: hashShareToken(deleteToken),
+	}
+	if opts != nil {
+		card.Options = *opts
+		card.Options.Format = "" // Shared cards are always images
+	}
+	if ttl > 0 {
+		card.Expires = card.Created.Add(ttl)
+	}
+	return card, deleteToken, nil
+}
+
+// CheckDeleteToken returns true if the token deletes the card
+func (s *SharedCard) CheckDeleteToken(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashShareToken(token)),
+		[]byte(s.DeleteTokenHash)) == 1
+}
+
+// Expired returns true if the card expired at the time
+func (s *SharedCard) Expired(now time.Time) bool {
+	return !s.Expires.IsZero() && now.After(s.Expires)
+}
+
+func hashShareToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newShareID returns a short, random, URL safe ID
+func newShareID() (string, error) {
+	id := make([]byte, shareIDBytes)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(id), nil
+}
+
+// createShare saves the card with save under new IDs until one isn't taken
+func createShare(save func(id string) error) (string, error) {
+	for attempt := 0; attempt < shareIDAttempts; attempt++ {
+		id, err := newShareID()
+		if err != nil {
+			return "", err
+		}
+		err = save(id)
+		if err == nil {
+			return id, nil
+		}
+		if !errors.Is(err, errShareExists) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("no unused ID after %d attempts", shareIDAttempts)
+}
+
+// FileShareStore saves each shared card as a JSON file in a directory
+type FileShareStore struct {
+	dir       string
+	lock      sync.Mutex
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+// NewFileShareStore returns a store saving cards in dir, creating the
+// directory if needed
+func NewFileShareStore(dir string) (*FileShareStore, error) {
+	if len(dir) == 0 {
+		return nil, fmt.Errorf("share store directory not set")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileShareStore{dir: dir, now: time.Now}, nil
+}
+
+// Create saves the card under a new ID. Expired cards are removed every so
+// often.
+func (s *FileShareStore) Create(card *SharedCard) (string, error) {
+	s.prune()
+	data, err := json.Marshal(card)
+	if err != nil {
+		return "", err
+	}
+	return createShare(func(id string) error {
+		file, err := os.OpenFile(s.path(id), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if errors.Is(err, os.ErrExist) {
+			return errShareExists
+		} else if err != nil {
+			return err
+		}
+		_, err = file.Write(data)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(file.Name())
+		}
+		return err
+	})
+}
+
+// Load returns the card or ErrShareNotFound if it doesn't exist or expired
+func (s *FileShareStore) Load(id string) (*SharedCard, error) {
+	if !shareIDRegex.MatchString(id) {
+		return nil, ErrShareNotFound
+	}
+	data, err := os.ReadFile(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrShareNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	var card SharedCard
+	if err := json.Unmarshal(data, &card); err != nil {
+		return nil, err
+	}
+	if card.Expired(s.now()) {
+		os.Remove(s.path(id))
+		return nil, ErrShareNotFound
+	}
+	return &card, nil
+}
+
+// Delete removes the card or returns ErrShareNotFound
+func (s *FileShareStore) Delete(id string) error {
+	if !shareIDRegex.MatchString(id) {
+		return ErrShareNotFound
+	}
+	err := os.Remove(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrShareNotFound
+	}
+	return err
+}
+
+func (s *FileShareStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// prune removes expired cards if it hasn't done so recently
+func (s *FileShareStore) prune() {
+	s.lock.Lock()
+	if s.now().Sub(s.lastPrune) < sharePruneInterval {
+		s.lock.Unlock()
+		return
+	}
+	s.lastPrune = s.now()
+	s.lock.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		id := strings.TrimSuffix(entry.Name(), ".json")
+		if !shareIDRegex.MatchString(id) {
+			continue
+		}
+		// Load removes expired cards
+		s.Load(id)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !sqlite
+
+package common
+
+import "fmt"
+
+// newSQLiteShareStore fails, SQLite is only built in with the sqlite tag
+func newSQLiteShareStore(path string) (ShareStore, error) {
+	return nil, fmt.Errorf("built without SQLite, build with -tags sqlite")
+}
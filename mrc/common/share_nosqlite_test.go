@@ -0,0 +1,12 @@
+//go:build !sqlite
+
+package common
+
+import "testing"
+
+func TestNewShareStore_NoSQLite(t *testing.T) {
+	if store, err := NewShareStore(ShareData{Backend: ShareStoreSQLite,
+		Path: "shared.db"}); store != nil || err == nil {
+		t.Errorf("Expected an error without SQLite, got %v %v", store, err)
+	}
+}
@@ -0,0 +1,120 @@
+//go:build sqlite
+
+package common
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite" // Registers the sqlite driver
+)
+
+// SQLiteShareStore saves shared cards in a SQLite database
+type SQLiteShareStore struct {
+	db        *sql.DB
+	lock      sync.Mutex
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+// newSQLiteShareStore opens, or creates, the database at path
+func newSQLiteShareStore(path string) (ShareStore, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("share store database not set")
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// Writes are serialised by SQLite anyway
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS shared_cards (
+		id TEXT PRIMARY KEY,
+		expires INTEGER NOT NULL, -- Unix seconds. 0 for no expiry
+		card BLOB NOT NULL
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteShareStore{db: db, now: time.Now}, nil
+}
+
+// Create saves the card under a new ID. Expired cards are removed every so
+// often.
+func (s *SQLiteShareStore) Create(card *SharedCard) (string, error) {
+	s.prune()
+	data, err := json.Marshal(card)
+	if err != nil {
+		return "", err
+	}
+	var expires int64
+	if !card.Expires.IsZero() {
+		expires = card.Expires.Unix()
+	}
+	return createShare(func(id string) error {
+		result, err := s.db.Exec(
+			"INSERT OR IGNORE INTO shared_cards (id, expires, card) VALUES (?, ?, ?)",
+			id, expires, data)
+		if err != nil {
+			return err
+		}
+		if rows, err := result.RowsAffected(); err != nil {
+			return err
+		} else if rows == 0 {
+			return errShareExists
+		}
+		return nil
+	})
+}
+
+// Load returns the card or ErrShareNotFound if it doesn't exist or expired
+func (s *SQLiteShareStore) Load(id string) (*SharedCard, error) {
+	var data []byte
+	err := s.db.QueryRow("SELECT card FROM shared_cards WHERE id = ?", id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrShareNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	var card SharedCard
+	if err := json.Unmarshal(data, &card); err != nil {
+		return nil, err
+	}
+	if card.Expired(s.now()) {
+		s.Delete(id)
+		return nil, ErrShareNotFound
+	}
+	return &card, nil
+}
+
+// Delete removes the card or returns ErrShareNotFound
+func (s *SQLiteShareStore) Delete(id string) error {
+	result, err := s.db.Exec("DELETE FROM shared_cards WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return ErrShareNotFound
+	}
+	return nil
+}
+
+// prune removes expired cards if it hasn't done so recently
+func (s *SQLiteShareStore) prune() {
+	s.lock.Lock()
+	if s.now().Sub(s.lastPrune) < sharePruneInterval {
+		s.lock.Unlock()
+		return
+	}
+	s.lastPrune = s.now()
+	s.lock.Unlock()
+	s.db.Exec("DELETE FROM shared_cards WHERE expires > 0 AND expires < ?",
+		s.now().Unix())
+}
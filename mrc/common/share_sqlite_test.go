@@ -0,0 +1,40 @@
+//go:build sqlite
+
+package common
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSQLiteShareStore(t *testing.T) {
+	store, err := NewShareStore(ShareData{Backend: ShareStoreSQLite,
+		Path: filepath.Join(t.TempDir(), "shared.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	card, token := testSharedCard(t, time.Hour)
+	id, err := store.Create(card)
+	if err != nil || !shareIDRegex.MatchString(id) {
+		t.Fatalf("Expected a short ID, got %s %v", id, err)
+	}
+	loaded, err := store.Load(id)
+	if err != nil || !loaded.CheckDeleteToken(token) || loaded.Options.Title != "A320" {
+		t.Errorf("Unexpected card %+v %v", loaded, err)
+	}
+	if err := store.Delete(id); err != nil {
+		t.Errorf("Delete failed %v", err)
+	}
+	if _, err := store.Load(id); err != ErrShareNotFound {
+		t.Errorf("Expected deleted card not found, got %v", err)
+	}
+
+	expiring, _ := testSharedCard(t, time.Hour)
+	id, _ = store.Create(expiring)
+	sqliteStore := store.(*SQLiteShareStore)
+	sqliteStore.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if _, err := store.Load(id); err != ErrShareNotFound {
+		t.Errorf("Expected expired card not found, got %v", err)
+	}
+}
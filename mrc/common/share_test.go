@@ -0,0 +1,106 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testSharedCard(t *testing.T, ttl time.Duration) (*SharedCard, string) {
+	binds := GameBindsByProfile{"Default": GameDeviceContextActions{
+		"d1": GameContextActions{"PLANE": GameActions{"GEAR": {"Button 1", ""}}}}}
+	card, token, err := NewSharedCard("game", "1.0", []string{"abc"}, binds, nil,
+		&RequestOptions{Title: "A320", Format: ReportCSV, Interactive: true}, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return card, token
+}
+
+func TestNewSharedCard(t *testing.T) {
+	card, token := testSharedCard(t, time.Hour)
+	if card.Game != "game" || card.Options.Title != "A320" || !card.Options.Interactive ||
+		len(card.Options.Format) > 0 || card.Expires.Sub(card.Created) != time.Hour {
+		t.Errorf("Unexpected card %+v", card)
+	}
+	if !card.CheckDeleteToken(token) || card.CheckDeleteToken("") ||
+		card.DeleteTokenHash == token {
+		t.Errorf("Expected only the token to delete the card")
+	}
+	if card.Expired(card.Created) || !card.Expired(card.Expires.Add(time.Second)) {
+		t.Errorf("Unexpected expiry %v", card.Expires)
+	}
+	forever, _ := testSharedCard(t, 0)
+	if !forever.Expires.IsZero() || forever.Expired(time.Now().AddDate(100, 0, 0)) {
+		t.Errorf("Expected no expiry, got %v", forever.Expires)
+	}
+}
+
+func TestNewShareStore(t *testing.T) {
+	for _, test := range []struct {
+		data  ShareData
+		store bool
+		err   bool
+	}{
+		{ShareData{}, false, false},
+		{ShareData{Backend: ShareStoreFilesystem, Path: t.TempDir()}, true, false},
+		{ShareData{Backend: ShareStoreFilesystem}, false, true},
+		{ShareData{Backend: "Cloud"}, false, true},
+	} {
+		store, err := NewShareStore(test.data)
+		if (store != nil) != test.store || (err != nil) != test.err {
+			t.Errorf("%+v expected store %v error %v, got %v %v", test.data,
+				test.store, test.err, store, err)
+		}
+	}
+}
+
+func TestFileShareStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "shared")
+	store, err := NewFileShareStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	card, token := testSharedCard(t, time.Hour)
+	id, err := store.Create(card)
+	if err != nil || !shareIDRegex.MatchString(id) {
+		t.Fatalf("Expected a short ID, got %s %v", id, err)
+	}
+	loaded, err := store.Load(id)
+	if err != nil || loaded.Bindings["Default"]["d1"]["PLANE"]["GEAR"][0] != "Button 1" ||
+		!loaded.CheckDeleteToken(token) || loaded.Options.Title != "A320" {
+		t.Errorf("Unexpected card %+v %v", loaded, err)
+	}
+	for _, id := range []string{"missing1", "../../etc/passwd"} {
+		if _, err := store.Load(id); err != ErrShareNotFound {
+			t.Errorf("Expected %s not found, got %v", id, err)
+		}
+	}
+	if err := store.Delete(id); err != nil {
+		t.Errorf("Delete failed %v", err)
+	}
+	if err := store.Delete(id); err != ErrShareNotFound {
+		t.Errorf("Expected deleted card not found, got %v", err)
+	}
+
+	// Expired cards aren't loaded and are pruned when a card is created
+	expiring, _ := testSharedCard(t, time.Hour)
+	id, _ = store.Create(expiring)
+	store.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if _, err := store.Load(id); err != ErrShareNotFound {
+		t.Errorf("Expected expired card not found, got %v", err)
+	}
+	store.now = time.Now
+	expiring, _ = testSharedCard(t, time.Hour)
+	store.Create(expiring)
+	forever, _ := testSharedCard(t, 0)
+	id, _ = store.Create(forever)
+	store.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	store.lastPrune = time.Time{}
+	store.prune()
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() != id+".json" {
+		t.Errorf("Expected only the card without expiry, got %v", entries)
+	}
+}
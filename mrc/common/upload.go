@@ -0,0 +1,151 @@
+package common
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// utf8BOM starts some input files e.g. FS2020's
+var utf8BOM = []byte{0xef, 0xbb, 0xbf}
+
+// uploadContentTypes are the multipart content types accepted for input
+// files. Browsers send text types, a generic type or nothing for files they
+// don't know e.g. .profile.
+var uploadContentTypes = map[string]bool{
+	"":                         true,
+	"application/octet-stream": true,
+	"application/xml":          true,
+}
+
+// UploadError rejects a request's input files with the HTTP status to respond
+// with and a message for the user
+type UploadError struct {
+	Status int
+	Msg    string
+}
+
+func (e *UploadError) Error() string {
+	return e.Msg
+}
+
+func newUploadError(status int, format string, args ...any) *UploadError {
+	return &UploadError{Status: status, Msg: fmt.Sprintf(format, args...)}
+}
+
+// MaxRequestBytes returns the most bytes a request body can have. 0 for no
+// limit.
+func (l *UploadLimits) MaxRequestBytes() int64 {
+	return int64(l.MaxRequestKB) << 10
+}
+
+// MaxCardRequestBytes returns the most bytes a request of cards to render
+// again can have, the most cards of MaxCardKB and MaxRequestKB for the rest
+// of the request. 0 for no limit.
+func (l *UploadLimits) MaxCardRequestBytes() int64 {
+	if l.MaxFiles <= 0 || l.MaxCardKB <= 0 {
+		return 0
+	}
+	return int64(l.MaxFiles*l.MaxCardKB+l.MaxRequestKB) << 10
+}
+
+// CheckFileCount rejects requests with too many files
+func (l *UploadLimits) CheckFileCount(count int) error {
+	if l.MaxFiles > 0 && count > l.MaxFiles {
+		return newUploadError(http.StatusBadRequest,
+			"Too many files, %d were uploaded but at most %d are allowed", count,
+			l.MaxFiles)
+	}
+	return nil
+}
+
+// CheckFileSize rejects files that are too large
+func (l *UploadLimits) CheckFileSize(name string, size int64) error {
+	if l.MaxFileKB > 0 && size > int64(l.MaxFileKB)<<10 {
+		return newUploadError(http.StatusRequestEntityTooLarge,
+			"%s is too large, files can be at most %d KB", name, l.MaxFileKB)
+	}
+	return nil
+}
+
+// CheckCardSize rejects cards posted to be rendered again that are too large
+func (l *UploadLimits) CheckCardSize(name string, size int64) error {
+	if l.MaxCardKB > 0 && size > int64(l.MaxCardKB)<<10 {
+		return newUploadError(http.StatusRequestEntityTooLarge,
+			"%s is too large, cards can be at most %d KB", name, l.MaxCardKB)
+	}
+	return nil
+}
+
+// CheckRequestSize returns a 413 upload error if err is from reading more of
+// the request than its limit. Other errors are returned as is.
+func (l *UploadLimits) CheckRequestSize(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return newUploadError(http.StatusRequestEntityTooLarge,
+			"The upload is too large, requests can be at most %d KB",
+			maxBytesErr.Limit>>10)
+	}
+	return err
+}
+
+// CheckBindings rejects requests with more profiles or devices than allowed.
+// Bindings are checked after parsing but before the expensive rendering.
+func (l *UploadLimits) CheckBindings(gameBinds GameBindsByProfile, gameDevices Set) error {
+	if l.MaxProfiles > 0 && len(gameBinds) > l.MaxProfiles {
+		return newUploadError(http.StatusBadRequest,
+			"Too many profiles, the files have %d but at most %d are allowed",
+			len(gameBinds), l.MaxProfiles)
+	}
+	if l.MaxDevices > 0 && len(gameDevices) > l.MaxDevices {
+		return newUploadError(http.StatusBadRequest,
+			"Too many devices, the files have %d but at most %d are allowed",
+			len(gameDevices), l.MaxDevices)
+	}
+	return nil
+}
+
+// CheckContentType rejects files uploaded with a content type that isn't a
+// game's input file e.g. images
+func CheckContentType(name string, contentType string) error {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if len(contentType) > 0 && err != nil {
+		return newUploadError(http.StatusBadRequest,
+			"%s has an invalid content type %s", name, contentType)
+	}
+	if !uploadContentTypes[mediaType] && !strings.HasPrefix(mediaType, "text/") {
+		return newUploadError(http.StatusBadRequest,
+			"%s isn't a game input file, its content type is %s", name, mediaType)
+	}
+	return nil
+}
+
+// CheckInputFile rejects files that can't be game input files. They must be
+// UTF-8 text and, if they look like XML, well formed.
+func CheckInputFile(name string, contents []byte) error {
+	text := bytes.TrimPrefix(contents, utf8BOM)
+	if !utf8.Valid(text) || bytes.IndexByte(text, 0) >= 0 {
+		return newUploadError(http.StatusBadRequest,
+			"%s isn't a game input file, it isn't UTF-8 text", name)
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(text), []byte("<")) {
+		return nil
+	}
+	decoder := xml.NewDecoder(bytes.NewReader(text))
+	for {
+		_, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return newUploadError(http.StatusBadRequest, "%s isn't valid XML - %s",
+				name, err)
+		}
+	}
+}
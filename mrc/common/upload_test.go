@@ -0,0 +1,114 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func uploadStatus(err error) int {
+	var uploadErr *UploadError
+	if errors.As(err, &uploadErr) {
+		return uploadErr.Status
+	}
+	return 0
+}
+
+func TestUploadLimits(t *testing.T) {
+	limits := UploadLimits{MaxFiles: 2, MaxFileKB: 1, MaxRequestKB: 4, MaxProfiles: 1,
+		MaxDevices: 2}
+	if err := limits.CheckFileCount(2); err != nil {
+		t.Errorf("Expected 2 files allowed, got %v", err)
+	}
+	if err := limits.CheckFileCount(3); uploadStatus(err) != http.StatusBadRequest {
+		t.Errorf("Expected 400 for 3 files, got %v", err)
+	}
+	if err := limits.CheckFileSize("a.xml", 1024); err != nil {
+		t.Errorf("Expected 1 KB allowed, got %v", err)
+	}
+	if err := limits.CheckFileSize("a.xml", 1025); uploadStatus(err) != http.StatusRequestEntityTooLarge ||
+		err.Error() != "a.xml is too large, files can be at most 1 KB" {
+		t.Errorf("Expected 413 for a large file, got %v", err)
+	}
+	if limits.MaxRequestBytes() != 4096 {
+		t.Errorf("Expected 4096 bytes, got %d", limits.MaxRequestBytes())
+	}
+	err := limits.CheckRequestSize(fmt.Errorf("reading: %w", &http.MaxBytesError{Limit: 4096}))
+	if uploadStatus(err) != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for a large request, got %v", err)
+	}
+	cards := UploadLimits{MaxFiles: 2, MaxRequestKB: 4, MaxCardKB: 8}
+	if cards.MaxCardRequestBytes() != 20<<10 {
+		t.Errorf("Expected 20 KB for cards, got %d", cards.MaxCardRequestBytes())
+	}
+	if err := cards.CheckCardSize("card.jpg", 8<<10); err != nil {
+		t.Errorf("Expected 8 KB allowed, got %v", err)
+	}
+	if err := cards.CheckCardSize("card.jpg", 8<<10+1); uploadStatus(err) != http.StatusRequestEntityTooLarge ||
+		err.Error() != "card.jpg is too large, cards can be at most 8 KB" {
+		t.Errorf("Expected 413 for a large card, got %v", err)
+	}
+	other := errors.New("other")
+	if err := limits.CheckRequestSize(other); err != other {
+		t.Errorf("Expected other errors unchanged, got %v", err)
+	}
+
+	binds := GameBindsByProfile{"Default": nil}
+	if err := limits.CheckBindings(binds, Set{"d1": true, "d2": true}); err != nil {
+		t.Errorf("Expected bindings allowed, got %v", err)
+	}
+	if err := limits.CheckBindings(GameBindsByProfile{"a": nil, "b": nil}, nil); uploadStatus(err) != http.StatusBadRequest {
+		t.Errorf("Expected 400 for too many profiles, got %v", err)
+	}
+	if err := limits.CheckBindings(binds, Set{"d1": true, "d2": true, "d3": true}); uploadStatus(err) != http.StatusBadRequest {
+		t.Errorf("Expected 400 for too many devices, got %v", err)
+	}
+
+	// No limits
+	var none UploadLimits
+	if none.CheckFileCount(1000) != nil || none.CheckFileSize("a", 1<<30) != nil ||
+		none.CheckCardSize("a", 1<<30) != nil || none.MaxCardRequestBytes() != 0 ||
+		none.CheckBindings(GameBindsByProfile{"a": nil, "b": nil}, nil) != nil {
+		t.Error("Expected no limits by default")
+	}
+}
+
+func TestCheckContentType(t *testing.T) {
+	for _, test := range []struct {
+		contentType string
+		valid       bool
+	}{
+		{"", true},
+		{"text/xml", true},
+		{"text/plain; charset=utf-8", true},
+		{"application/xml", true},
+		{"application/octet-stream", true},
+		{"image/jpeg", false},
+		{"application/zip", false},
+		{"text/", false},
+	} {
+		err := CheckContentType("a.xml", test.contentType)
+		if (err == nil) != test.valid {
+			t.Errorf("%s expected valid %v, got %v", test.contentType, test.valid, err)
+		}
+	}
+}
+
+func TestCheckInputFile(t *testing.T) {
+	for _, test := range []struct {
+		contents string
+		valid    bool
+	}{
+		{"\xef\xbb\xbf<?xml version=\"1.0\" encoding=\"utf-8\"?><Device></Device>", true},
+		{"GstInput.JoystickPitch 1\n", true},
+		{"  <Device><Context></Device>", false},
+		{"<Device>\xff</Device>", false},
+		{"GstInput\x00", false},
+	} {
+		err := CheckInputFile("input", []byte(test.contents))
+		if (err == nil) != test.valid || (err != nil && uploadStatus(err) != http.StatusBadRequest) {
+			t.Errorf("%q expected valid %v, got %v", test.contents, test.valid, err)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package common
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of usage counted
+const (
+	UsageRequests          = "requests"
+	UsageDevice            = "device"
+	UsageUnsupportedDevice = "unsupported_device"
+	UsageProfiles          = "profiles"
+)
+
+// usageDateLayout - days are UTC dates
+const usageDateLayout = "2006-01-02"
+
+// usageFlushInterval - how often counts are written to the day's file
+const usageFlushInterval = time.Minute
+
+// maxUsageNames - distinct names counted per day, game and kind. Unsupported
+// device names come from uploaded files so they're capped.
+const maxUsageNames = 500
+
+// usageFileRegex matches the day files in the usage directory
+var usageFileRegex = regexp.MustCompile(`^usage-(\d{4}-\d{2}-\d{2})\.json$`)
+
+// UsageCounts - Game -> Kind -> Name -> Count
+type UsageCounts map[string]map[string]map[string]int
+
+// UsageRow is a day's count of a game's usage
+type UsageRow struct {
+	Date  string
+	Game  string
+	Kind  string
+	Name  string
+	Count int
+}
+
+// UsageRecorder aggregates anonymous daily usage counts in a JSON file per
+// day. Only counts are kept, never uploaded files or who uploaded them.
+type UsageRecorder struct {
+	dir       string
+	retention time.Duration
+	lock      sync.Mutex
+	day       string // Date of counts
+	counts    UsageCounts
+	dirty     bool
+	lastFlush time.Time
+	now       func() time.Time
+}
+
+// NewUsageRecorder returns the configured usage recorder or nil if usage
+// statistics are disabled
+func NewUsageRecorder(data UsageData) (*UsageRecorder, error) {
+	if len(data.Dir) == 0 {
+		return nil, nil
+	}
+	if err := os.MkdirAll(data.Dir, 0755); err != nil {
+		return nil, err
+	}
+	return &UsageRecorder{
+		dir:       data.Dir,
+		retention: time.Duration(data.RetentionDays) * 24 * time.Hour,
+		now:       time.Now,
+	}, nil
+}
+
+// RecordRequest counts a request for the game, the supported devices in its
+// files and its number of profiles
+func (r *UsageRecorder) RecordRequest(game string, devices Set, profiles int) {
+	r.record(func(counts UsageCounts) {
+		counts.add(game, UsageRequests, game)
+		for device := range devices {
+			counts.add(game, UsageDevice, device)
+		}
+		counts.add(game, UsageProfiles, profileBucket(profiles))
+	})
+}
+
+// RecordUnsupportedDevice counts an input file device the game doesn't support
+func (r *UsageRecorder) RecordUnsupportedDevice(game string, device string) {
+	r.record(func(counts UsageCounts) {
+		counts.add(game, UsageUnsupportedDevice, device)
+	})
+}
+
+// Rows returns the daily counts from the day since, oldest first
+func (r *UsageRecorder) Rows(since time.Time) ([]UsageRow, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if err := r.flush(); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return nil, err
+	}
+	from := since.UTC().Format(usageDateLayout)
+	var rows []UsageRow
+	for _, entry := range entries {
+		matches := usageFileRegex.FindStringSubmatch(entry.Name())
+		if matches == nil || matches[1] < from {
+			continue
+		}
+		counts, err := r.load(matches[1])
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, counts.rows(matches[1])...)
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].Date < rows[j].Date
+	})
+	return rows, nil
+}
+
+// Flush writes counts that haven't been written yet
+func (r *UsageRecorder) Flush() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.flush()
+}
+
+// record updates the day's counts, writing them every so often
+func (r *UsageRecorder) record(update func(counts UsageCounts)) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	now := r.now()
+	day := now.UTC().Format(usageDateLayout)
+	if day != r.day {
+		// Write the previous day before starting the new one
+		r.flush()
+		counts, err := r.load(day)
+		if err != nil {
+			counts = make(UsageCounts)
+		}
+		r.day, r.counts = day, counts
+		r.prune(now)
+	}
+	update(r.counts)
+	r.dirty = true
+	if now.Sub(r.lastFlush) >= usageFlushInterval {
+		r.flush()
+	}
+}
+
+func (r *UsageRecorder) flush() error {
+	if !r.dirty {
+		return nil
+	}
+	data, err := json.Marshal(r.counts)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file so readers never see part of a day
+	file, err := os.CreateTemp(r.dir, "usage-*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), r.path(r.day))
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	r.dirty = false
+	r.lastFlush = r.now()
+	return nil
+}
+
+// load reads a day's counts. Days without a file have no counts.
+func (r *UsageRecorder) load(day string) (UsageCounts, error) {
+	counts := make(UsageCounts)
+	data, err := os.ReadFile(r.path(day))
+	if errors.Is(err, os.ErrNotExist) {
+		return counts, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &counts); err != nil {
+		return nil, fmt.Errorf("usage for %s - %w", day, err)
+	}
+	return counts, nil
+}
+
+// prune removes days older than the retention period
+func (r *UsageRecorder) prune(now time.Time) {
+	if r.retention == 0 {
+		return
+	}
+	oldest := now.Add(-r.retention).UTC().Format(usageDateLayout)
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		matches := usageFileRegex.FindStringSubmatch(entry.Name())
+		if matches != nil && matches[1] < oldest {
+			os.Remove(filepath.Join(r.dir, entry.Name()))
+		}
+	}
+}
+
+func (r *UsageRecorder) path(day string) string {
+	return filepath.Join(r.dir, fmt.Sprintf("usage-%s.json", day))
+}
+
+// add counts a name. Once a game and kind has too many names, new ones are
+// counted as other.
+func (c UsageCounts) add(game string, kind string, name string) {
+	kinds, found := c[game]
+	if !found {
+		kinds = make(map[string]map[string]int)
+		c[game] = kinds
+	}
+	names, found := kinds[kind]
+	if !found {
+		names = make(map[string]int)
+		kinds[kind] = names
+	}
+	if _, found := names[name]; !found && len(names) >= maxUsageNames {
+		name = otherLabel
+	}
+	names[name]++
+}
+
+// rows returns the day's counts sorted by game, kind and name
+func (c UsageCounts) rows(day string) []UsageRow {
+	var rows []UsageRow
+	for _, game := range sortedKeys(c) {
+		for _, kind := range sortedKeys(c[game]) {
+			for _, name := range sortedKeys(c[game][kind]) {
+				rows = append(rows, UsageRow{Date: day, Game: game, Kind: kind,
+					Name: name, Count: c[game][kind][name]})
+			}
+		}
+	}
+	return rows
+}
+
+// profileBucket groups profile counts so the names stay few
+func profileBucket(profiles int) string {
+	switch {
+	case profiles <= 4:
+		return strconv.Itoa(profiles)
+	case profiles <= 8:
+		return "5-8"
+	}
+	return "9+"
+}
+
+// UsageTotals sums the rows' counts by game, kind and name, largest first
+func UsageTotals(rows []UsageRow) []UsageRow {
+	totals := make(map[UsageRow]int)
+	for _, row := range rows {
+		totals[UsageRow{Game: row.Game, Kind: row.Kind, Name: row.Name}] += row.Count
+	}
+	summed := make([]UsageRow, 0, len(totals))
+	for row, count := range totals {
+		row.Count = count
+		summed = append(summed, row)
+	}
+	sort.Slice(summed, func(i, j int) bool {
+		a, b := summed[i], summed[j]
+		if a.Game != b.Game {
+			return a.Game < b.Game
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Name < b.Name
+	})
+	return summed
+}
+
+// WriteUsageCSV writes the rows as CSV with a header
+func WriteUsageCSV(w io.Writer, rows []UsageRow) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"date", "game", "kind", "name", "count"})
+	for _, row := range rows {
+		writer.Write([]string{row.Date, row.Game, row.Kind, csvSafe(row.Name),
+			strconv.Itoa(row.Count)})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// csvSafe stops names from uploaded files being run as spreadsheet formulas
+func csvSafe(value string) string {
+	if len(value) > 0 && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,137 @@
+package common
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestUsageRecorder(t *testing.T, retentionDays int) (*UsageRecorder, *time.Time) {
+	recorder, err := NewUsageRecorder(UsageData{Dir: t.TempDir(),
+		RetentionDays: retentionDays})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	recorder.now = func() time.Time { return now }
+	return recorder, &now
+}
+
+func TestNewUsageRecorder(t *testing.T) {
+	if recorder, err := NewUsageRecorder(UsageData{}); recorder != nil || err != nil {
+		t.Errorf("Expected no recorder when disabled, got %v %v", recorder, err)
+	}
+}
+
+func TestUsageRecorder(t *testing.T) {
+	recorder, now := newTestUsageRecorder(t, 0)
+	recorder.RecordRequest("fs2020", Set{"X55": true, "T16000M": true}, 2)
+	recorder.RecordRequest("fs2020", Set{"X55": true}, 12)
+	recorder.RecordUnsupportedDevice("fs2020", "Mystery Stick")
+	*now = now.Add(24 * time.Hour)
+	recorder.RecordRequest("sws", nil, 1)
+
+	rows, err := recorder.Rows(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []UsageRow{
+		{"2026-03-01", "fs2020", UsageDevice, "T16000M", 1},
+		{"2026-03-01", "fs2020", UsageDevice, "X55", 2},
+		{"2026-03-01", "fs2020", UsageProfiles, "2", 1},
+		{"2026-03-01", "fs2020", UsageProfiles, "9+", 1},
+		{"2026-03-01", "fs2020", UsageRequests, "fs2020", 2},
+		{"2026-03-01", "fs2020", UsageUnsupportedDevice, "Mystery Stick", 1},
+		{"2026-03-02", "sws", UsageProfiles, "1", 1},
+		{"2026-03-02", "sws", UsageRequests, "sws", 1},
+	}
+	if len(rows) != len(expected) {
+		t.Fatalf("Expected %d rows, got %v", len(expected), rows)
+	}
+	for idx := range expected {
+		if rows[idx] != expected[idx] {
+			t.Errorf("Row %d expected %v, got %v", idx, expected[idx], rows[idx])
+		}
+	}
+	if rows, _ := recorder.Rows(*now); len(rows) != 2 {
+		t.Errorf("Expected today's 2 rows, got %v", rows)
+	}
+
+	// Counts survive a restart
+	restarted, _ := NewUsageRecorder(UsageData{Dir: recorder.dir})
+	restarted.now = recorder.now
+	restarted.RecordRequest("sws", nil, 1)
+	restarted.Flush()
+	if rows, _ := restarted.Rows(*now); len(rows) != 2 || rows[1].Count != 2 {
+		t.Errorf("Expected counts kept, got %v", rows)
+	}
+}
+
+func TestUsageRecorder_Prune(t *testing.T) {
+	recorder, now := newTestUsageRecorder(t, 2)
+	recorder.RecordRequest("sws", nil, 1)
+	*now = now.Add(3 * 24 * time.Hour)
+	recorder.RecordRequest("sws", nil, 1)
+	files, _ := filepath.Glob(filepath.Join(recorder.dir, "usage-*.json"))
+	if len(files) != 1 || filepath.Base(files[0]) != "usage-2026-03-04.json" {
+		t.Errorf("Expected old days removed, got %v", files)
+	}
+}
+
+func TestUsageRecorder_CorruptDay(t *testing.T) {
+	recorder, _ := newTestUsageRecorder(t, 0)
+	os.WriteFile(recorder.path("2026-02-01"), []byte("{"), 0644)
+	if _, err := recorder.Rows(time.Time{}); err == nil {
+		t.Error("Expected an error for a corrupt day")
+	}
+}
+
+func TestUsageCounts_MaxNames(t *testing.T) {
+	counts := make(UsageCounts)
+	for idx := 0; idx < maxUsageNames+5; idx++ {
+		counts.add("sws", UsageUnsupportedDevice, string(rune('a'+idx%26))+
+			string(rune('0'+idx/26)))
+	}
+	names := counts["sws"][UsageUnsupportedDevice]
+	if len(names) != maxUsageNames+1 || names[otherLabel] != 5 {
+		t.Errorf("Expected names capped, got %d names and %d other", len(names),
+			names[otherLabel])
+	}
+}
+
+func TestUsageTotals(t *testing.T) {
+	totals := UsageTotals([]UsageRow{
+		{"2026-03-01", "sws", UsageDevice, "A", 1},
+		{"2026-03-01", "sws", UsageDevice, "B", 2},
+		{"2026-03-02", "sws", UsageDevice, "A", 3},
+		{"2026-03-02", "fs2020", UsageDevice, "A", 1},
+	})
+	expected := []UsageRow{
+		{"", "fs2020", UsageDevice, "A", 1},
+		{"", "sws", UsageDevice, "A", 4},
+		{"", "sws", UsageDevice, "B", 2},
+	}
+	if len(totals) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, totals)
+	}
+	for idx := range expected {
+		if totals[idx] != expected[idx] {
+			t.Errorf("Total %d expected %v, got %v", idx, expected[idx], totals[idx])
+		}
+	}
+}
+
+func TestWriteUsageCSV(t *testing.T) {
+	var out bytes.Buffer
+	WriteUsageCSV(&out, []UsageRow{
+		{"2026-03-01", "sws", UsageDevice, "X55", 2},
+		{"2026-03-01", "sws", UsageUnsupportedDevice, "=cmd,1", 1},
+	})
+	expected := "date,game,kind,name,count\n2026-03-01,sws,device,X55,2\n" +
+		"2026-03-01,sws,unsupported_device,\"'=cmd,1\",1\n"
+	if out.String() != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, out.String())
+	}
+}
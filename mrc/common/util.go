@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"os"
+	"sort"
 	"sync"
 
 	"github.com/golang/freetype/truetype"
@@ -23,6 +24,15 @@ func (m Set) Keys() []string {
 	return array
 }
 
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // LoadYaml loads Yaml file and prints any errors
 func LoadYaml(filename string, out interface{}, label string, log *Logger) {
 	yamlData, err := os.ReadFile(filename)
@@ -35,6 +45,18 @@ func LoadYaml(filename string, out interface{}, label string, log *Logger) {
 	}
 }
 
+// LoadYamlFile loads Yaml file, returning any errors rather than exiting
+func LoadYamlFile(filename string, out interface{}) error {
+	yamlData, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(yamlData, out); err != nil {
+		return fmt.Errorf("%s - %w", filename, err)
+	}
+	return nil
+}
+
 // YamlObjectAsString outputs contents of yaml object with a label
 func YamlObjectAsString(in interface{}, label string, log *Logger) string {
 	d, err := yaml.Marshal(in)
@@ -49,20 +71,9 @@ var fontCache sync.Map
 
 // loadFont loads a font into memory and returns it.
 func loadFont(dir string, name string, size int) font.Face {
-	var font *truetype.Font
-	if v, found := fontCache.Load(name); found {
-		font = v.(*truetype.Font)
-	} else {
-		fontPath := fmt.Sprintf("%s/%s", dir, name)
-		fontBytes, err := os.ReadFile(fontPath)
-		if err != nil {
-			panic(err)
-		}
-		font, err = truetype.Parse(fontBytes)
-		if err != nil {
-			panic(err)
-		}
-		fontCache.Store(name, font)
+	font, err := loadTrueType(dir, name)
+	if err != nil {
+		panic(err)
 	}
 	face := truetype.NewFace(font, &truetype.Options{
 		Size: float64(size),
@@ -70,26 +81,48 @@ func loadFont(dir string, name string, size int) font.Face {
 	return face
 }
 
+// loadTrueType loads and parses a font file, caching the result by name
+func loadTrueType(dir string, name string) (*truetype.Font, error) {
+	if v, found := fontCache.Load(name); found {
+		return v.(*truetype.Font), nil
+	}
+	fontPath := fmt.Sprintf("%s/%s", dir, name)
+	fontBytes, err := os.ReadFile(fontPath)
+	if err != nil {
+		return nil, err
+	}
+	font, err := truetype.Parse(fontBytes)
+	if err != nil {
+		return nil, err
+	}
+	fontCache.Store(name, font)
+	return font, nil
+}
+
 type fontKey struct {
 	name string
 	size int
 }
 
-// FontFaceCache is a thread-safe cache for font faces
+// FontFaceCache is a thread-safe cache for font faces. Faces fall back to the
+// fallback fonts, in order, for characters missing from the requested font.
 type FontFaceCache struct {
-	cache sync.Map
+	cache         sync.Map
+	fallbackFonts []string
 }
 
-func NewFontFaceCache() *FontFaceCache {
-	return &FontFaceCache{}
+func NewFontFaceCache(fallbackFonts ...string) *FontFaceCache {
+	return &FontFaceCache{fallbackFonts: fallbackFonts}
 }
 
 func (c *FontFaceCache) LoadFont(dir string, name string, size int) font.Face {
 	key := fontKey{name: name, size: size}
 	if v, ok := c.cache.Load(key); ok {
+		fontCacheHits.Add(1)
 		return v.(font.Face)
 	}
-	fontFace := loadFont(dir, name, size)
+	fontCacheMisses.Add(1)
+	fontFace := loadFontWithFallbacks(dir, name, c.fallbackFonts, size)
 	c.cache.Store(key, fontFace)
 	return fontFace
 }
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"io"
 	"regexp"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/ankurkotwal/metarefcard/mrc/common"
@@ -43,9 +45,10 @@ func GetGameInfo() (string, string, common.FuncRequestHandler, common.FuncMatchG
 
 // handleRequest services the request to load files
 func handleRequest(files [][]byte, config *common.Config, log *common.Logger) (common.GameData,
-	common.GameBindsByProfile, common.Set, common.ContextToColours, string) {
+	common.GameBindsByProfile, common.GameAxesByProfile, common.Set, common.ContextToColours,
+	string) {
 	firstInit.Do(func() {
-		sharedGameData = common.LoadGameModel("config/fs2020.yaml",
+		sharedGameData = common.LoadGameModel(common.GameConfigFile(label),
 			"FS2020 Data", config.DebugOutput, log)
 		sharedRegexes.Button = regexp.MustCompile(sharedGameData.Regexes["Button"])
 		sharedRegexes.Axis = regexp.MustCompile(sharedGameData.Regexes["Axis"])
@@ -53,18 +56,20 @@ func handleRequest(files [][]byte, config *common.Config, log *common.Logger) (c
 		sharedRegexes.Rotation = regexp.MustCompile(sharedGameData.Regexes["Rotation"])
 		sharedRegexes.Slider = regexp.MustCompile(sharedGameData.Regexes["Slider"])
 	})
-	gameBinds, gameDevices, gameContextsToColours := loadInputFiles(files, config.Devices.DeviceToShortNameMap,
-		log, config.DebugOutput, config.VerboseOutput)
-	common.GenerateContextColours(gameContextsToColours, config)
-	return sharedGameData, gameBinds, gameDevices, gameContextsToColours, sharedGameData.Logo
+	gameBinds, gameAxes, gameDevices, gameContextsToColours := loadInputFiles(files,
+		config.Devices.DeviceToShortNameMap, log, config.DebugOutput, config.VerboseOutput)
+	common.GenerateContextColours(gameContextsToColours, sharedGameData.ContextColours, config)
+	return sharedGameData, gameBinds, gameAxes, gameDevices, gameContextsToColours,
+		sharedGameData.Logo
 }
 
 // Load the game config files (provided by user)
 func loadInputFiles(files [][]byte, deviceShortNameMap common.DeviceNameFullToShort,
 	log *common.Logger, debugOutput bool, verboseOutput bool) (common.GameBindsByProfile,
-	common.Set, common.ContextToColours) {
+	common.GameAxesByProfile, common.Set, common.ContextToColours) {
 
 	gameBinds := make(common.GameBindsByProfile)
+	gameAxes := make(common.GameAxesByProfile)
 	defaultProfile := common.ProfileDefault
 	gameBinds[defaultProfile] = make(common.GameDeviceContextActions)
 	neededDevices := make(common.Set)
@@ -76,6 +81,10 @@ func loadInputFiles(files [][]byte, deviceShortNameMap common.DeviceNameFullToSh
 	currentAction := make(common.GameInput, common.NumInputs)
 	currentKeyType := keyUnknown
 	var currentProfile *string
+	// Axis state, device axis name -> sensitivity and deadzone
+	var deviceAxes map[string]common.AxisSettings
+	var currentShortName, currentContextName, currentActionName string
+	currentActionIsAxis, currentActionInverted := false, false
 
 	for idx, file := range files {
 		_ = idx
@@ -92,8 +101,8 @@ func loadInputFiles(files [][]byte, deviceShortNameMap common.DeviceNameFullToSh
 				// EOF means we're done.
 				break
 			} else if err != nil {
-				log.Err("FS2020 decoding token %s in file %s", err, file)
-				return gameBinds, neededDevices, contextsToColours
+				log.ErrAs(common.ErrorParse, "FS2020 decoding token %s in file %s", err, file)
+				return gameBinds, gameAxes, neededDevices, contextsToColours
 			}
 
 			switch ty := token.(type) {
@@ -112,14 +121,16 @@ func loadInputFiles(files [][]byte, deviceShortNameMap common.DeviceNameFullToSh
 					var found bool
 					var shortName string
 					if shortName, found = deviceShortNameMap[aDevice]; !found {
-						log.Err("FS2020 Unsupported device \"%s\"", aDevice)
+						log.ErrAs(common.ErrorUnsupportedDevice, "FS2020 Unsupported device \"%s\"", aDevice)
+						common.RecordUnsupportedDevice(label, aDevice)
+						log.UnsupportedDevice(aDevice)
 						skipToNextFile = true
 						break // Move on to next device
 					}
 					_, found = gameBinds[*currentProfile][shortName]
 					if found {
 						out, _ := json.Marshal(aDevice)
-						log.Err("FS2020 duplicate device: %s", out)
+						log.ErrAs(common.ErrorParse, "FS2020 duplicate device: %s", out)
 						break // Move on to next device
 					} else {
 						if debugOutput {
@@ -127,10 +138,12 @@ func loadInputFiles(files [][]byte, deviceShortNameMap common.DeviceNameFullToSh
 							log.Dbg("Info: FS2020 new device: %s", out)
 						}
 						contextActions = make(common.GameContextActions)
+						deviceAxes = make(map[string]common.AxisSettings)
+						currentShortName = shortName
 						neededDevices[shortName] = true // Add to set
 						gameBinds[*currentProfile][shortName] = contextActions
 						if shortName == common.DeviceMissingInfo {
-							log.Err("FS2020 missing info for device '%s'",
+							log.ErrAs(common.ErrorUnknownInput, "FS2020 missing info for device '%s'",
 								shortName)
 						}
 					}
@@ -145,9 +158,10 @@ func loadInputFiles(files [][]byte, deviceShortNameMap common.DeviceNameFullToSh
 						}
 					}
 					var found bool
+					currentContextName = contextName
 					currentContext, found = contextActions[contextName]
 					if found {
-						log.Err("FS2020 duplicate context: %s", contextName)
+						log.ErrAs(common.ErrorParse, "FS2020 duplicate context: %s", contextName)
 					} else {
 						if debugOutput {
 							log.Dbg("FS2020 new context: %s", contextName)
@@ -155,20 +169,33 @@ func loadInputFiles(files [][]byte, deviceShortNameMap common.DeviceNameFullToSh
 						currentContext = make(common.GameActions)
 						contextActions[contextName] = currentContext
 					}
+				case "Axis":
+					// Device wide axis settings, before the contexts
+					name, settings := deviceAxisSettings(ty.Attr)
+					if deviceAxes != nil && len(name) > 0 {
+						deviceAxes[name] = settings
+					}
 				case "Action":
 					// Found new action
 					currentKeyType = keyUnknown
 					var actionName string
 					action := make(common.GameInput, common.NumInputs)
+					currentActionIsAxis, currentActionInverted = false, false
 					for _, attr := range ty.Attr {
-						if attr.Name.Local == "ActionName" {
+						switch attr.Name.Local {
+						case "ActionName":
 							actionName = attr.Value
+						case "Flag":
+							flag, _ := strconv.Atoi(attr.Value)
+							currentActionIsAxis = flag&flagAxis != 0
+							currentActionInverted = flag&flagInverted != 0
 						}
 					}
+					currentActionName = actionName
 					var found bool
 					currentAction, found = currentContext[actionName]
 					if found {
-						log.Err("FS2020 duplicate action: %s", actionName)
+						log.ErrAs(common.ErrorParse, "FS2020 duplicate action: %s", actionName)
 					} else {
 						if debugOutput {
 							log.Dbg("FS2020 new action: %s", actionName)
@@ -186,6 +213,12 @@ func loadInputFiles(files [][]byte, deviceShortNameMap common.DeviceNameFullToSh
 							switch currentKeyType {
 							case keyPrimary:
 								currentAction[common.InputPrimary] = attr.Value
+								if currentActionIsAxis && currentContext != nil {
+									settings := axisSettings(attr.Value, deviceAxes)
+									settings.Inverted = currentActionInverted
+									gameAxes.Set(*currentProfile, currentShortName,
+										currentContextName, currentActionName, settings)
+								}
 							case keySecondary:
 								currentAction[common.InputSecondary] = attr.Value
 							}
@@ -226,7 +259,50 @@ func loadInputFiles(files [][]byte, deviceShortNameMap common.DeviceNameFullToSh
 		log.Dbg("%s", common.GameBindsAsString(gameBinds))
 	}
 
-	return gameBinds, neededDevices, contextsToColours
+	return gameBinds, gameAxes, neededDevices, contextsToColours
+}
+
+// deviceAxisSettings returns the device axis name and its sensitivity and
+// deadzone from an Axis element. Defaults are left empty.
+func deviceAxisSettings(attrs []xml.Attr) (string, common.AxisSettings) {
+	var name string
+	var settings common.AxisSettings
+	for _, attr := range attrs {
+		switch attr.Name.Local {
+		case "AxisName":
+			name = attr.Value
+		case "AxisSensitivy", "AxisSensitivity": // The game misspells it
+			if attr.Value != "0" {
+				settings.Sensitivity = attr.Value
+			}
+		case "AxisDeadZone":
+			if attr.Value != "0" {
+				settings.Deadzone = attr.Value
+			}
+		}
+	}
+	return name, settings
+}
+
+// axisSettings returns the settings for an axis key e.g. "Slider X +" with the
+// device's settings for the axis. A trailing +/- binds half the axis.
+func axisSettings(information string, deviceAxes map[string]common.AxisSettings) common.AxisSettings {
+	var settings common.AxisSettings
+	matches := axisKeyRegex.FindStringSubmatch(information)
+	if matches == nil {
+		return settings
+	}
+	// Match the device's axis names X, rX, SliderX
+	name := matches[3]
+	switch {
+	case len(matches[1]) > 0 || strings.EqualFold(matches[2], "Rotation"):
+		name = "r" + name
+	case strings.EqualFold(matches[2], "Slider"):
+		name = "Slider" + name
+	}
+	settings = deviceAxes[name]
+	settings.Direction = matches[4]
+	return settings
 }
 
 // matchGameInputToModel takes the game provided bindings with the device map to
@@ -242,7 +318,8 @@ func matchGameInputToModel(deviceName string, actionData common.GameInput,
 	if input != "" {
 		inputLookups = append(inputLookups, input)
 	} else {
-		log.Err("FS2020 did not find primary input for %s", actionData[common.InputPrimary])
+		log.ErrAs(common.ErrorUnknownInput, "FS2020 did not find primary input for %s",
+			actionData[common.InputPrimary])
 	}
 	// Now the secondary input
 	if len(actionData[common.InputSecondary]) > 0 {
@@ -251,7 +328,7 @@ func matchGameInputToModel(deviceName string, actionData common.GameInput,
 		if input != "" {
 			inputLookups = append(inputLookups, input)
 		} else {
-			log.Err("FS2020 did not find secondary input for %s",
+			log.ErrAs(common.ErrorUnknownInput, "FS2020 did not find secondary input for %s",
 				actionData[common.InputSecondary])
 		}
 	}
@@ -308,20 +385,33 @@ func matchGameInputToModelByRegex(deviceName string, action string,
 		if input, ok := gameInputMap["Slider"]; ok {
 			slider = fmt.Sprintf("%sAxis", input[matches[0][1]])
 		} else {
-			log.Err("FS2020 unknown action %s for slider on device %s", action, deviceName)
+			log.ErrAs(common.ErrorUnknownInput, "FS2020 unknown action %s for slider on device %s",
+				action, deviceName)
 			return ""
 		}
 		if _, ok := inputs[slider]; ok {
 			return slider
 		}
-		log.Err("FS2020 couldn't find slider %s on device %s", slider, deviceName)
+		log.ErrAs(common.ErrorUnknownInput, "FS2020 couldn't find slider %s on device %s",
+			slider, deviceName)
 		return ""
 
 	}
-	log.Err("FS2020 could not find matching Action %s on device %s", action, deviceName)
+	log.ErrAs(common.ErrorUnknownInput, "FS2020 could not find matching Action %s on device %s",
+		action, deviceName)
 	return ""
 }
 
+// Action flags
+const (
+	flagAxis     = 4
+	flagInverted = 128
+)
+
+// axisKeyRegex matches the axis keys of axis actions e.g. "Axis X",
+// "Joystick R-Axis Y", "Rotation Z" or "Slider X +"
+var axisKeyRegex = regexp.MustCompile(`(?i)(?:(R)-)?(Axis|Rotation|Slider)\s*([XYZ])\s*([+-]?)`)
+
 const (
 	keyUnknown   = iota
 	keyPrimary   = iota
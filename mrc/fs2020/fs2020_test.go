@@ -31,7 +31,7 @@ func TestLoadInputFiles(t *testing.T) {
 
 	files := [][]byte{fileContent}
 
-	gameBinds, neededDevices, contextColours := loadInputFiles(files, deviceMap, log, true, true)
+	gameBinds, _, neededDevices, contextColours := loadInputFiles(files, deviceMap, log, true, true)
 
 	if len(gameBinds) == 0 {
 		t.Error("Expected game binds to be populated")
@@ -56,7 +56,7 @@ func TestLoadInputFiles_Errors(t *testing.T) {
 	mapping := make(common.DeviceNameFullToShort)
 	mapping["Target"] = "Target"
 	
-	_, _, _ = loadInputFiles(files, mapping, log, true, true)
+	_, _, _, _ = loadInputFiles(files, mapping, log, true, true)
 	// Just ensure no panic. Errors logged.
 	if len(log.Entries) == 0 {
 		// Expect decoding error? 
@@ -206,7 +206,7 @@ func TestLoadInputFiles_CorruptXML(t *testing.T) {
 	files := [][]byte{corruptFile}
 
 	// Should not panic and ideally return empty/partial result
-	gameBinds, _, _ := loadInputFiles(files, deviceMap, log, true, true)
+	gameBinds, _, _, _ := loadInputFiles(files, deviceMap, log, true, true)
 	
 	if len(gameBinds[common.ProfileDefault]) > 0 {
 		// Just ensuring it didn't crash. Empty result expected or partial.
@@ -236,7 +236,7 @@ func TestLoadInputFiles_ErroneousData(t *testing.T) {
 	files := [][]byte{unknownDeviceXML}
 
 	// Should handle gracefully (log error) and skip
-	gameBinds, neededDevices, _ := loadInputFiles(files, deviceMap, log, true, true)
+	gameBinds, _, neededDevices, _ := loadInputFiles(files, deviceMap, log, true, true)
 
 	if len(neededDevices) != 0 {
 		t.Errorf("Expected neededDevices to be empty for unknown device, got %v", neededDevices)
@@ -265,7 +265,7 @@ func TestLoadInputFiles_Duplicates(t *testing.T) {
 	
 	files := [][]byte{xmlData}
 	
-	gameBinds, _, _ := loadInputFiles(files, deviceMap, log, true, true)
+	gameBinds, _, _, _ := loadInputFiles(files, deviceMap, log, true, true)
 	
 	// Should have loaded once.
 	// We can check logs for error "FS2020 duplicate device"
@@ -366,7 +366,7 @@ func TestHandleRequest(t *testing.T) {
 	files := [][]byte{}
 	
 	// Call
-	gData, _, _, _, logo := handleRequest(files, config, log)
+	gData, _, _, _, _, logo := handleRequest(files, config, log)
 	
 	if gData.Logo == "" {
 		t.Error("GameData Logo empty")
@@ -442,7 +442,7 @@ func TestLoadInputFiles_DuplicateContext(t *testing.T) {
 
 	files := [][]byte{xmlData}
 
-	_, _, _ = loadInputFiles(files, deviceMap, log, true, true)
+	_, _, _, _ = loadInputFiles(files, deviceMap, log, true, true)
 
 	// Check for duplicate context error
 	foundDuplicate := false
@@ -474,7 +474,7 @@ func TestLoadInputFiles_DuplicateAction(t *testing.T) {
 
 	files := [][]byte{xmlData}
 
-	_, _, _ = loadInputFiles(files, deviceMap, log, true, true)
+	_, _, _, _ = loadInputFiles(files, deviceMap, log, true, true)
 
 	// Check for duplicate action error
 	foundDuplicate := false
@@ -551,7 +551,7 @@ func TestLoadInputFiles_SecondaryKey(t *testing.T) {
 	`)
 
 	files := [][]byte{xmlData}
-	gameBinds, _, _ := loadInputFiles(files, deviceMap, log, true, true)
+	gameBinds, _, _, _ := loadInputFiles(files, deviceMap, log, true, true)
 
 	// Verify both primary and secondary are populated
 	if gameBinds[common.ProfileDefault]["TestDevice"]["PLANE"]["ACTION1"][common.InputSecondary] != "Button 2" {
@@ -580,7 +580,7 @@ func TestLoadInputFiles_FriendlyName(t *testing.T) {
 	`)
 
 	files := [][]byte{xmlData}
-	gameBinds, _, _ := loadInputFiles(files, deviceMap, log, true, true)
+	gameBinds, _, _, _ := loadInputFiles(files, deviceMap, log, true, true)
 
 	// The custom profile should be used
 	if _, found := gameBinds["MyCustomProfile"]; !found {
@@ -609,7 +609,7 @@ func TestLoadInputFiles_EmptyFriendlyName(t *testing.T) {
 	`)
 
 	files := [][]byte{xmlData}
-	gameBinds, _, _ := loadInputFiles(files, deviceMap, log, true, true)
+	gameBinds, _, _, _ := loadInputFiles(files, deviceMap, log, true, true)
 
 	// Should fall back to default profile
 	if _, found := gameBinds[common.ProfileDefault]; !found {
@@ -636,7 +636,7 @@ func TestLoadInputFiles_DeviceMissingInfo(t *testing.T) {
 	`)
 
 	files := [][]byte{xmlData}
-	_, _, _ = loadInputFiles(files, deviceMap, log, true, true)
+	_, _, _, _ = loadInputFiles(files, deviceMap, log, true, true)
 
 	// Check that error was logged for missing info
 	foundError := false
@@ -800,7 +800,7 @@ func TestLoadInputFiles_XMLDecodeError(t *testing.T) {
 	// Call loadInputFiles with any data - our mock will control behavior
 	files := [][]byte{[]byte("<Root></Root>")}
 	
-	gameBinds, neededDevices, contextsToColours := loadInputFiles(files, deviceMap, log, false, false)
+	gameBinds, _, neededDevices, contextsToColours := loadInputFiles(files, deviceMap, log, false, false)
 	
 	// Function should return early on error
 	if gameBinds == nil {
@@ -825,3 +825,103 @@ func TestLoadInputFiles_XMLDecodeError(t *testing.T) {
 		t.Error("Expected error to be logged for XML decode failure")
 	}
 }
+
+func TestLoadInputFiles_Axes(t *testing.T) {
+	log := common.NewLog()
+	deviceMap := common.DeviceNameFullToShort{
+		"TestDevice": "TestDevice",
+	}
+
+	xmlData := []byte(`
+		<Device DeviceName="TestDevice">
+			<Axes>
+				<Axis AxisName="X" AxisSensitivy="-50" AxisDeadZone="2" />
+				<Axis AxisName="Y" AxisSensitivy="0" AxisDeadZone="0" />
+				<Axis AxisName="rY" AxisSensitivy="10" AxisDeadZone="0" />
+				<Axis AxisName="SliderX" AxisSensitivy="0" AxisDeadZone="5" />
+			</Axes>
+			<Context ContextName="PLANE">
+				<Action ActionName="AILERONS" Flag="4">
+					<Primary><KEY Information="Axis X">1026</KEY></Primary>
+				</Action>
+				<Action ActionName="ELEVATOR" Flag="132">
+					<Primary><KEY Information="Axis Y">1042</KEY></Primary>
+				</Action>
+				<Action ActionName="TRIM" Flag="132">
+					<Primary><KEY Information="Joystick R-Axis Y ">786</KEY></Primary>
+				</Action>
+				<Action ActionName="THROTTLE" Flag="4">
+					<Primary><KEY Information="Slider X -">514</KEY></Primary>
+				</Action>
+				<Action ActionName="GEAR" Flag="2">
+					<Primary><KEY Information="Slider X +">514</KEY></Primary>
+				</Action>
+			</Context>
+		</Device>
+	`)
+
+	_, gameAxes, _, _ := loadInputFiles([][]byte{xmlData}, deviceMap, log, false, false)
+
+	for action, expected := range map[string]common.AxisSettings{
+		"AILERONS": {Sensitivity: "-50", Deadzone: "2"},
+		"ELEVATOR": {Inverted: true},
+		"TRIM":     {Inverted: true, Sensitivity: "10"},
+		"THROTTLE": {Direction: common.AxisNegative, Deadzone: "5"},
+	} {
+		settings := gameAxes.Lookup(common.ProfileDefault, "TestDevice", "PLANE", action)
+		if settings == nil || *settings != expected {
+			t.Errorf("%s expected %+v, got %+v", action, expected, settings)
+		}
+	}
+	// Buttons aren't axes, even on half an axis
+	if settings := gameAxes.Lookup(common.ProfileDefault, "TestDevice", "PLANE",
+		"GEAR"); settings != nil {
+		t.Errorf("Expected no axis settings for a button, got %+v", settings)
+	}
+}
+
+func TestPopulateImageOverlays_Hats(t *testing.T) {
+	log := common.NewLog()
+	wd, _ := os.Getwd()
+	gameData := common.LoadGameModel(filepath.Join(wd, "../../config/fs2020.yaml"),
+		"FS2020 Data", false, log)
+	sharedGameData = gameData
+	sharedRegexes = fs2020Regexes{
+		Button:   regexp.MustCompile(sharedGameData.Regexes["Button"]),
+		Axis:     regexp.MustCompile(sharedGameData.Regexes["Axis"]),
+		Pov:      regexp.MustCompile(sharedGameData.Regexes["Pov"]),
+		Rotation: regexp.MustCompile(sharedGameData.Regexes["Rotation"]),
+		Slider:   regexp.MustCompile(sharedGameData.Regexes["Slider"]),
+	}
+	// The devices file refers to the generated devices from the project root
+	t.Chdir("../..")
+	config := &common.Config{}
+	if err := common.LoadDevicesFile("config/devices.yaml", &config.Devices); err != nil {
+		t.Fatal(err)
+	}
+
+	// The POV hats of these devices are drawn as compass roses
+	for file, shortName := range map[string]string{
+		"T.16000M.xml":                           "T16000M",
+		"Joystick_-_HOTAS_Warthog.xml":           "ThrustMasterWarthogJoystick",
+		"Saitek_Pro_Flight_X-56_Rhino_Stick.xml": "SaitekX56Joystick",
+	} {
+		contents, err := os.ReadFile(filepath.Join("testdata/fs2020", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		gameBinds, gameAxes, neededDevices, _ := loadInputFiles([][]byte{contents},
+			config.Devices.DeviceToShortNameMap, log, false, false)
+		overlays, _ := common.PopulateImageOverlays(neededDevices, config, log,
+			gameBinds, gameAxes, gameData, matchGameInputToModel, nil)
+		image := config.Devices.ImageMap[shortName]
+		hat, found := overlays[common.ProfileDefault][image][shortName+":POV1"]
+		if !found || hat.PosAndSize.Type != common.InputTypeHat ||
+			len(hat.Directions) == 0 {
+			t.Errorf("%s expected a hat overlay, got %+v", file, hat)
+		}
+		if _, found := overlays[common.ProfileDefault][image][shortName+":POV1Up"]; found {
+			t.Errorf("%s expected POV1Up drawn in the hat", file)
+		}
+	}
+}
@@ -2,7 +2,11 @@ package mrc
 
 import (
 	"bytes"
-	"encoding/base64"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
@@ -10,6 +14,11 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/ankurkotwal/metarefcard/mrc/common"
 	"github.com/ankurkotwal/metarefcard/mrc/fs2020"
@@ -20,6 +29,42 @@ import (
 
 var config *common.Config
 
+// configFile is the main configuration file
+const configFile = "config/config.yaml"
+
+// cardStore keeps the generated cards that pages link to. nil sends them inline.
+var cardStore common.CardStore
+
+// shareStore saves the bindings behind shareable links. nil disables sharing.
+var shareStore common.ShareStore
+
+// usageRecorder records anonymous usage statistics. nil disables them.
+var usageRecorder *common.UsageRecorder
+
+// rateLimiter limits each client's /api/ requests. nil disables it.
+var rateLimiter *common.RateLimiter
+
+// renderRequests - requests rendering or waiting to render
+var renderRequests atomic.Int64
+
+// maxUsageDays - most days of usage statistics shown at once
+const maxUsageDays = 3660
+
+// adminCookie holds the admin session after signing in to the usage page
+const adminCookie = "mrc_admin"
+
+// adminSessionAge - how long an admin session lasts
+const adminSessionAge = 12 * time.Hour
+
+// rateLimitedPrefixes - paths limited to the configured requests per minute
+var rateLimitedPrefixes = []string{"/api/", "/admin/"}
+
+// retryRendersAfter - how long clients are asked to wait when rendering is busy
+const retryRendersAfter = 5 * time.Second
+
+// uploadedFilesKey - gin context key of the input files checkUploads read
+const uploadedFilesKey = "uploadedFiles"
+
 // GameInfo is the info needed to fit into MetaRefCard
 // Returns:
 //   - Game label / name
@@ -35,17 +80,33 @@ var GamesInfo []GameInfo = []GameInfo{fs2020.GetGameInfo, sws.GetGameInfo}
 // GetServer will run the server
 func GetServer(debugMode bool, gameArgs GameToInputFiles) (*gin.Engine, string) {
 	log := common.NewLog()
-	// Load the configuration
-	common.LoadYaml("config/config.yaml", &config, "Config", log)
-	// Load the device information
-	common.LoadDevicesInfo(config.DevicesFile, &config.Devices, log)
+	loadConfig(log)
+	var err error
+	if cardStore, err = common.NewCardStore(config.CardStore); err != nil {
+		log.ErrAs(common.ErrorStorage, "Error creating card store, sending cards inline - %s", err)
+	}
+	if shareStore, err = common.NewShareStore(config.Share); err != nil {
+		log.ErrAs(common.ErrorStorage, "Error creating share store, sharing is disabled - %s", err)
+	}
+	rateLimiter = common.NewRateLimiter(config.RateLimit)
+	if token := os.Getenv("MRC_ADMIN_TOKEN"); len(token) > 0 {
+		config.Usage.AdminToken = token
+	}
+	if usageRecorder, err = common.NewUsageRecorder(config.Usage); err != nil {
+		log.ErrAs(common.ErrorStorage, "Error creating usage recorder, usage isn't recorded - %s", err)
+	}
 
 	if !debugMode {
 		gin.SetMode(gin.ReleaseMode)
 	}
 	router := gin.Default()
+	if err := trustProxies(router, &config.RateLimit); err != nil {
+		log.Err("Error setting trusted proxies, trusting none - %s", err)
+	}
+	router.Use(recordRequests, limitRate)
 	if debugMode {
 		pprof.Register(router)
+		log.Dbg("Using %s for JPEG encoding and decoding", common.JpegCodec)
 	}
 
 	router.LoadHTMLGlob("resources/www/templates/*.html")
@@ -59,8 +120,22 @@ func GetServer(debugMode bool, gameArgs GameToInputFiles) (*gin.Engine, string)
 		c.Redirect(http.StatusFound, "/fs2020")
 	})
 
+	// Probes and build info
+	router.GET("/healthz", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	router.GET("/readyz", sendReadiness)
+	router.GET("/version", sendVersion)
+	// Prometheus metrics
+	router.GET("/metrics", sendMetrics)
+	// Usage statistics
+	router.GET("/admin/usage", checkAdminToken(true), sendUsagePage)
+	router.GET("/admin/usage.csv", checkAdminToken(false), sendUsageCSV)
+	router.POST("/admin/login", adminLogin)
+
 	for _, game := range GamesInfo {
 		label, _, handleRequest, matchGameInputToModel := game()
+		handleRequest = observeHandler(label, handleRequest)
 		router.GET(fmt.Sprintf("/%s", label), func(c *gin.Context) {
 			c.HTML(http.StatusOK, fmt.Sprintf("%s.html", label), gin.H{
 				"Title":   config.AppName,
@@ -69,19 +144,48 @@ func GetServer(debugMode bool, gameArgs GameToInputFiles) (*gin.Engine, string)
 			})
 		})
 		// Flight simulator endpoint
-		router.POST(fmt.Sprintf("/api/%s", label), func(c *gin.Context) {
+		router.POST(fmt.Sprintf("/api/%s", label), checkUploads(true), limitRenders, func(c *gin.Context) {
 			// Use the posted form data
-			sendResponse(loadFormFiles(c, log), handleRequest, matchGameInputToModel, c)
+			sendResponse(label, loadFormFiles(c, log), handleRequest,
+				matchGameInputToModel, c)
+		})
+		// ZIP of the cards, bindings and log
+		router.POST(fmt.Sprintf("/api/%s/zip", label), checkUploads(true), limitRenders, func(c *gin.Context) {
+			sendArchive(label, loadFormFiles(c, log), handleRequest,
+				matchGameInputToModel, c)
+		})
+		// Save the bindings for a shareable link
+		router.POST(fmt.Sprintf("/api/%s/share", label), checkUploads(true), func(c *gin.Context) {
+			sendShare(label, loadFormFiles(c, log), handleRequest, c)
+		})
+		// JSON API endpoint
+		router.POST(fmt.Sprintf("/api/v2/%s", label), checkUploads(true), limitRenders, func(c *gin.Context) {
+			sendJSON(label, loadFormFiles(c, log), handleRequest,
+				matchGameInputToModel, c)
 		})
 		if debugMode {
-			router.GET(fmt.Sprintf("/test/%s", label), func(c *gin.Context) {
+			router.GET(fmt.Sprintf("/test/%s", label), limitRenders, func(c *gin.Context) {
 				// Use local files (specified on the command line)
-				sendResponse(loadLocalFiles(*gameArgs[label], log), handleRequest,
-					matchGameInputToModel, c)
+				if err := checkLocalFiles(*gameArgs[label]); err != nil {
+					sendUploadError(c, err)
+					return
+				}
+				sendResponse(label, loadLocalFiles(*gameArgs[label], log),
+					handleRequest, matchGameInputToModel, c)
 			})
 		}
 
 	}
+	// Generated cards linked from the pages
+	router.GET(common.CardStorePath+":name", sendStoredCard)
+	// Shared cards, rendered on demand
+	router.GET(common.SharePath+":id", sendSharedPage)
+	router.GET("/api/card/:id", limitRenders, sendSharedCards)
+	router.DELETE("/api/card/:id", deleteSharedCard)
+	// Render posted cards again from their embedded metadata
+	router.POST("/api/rerender", checkUploads(false), limitRenders, func(c *gin.Context) {
+		sendRerender(loadFormFiles(c, log), c)
+	})
 
 	// Run on port 8080 unless PORT varilable specified
 	port := os.Getenv("PORT")
@@ -91,12 +195,579 @@ func GetServer(debugMode bool, gameArgs GameToInputFiles) (*gin.Engine, string)
 	return router, fmt.Sprintf(":%s", port)
 }
 
+// loadConfig loads the main configuration and the device information
+func loadConfig(log *common.Logger) {
+	common.LoadYaml(configFile, &config, "Config", log)
+	if err := config.CheckLayout(); err != nil {
+		log.Fatal("Config %v", err)
+	}
+	common.LoadDevicesInfo(config.DevicesFile, &config.Devices, log)
+	common.ConfigureRendering(config)
+}
+
+// Shutdown saves what the server hasn't written yet, once it stops taking
+// requests i.e. the day's usage statistics
+func Shutdown(log *common.Logger) {
+	if usageRecorder == nil {
+		return
+	}
+	if err := usageRecorder.Flush(); err != nil {
+		log.ErrAs(common.ErrorStorage, "Error writing usage - %s", err)
+	}
+}
+
+// GenerateCards renders the reference cards for a game's input files without
+// running the server. Returns the generated images in profile and image order.
+func GenerateCards(gameLabel string, files [][]byte, opts *common.RequestOptions,
+	log *common.Logger) ([]bytes.Buffer, error) {
+	for _, game := range GamesInfo {
+		label, _, handleRequest, matchGameInputToModel := game()
+		if label != gameLabel {
+			continue
+		}
+		if config == nil {
+			loadConfig(log)
+		}
+		gameData, gameBinds, gameAxes, gameDevices, _, gameLogo :=
+			handleRequest(files, config, log)
+		generatedFiles, _, _, _ := generateCards(label, common.HashSources(files),
+			gameData, gameBinds, gameAxes, gameDevices, nil, gameLogo,
+			matchGameInputToModel, opts, log)
+		return generatedFiles, nil
+	}
+	return nil, fmt.Errorf("unsupported game %s", gameLabel)
+}
+
+// RerenderCard renders a card again, with the current layouts and themes, from
+// the bindings metadata embedded in it
+func RerenderCard(card []byte, opts *common.RequestOptions, log *common.Logger) (
+	[]bytes.Buffer, []bytes.Buffer, []common.CardChips, error) {
+	metadata, err := common.ReadCardMetadata(card)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return renderBindings(metadata.Game, metadata.Sources, metadata.GameBinds(),
+		metadata.GameAxes(), metadata.Colours, metadata.RequestOptions(opts),
+		log)
+}
+
+// renderBindings renders cards, with the current layouts and themes, from
+// bindings that were parsed earlier e.g. card metadata or a shared card.
+// Contexts keep the colours passed in, others are assigned one.
+func renderBindings(game string, sources []string,
+	gameBinds common.GameBindsByProfile, gameAxes common.GameAxesByProfile,
+	colours common.ContextToColours, opts *common.RequestOptions,
+	log *common.Logger) (
+	[]bytes.Buffer, []bytes.Buffer, []common.CardChips, error) {
+	for _, gameInfo := range GamesInfo {
+		label, _, handleRequest, matchGameInputToModel := gameInfo()
+		if label != game {
+			continue
+		}
+		if config == nil {
+			loadConfig(log)
+		}
+		// Without input files the handler only loads the game's data
+		gameData, _, _, _, _, gameLogo := handleRequest(nil, config, log)
+		gameDevices := make(common.Set)
+		for _, devices := range gameBinds {
+			for shortName := range devices {
+				gameDevices[shortName] = true
+			}
+		}
+		generatedFiles, thumbnails, cardChips, _ := generateCards(label, sources,
+			gameData, gameBinds, gameAxes, gameDevices, colours, gameLogo,
+			matchGameInputToModel, opts, log)
+		return generatedFiles, thumbnails, cardChips, nil
+	}
+	return nil, nil, nil, fmt.Errorf("unsupported game %s", game)
+}
+
+// generateCards renders the cards for the game binds and embeds the bindings
+// metadata in each card. Contexts keep the colours passed in e.g. from a card's
+// metadata. The other contexts drawn are assigned one after the request's
+// context filter is applied. Also returns the overlays drawn.
+func generateCards(label string, sources []string, gameData common.GameData,
+	gameBinds common.GameBindsByProfile, gameAxes common.GameAxesByProfile,
+	gameDevices common.Set,
+	colours common.ContextToColours, gameLogo string,
+	matchFunc common.FuncMatchGameInputToModel, opts *common.RequestOptions,
+	log *common.Logger) ([]bytes.Buffer, []bytes.Buffer, []common.CardChips,
+	common.OverlaysByProfile) {
+	gameContexts := opts.ShownContexts(gameBinds, colours)
+	common.GenerateContextColours(gameContexts, gameData.ContextColours, config)
+	overlaysByImage, hidden := common.PopulateImageOverlays(gameDevices, config,
+		log, gameBinds, gameAxes, gameData, matchFunc, opts)
+	common.RecordOverlays(label, overlaysByImage)
+	generatedFiles, thumbnails, cardChips, _ := common.GenerateImages(
+		overlaysByImage, hidden, gameContexts, gameLogo, config, log, opts)
+	for idx, card := range cardChips {
+		if generatedFiles[idx].Len() == 0 {
+			continue
+		}
+		metadata := common.NewCardMetadata(label, config.Version, sources,
+			card.Profile, card.Image, gameBinds, gameAxes, overlaysByImage,
+			config.Devices.ImageMap, gameContexts, opts)
+		embedded, err := common.EmbedCardMetadata(generatedFiles[idx].Bytes(),
+			&metadata)
+		if err != nil {
+			log.ErrAs(common.ErrorImage, "Error embedding metadata in %s card - %s", card.Image, err)
+			continue
+		}
+		generatedFiles[idx] = *bytes.NewBuffer(embedded)
+	}
+	return generatedFiles, thumbnails, cardChips, overlaysByImage
+}
+
+// GenerateReport writes a binding report for a game's input files in the
+// report format without running the server
+func GenerateReport(gameLabel string, files [][]byte, opts *common.RequestOptions,
+	log *common.Logger) ([]byte, error) {
+	for _, game := range GamesInfo {
+		label, _, handleRequest, matchGameInputToModel := game()
+		if label != gameLabel {
+			continue
+		}
+		if config == nil {
+			loadConfig(log)
+		}
+		gameData, gameBinds, _, gameDevices, _, _ := handleRequest(files, config, log)
+		rows := common.BindingRows(gameDevices, config, log, gameBinds, gameData,
+			matchGameInputToModel, opts)
+		var report bytes.Buffer
+		err := common.WriteBindingReport(&report, opts.Format, rows)
+		return report.Bytes(), err
+	}
+	return nil, fmt.Errorf("unsupported game %s", gameLabel)
+}
+
+// requestOptions reads the per request options from the posted form or, for
+// the debug GET endpoints, the query string
+func requestOptions(c *gin.Context, log *common.Logger) *common.RequestOptions {
+	if c == nil || c.Request == nil {
+		return nil
+	}
+	opts := &common.RequestOptions{
+		Contexts: common.ParseContextFilter(formValue(c, "include"),
+			formValue(c, "exclude"), log),
+		Format:   formValue(c, "format"),
+		Title:    common.CleanHeaderText(formValue(c, "title")),
+		Subtitle: common.CleanHeaderText(formValue(c, "subtitle")),
+		Pilot:    common.CleanHeaderText(formValue(c, "pilot")),
+	}
+	if value := formValue(c, "scale"); len(value) > 0 {
+		scale, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			log.ErrAs(common.ErrorOption, "Invalid scale %s - %s", value, err)
+		} else {
+			opts.Scale = scale
+		}
+	}
+	if value := formValue(c, "interactive"); len(value) > 0 {
+		interactive, err := strconv.ParseBool(value)
+		if err != nil {
+			log.ErrAs(common.ErrorOption, "Invalid interactive %s - %s", value, err)
+		} else {
+			opts.Interactive = interactive
+		}
+	}
+	if value := formValue(c, "composite"); len(value) > 0 {
+		composite, err := strconv.ParseBool(value)
+		if err != nil {
+			log.ErrAs(common.ErrorOption, "Invalid composite %s - %s", value, err)
+		} else {
+			opts.Composite = composite
+		}
+	}
+	if value := formValue(c, "width"); len(value) > 0 {
+		width, err := strconv.Atoi(value)
+		if err != nil {
+			log.ErrAs(common.ErrorOption, "Invalid width %s - %s", value, err)
+		} else {
+			opts.Width = width
+		}
+	}
+	return opts
+}
+
+func formValue(c *gin.Context, key string) string {
+	if value, found := c.GetPostForm(key); found {
+		return value
+	}
+	return c.Query(key)
+}
+
+// checkUploads returns middleware rejecting requests whose files are over the
+// upload limits, before they're parsed. Input files are also checked to be
+// game input files. Cards posted to be rendered again are much larger than
+// input files so they have their own size limits.
+func checkUploads(inputFiles bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limits := &config.Uploads
+		maxBytes := limits.MaxRequestBytes()
+		if !inputFiles {
+			maxBytes = limits.MaxCardRequestBytes()
+		}
+		if maxBytes > 0 {
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+		}
+		form, err := c.MultipartForm()
+		if err != nil {
+			// Requests without files are left to the endpoint
+			if err := limits.CheckRequestSize(err); errors.As(err, new(*common.UploadError)) {
+				sendUploadError(c, err)
+			}
+			return
+		}
+		files := form.File["file"]
+		if err := limits.CheckFileCount(len(files)); err != nil {
+			sendUploadError(c, err)
+			return
+		}
+		contents := make([][]byte, len(files))
+		for idx, file := range files {
+			if !inputFiles {
+				if err := limits.CheckCardSize(file.Filename, file.Size); err != nil {
+					sendUploadError(c, err)
+					return
+				}
+				continue
+			}
+			if contents[idx], err = checkFormFile(file, limits); err != nil {
+				sendUploadError(c, err)
+				return
+			}
+		}
+		if inputFiles {
+			// Read once, the endpoint's loadFormFiles uses them
+			c.Set(uploadedFilesKey, contents)
+		}
+	}
+}
+
+// checkFormFile checks an uploaded input file's size, content type and
+// contents. Returns the contents.
+func checkFormFile(file *multipart.FileHeader, limits *common.UploadLimits) (
+	[]byte, error) {
+	if err := limits.CheckFileSize(file.Filename, file.Size); err != nil {
+		return nil, err
+	}
+	if err := common.CheckContentType(file.Filename,
+		file.Header.Get("Content-Type")); err != nil {
+		return nil, err
+	}
+	reader, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	contents, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	return contents, common.CheckInputFile(file.Filename, contents)
+}
+
+// checkLocalFiles applies the upload limits to the debug endpoints' local
+// files
+func checkLocalFiles(files []string) error {
+	limits := &config.Uploads
+	if err := limits.CheckFileCount(len(files)); err != nil {
+		return err
+	}
+	var total int64
+	for _, filename := range files {
+		info, err := os.Stat(filename)
+		if err != nil {
+			// Logged when the files are loaded
+			continue
+		}
+		total += info.Size()
+		if err := limits.CheckFileSize(filename, info.Size()); err != nil {
+			return err
+		}
+		if maxBytes := limits.MaxRequestBytes(); maxBytes > 0 && total > maxBytes {
+			return limits.CheckRequestSize(&http.MaxBytesError{Limit: maxBytes})
+		}
+		contents, err := os.ReadFile(filename)
+		if err != nil {
+			continue
+		}
+		if err := common.CheckInputFile(filename, contents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendUploadError rejects the request with the upload error's status and
+// message. Other errors are bad requests.
+func sendUploadError(c *gin.Context, err error) {
+	status := http.StatusBadRequest
+	var uploadErr *common.UploadError
+	if errors.As(err, &uploadErr) {
+		status = uploadErr.Status
+	}
+	sendError(c, status, err.Error())
+}
+
+// sendError rejects the request with the status and message, as JSON if the
+// client accepts it
+func sendError(c *gin.Context, status int, msg string) {
+	if acceptsJSON(c) {
+		c.AbortWithStatusJSON(status, gin.H{"Error": msg})
+		return
+	}
+	c.Data(status, "text/plain; charset=utf-8", []byte(msg))
+	c.Abort()
+}
+
+// observeHandler returns the game's request handler recording how long it
+// takes to parse input files. Usage is recorded by checkBindings.
+func observeHandler(label string, handler common.FuncRequestHandler) common.FuncRequestHandler {
+	return func(files [][]byte, config *common.Config, log *common.Logger) (
+		common.GameData, common.GameBindsByProfile, common.GameAxesByProfile,
+		common.Set, common.ContextToColours, string) {
+		start := time.Now()
+		gameData, gameBinds, gameAxes, gameDevices, gameContexts, gameLogo :=
+			handler(files, config, log)
+		// Without files the handler only loads the game's data
+		if len(files) > 0 {
+			common.RecordParse(label, time.Since(start))
+		}
+		return gameData, gameBinds, gameAxes, gameDevices, gameContexts, gameLogo
+	}
+}
+
+// recordRequests is middleware counting requests and their latency by route
+func recordRequests(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+	route := c.FullPath()
+	if len(route) == 0 {
+		route = "unmatched"
+	}
+	common.RecordRequest(route, c.Request.Method, c.Writer.Status(),
+		time.Since(start))
+}
+
+// gameLabels returns the supported games' labels
+func gameLabels() []string {
+	labels := make([]string, 0, len(GamesInfo))
+	for _, game := range GamesInfo {
+		label, _, _, _ := game()
+		labels = append(labels, label)
+	}
+	return labels
+}
+
+// sendReadiness responds with the readiness checks, 503 if any failed
+func sendReadiness(c *gin.Context) {
+	var games map[string]common.GameData
+	if config != nil {
+		games = make(map[string]common.GameData, len(GamesInfo))
+		for _, game := range GamesInfo {
+			label, _, handleRequest, _ := game()
+			// Without files the handler only loads the game's data, once
+			gameData, _, _, _, _, _ := handleRequest(nil, config, common.NewLog())
+			games[label] = gameData
+		}
+	}
+	checks := common.CheckReadiness(config, games)
+	status := http.StatusOK
+	ready := common.Ready(checks)
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, gin.H{"Ready": ready, "Checks": checks})
+}
+
+// sendVersion responds with the server's name, version, commit and what it
+// supports
+func sendVersion(c *gin.Context) {
+	c.JSON(http.StatusOK, common.VersionInfo{
+		AppName: config.AppName,
+		Version: config.Version,
+		Commit:  common.BuildCommit(),
+		Games:   gameLabels(),
+		Devices: len(config.Devices.Index),
+	})
+}
+
+// sendMetrics responds with the metrics in the Prometheus formats
+var sendMetrics = gin.WrapH(common.MetricsHandler())
+
+// checkAdminToken returns middleware only letting requests with the admin
+// token as a bearer token, or an admin session cookie, see the usage
+// statistics. Pages show a form to sign in instead of an error.
+func checkAdminToken(loginPage bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if usageRecorder == nil || len(config.Usage.AdminToken) == 0 {
+			sendError(c, http.StatusNotFound, "Usage statistics are disabled")
+			return
+		}
+		if validAdminToken(bearerToken(c)) {
+			return
+		}
+		if session, err := c.Cookie(adminCookie); err == nil && hmac.Equal(
+			[]byte(session), []byte(adminSession())) {
+			return
+		}
+		c.Header("WWW-Authenticate", "Bearer")
+		if loginPage {
+			c.HTML(http.StatusUnauthorized, "usage.html", adminPage(gin.H{
+				"Login": true}))
+			c.Abort()
+			return
+		}
+		sendError(c, http.StatusUnauthorized, "Invalid admin token")
+	}
+}
+
+// adminLogin starts an admin session given the admin token in the posted form
+func adminLogin(c *gin.Context) {
+	if usageRecorder == nil || len(config.Usage.AdminToken) == 0 {
+		sendError(c, http.StatusNotFound, "Usage statistics are disabled")
+		return
+	}
+	if !validAdminToken(c.PostForm("token")) {
+		c.HTML(http.StatusUnauthorized, "usage.html", adminPage(gin.H{
+			"Login": true, "Error": "Invalid admin token"}))
+		return
+	}
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(adminCookie, adminSession(), int(adminSessionAge.Seconds()),
+		"/admin", "", true, true)
+	c.Redirect(http.StatusSeeOther, "/admin/usage")
+}
+
+func validAdminToken(token string) bool {
+	return len(token) > 0 && subtle.ConstantTimeCompare([]byte(token),
+		[]byte(config.Usage.AdminToken)) == 1
+}
+
+// adminSession returns the admin session cookie value. It's derived from the
+// admin token so changing the token ends sessions, without the cookie holding
+// the token itself.
+func adminSession() string {
+	mac := hmac.New(sha256.New, []byte(config.Usage.AdminToken))
+	mac.Write([]byte("metarefcard admin session"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// adminPage returns the usage page's data with the common page fields
+func adminPage(page gin.H) gin.H {
+	page["Title"] = config.AppName
+	page["Version"] = config.Version
+	page["Domain"] = config.Domain
+	return page
+}
+
+// usageRows returns the usage statistics for the days parameter, 30 days by
+// default
+func usageRows(c *gin.Context) (int, []common.UsageRow, error) {
+	days, err := strconv.Atoi(formValue(c, "days"))
+	if err != nil || days <= 0 {
+		days = 30
+	}
+	days = min(days, maxUsageDays)
+	since := time.Now().AddDate(0, 0, 1-days)
+	rows, err := usageRecorder.Rows(since)
+	return days, rows, err
+}
+
+// sendUsagePage responds with the page of usage totals
+func sendUsagePage(c *gin.Context) {
+	days, rows, err := usageRows(c)
+	page := adminPage(gin.H{
+		"Days":   days,
+		"Totals": common.UsageTotals(rows),
+		"CSV":    fmt.Sprintf("/admin/usage.csv?days=%d", days),
+	})
+	if err != nil {
+		common.NewLog().Err("Error loading usage - %s", err)
+		page["Error"] = "Usage statistics couldn't be loaded"
+	}
+	c.HTML(http.StatusOK, "usage.html", page)
+}
+
+// sendUsageCSV responds with the daily usage counts as CSV
+func sendUsageCSV(c *gin.Context) {
+	days, rows, err := usageRows(c)
+	if err != nil {
+		common.NewLog().Err("Error loading usage - %s", err)
+		sendError(c, http.StatusInternalServerError, "Error loading usage statistics")
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(
+		`attachment; filename="metarefcard-usage-%dd.csv"`, days))
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Status(http.StatusOK)
+	if err := common.WriteUsageCSV(c.Writer, rows); err != nil {
+		common.NewLog().Err("Error writing usage - %s", err)
+	}
+}
+
+// trustProxies sets where the router takes client IPs from. By default gin
+// believes X-Forwarded-For from anyone, so clients could dodge the rate limit
+// by sending a new address with each request.
+func trustProxies(router *gin.Engine, data *common.RateLimitData) error {
+	router.TrustedPlatform = data.TrustedPlatform
+	if err := router.SetTrustedProxies(data.TrustedProxies); err != nil {
+		router.SetTrustedProxies(nil)
+		return err
+	}
+	return nil
+}
+
+// limitRate is middleware rejecting API and admin requests from clients over
+// the rate limit with a 429
+func limitRate(c *gin.Context) {
+	if rateLimiter == nil || !slices.ContainsFunc(rateLimitedPrefixes,
+		func(prefix string) bool {
+			return strings.HasPrefix(c.Request.URL.Path, prefix)
+		}) {
+		return
+	}
+	allowed, wait := rateLimiter.Allow(c.ClientIP())
+	if allowed {
+		return
+	}
+	seconds := retryAfterSeconds(wait)
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	sendError(c, http.StatusTooManyRequests, fmt.Sprintf(
+		"Too many requests, please try again in %d seconds", seconds))
+}
+
+// limitRenders is middleware rejecting requests with a 503 when too many are
+// already rendering or waiting to render
+func limitRenders(c *gin.Context) {
+	maxRequests := int64(config.Render.MaxRequests)
+	if renderRequests.Add(1) > maxRequests && maxRequests > 0 {
+		renderRequests.Add(-1)
+		seconds := retryAfterSeconds(retryRendersAfter)
+		c.Header("Retry-After", strconv.Itoa(seconds))
+		sendError(c, http.StatusServiceUnavailable, fmt.Sprintf(
+			"The server is busy generating cards, please try again in %d seconds",
+			seconds))
+		return
+	}
+	defer renderRequests.Add(-1)
+	c.Next()
+}
+
+// retryAfterSeconds rounds a wait up to the whole seconds of a Retry-After
+// header
+func retryAfterSeconds(wait time.Duration) int {
+	return max(1, int((wait+time.Second-1)/time.Second))
+}
+
 func loadLocalFiles(files []string, log *common.Logger) [][]byte {
 	var inputFiles [][]byte
 	for _, filename := range files {
 		file, err := os.ReadFile(filename)
 		if err != nil {
-			log.Err("Error reading file. %s", err)
+			log.ErrAs(common.ErrorUpload, "Error reading file. %s", err)
 		}
 		inputFiles = append(inputFiles, file)
 	}
@@ -104,9 +775,13 @@ func loadLocalFiles(files []string, log *common.Logger) [][]byte {
 }
 
 func loadFormFiles(c *gin.Context, log *common.Logger) [][]byte {
+	// Input files are read when checkUploads checks them
+	if files, found := c.Get(uploadedFilesKey); found {
+		return files.([][]byte)
+	}
 	form, err := c.MultipartForm()
 	if err != nil {
-		log.Err("Error getting MultipartForm - %s", err)
+		log.ErrAs(common.ErrorUpload, "Error getting MultipartForm - %s", err)
 		return make([][]byte, 0)
 	}
 
@@ -124,13 +799,13 @@ func processMultipartFiles(inputFiles []*multipart.FileHeader, log *common.Logge
 	for idx, file := range inputFiles {
 		multipart, err := opener(file)
 		if err != nil {
-			log.Err("Error opening multipart file %s - %s", file.Filename, err)
+			log.ErrAs(common.ErrorUpload, "Error opening multipart file %s - %s", file.Filename, err)
 			continue
 		}
 		defer multipart.Close()
 		contents, err := io.ReadAll(multipart)
 		if err != nil {
-			log.Err("Error reading multipart file %s - %s", file.Filename, err)
+			log.ErrAs(common.ErrorUpload, "Error reading multipart file %s - %s", file.Filename, err)
 			continue
 		}
 		files[idx] = contents
@@ -138,26 +813,317 @@ func processMultipartFiles(inputFiles []*multipart.FileHeader, log *common.Logge
 	return files
 }
 
-func sendResponse(loadedFiles [][]byte, handler common.FuncRequestHandler,
-	matchFunc common.FuncMatchGameInputToModel, c *gin.Context) {
+func sendResponse(label string, loadedFiles [][]byte,
+	handler common.FuncRequestHandler, matchFunc common.FuncMatchGameInputToModel,
+	c *gin.Context) {
 	log := common.NewLog()
+	opts := requestOptions(c, log)
+	if opts != nil && len(opts.Format) > 0 {
+		sendReport(label, loadedFiles, handler, matchFunc, opts, c, log)
+		return
+	}
+	if acceptsJSON(c) {
+		sendJSONResponse(label, loadedFiles, handler, matchFunc, opts, c, log)
+		return
+	}
 
 	// Call game handler to generate image overlayes
-	gameData, gameBinds, gameDevices, gameContexts, gameLogo :=
+	gameData, gameBinds, gameAxes, gameDevices, _, gameLogo :=
 		handler(loadedFiles, config, log)
-	overlaysByImage := common.PopulateImageOverlays(gameDevices, config, log,
-		gameBinds, gameData, matchFunc)
+	if !checkBindings(label, gameBinds, gameDevices, c, log) {
+		return
+	}
 
 	// Now generate images from the overlays
-	generatedFiles, _ := common.GenerateImages(overlaysByImage, gameContexts,
-		gameLogo, config, log)
+	generatedFiles, thumbnails, cardChips, _ := generateCards(label,
+		common.HashSources(loadedFiles), gameData, gameBinds, gameAxes,
+		gameDevices, nil, gameLogo, matchFunc, opts, log)
+	sendCards(generatedFiles, thumbnails, cardChips, opts, c, log)
+}
+
+// checkBindings sends an error for bindings over the upload limits, returning
+// false. Bindings within them are counted in the usage statistics.
+func checkBindings(label string, gameBinds common.GameBindsByProfile,
+	gameDevices common.Set, c *gin.Context, log *common.Logger) bool {
+	if err := config.Uploads.CheckBindings(gameBinds, gameDevices); err != nil {
+		sendUploadError(c, err)
+		return false
+	}
+	if usageRecorder != nil {
+		usageRecorder.RecordRequest(label, gameDevices, len(gameBinds))
+		for _, device := range log.UnsupportedDevices() {
+			usageRecorder.RecordUnsupportedDevice(label, device)
+		}
+	}
+	return true
+}
+
+// acceptsJSON returns true if the request prefers JSON to HTML
+func acceptsJSON(c *gin.Context) bool {
+	if c == nil || c.Request == nil {
+		return false
+	}
+	return c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON
+}
+
+// sendJSON responds with the JSON API response for the input files
+func sendJSON(label string, loadedFiles [][]byte, handler common.FuncRequestHandler,
+	matchFunc common.FuncMatchGameInputToModel, c *gin.Context) {
+	log := common.NewLog()
+	sendJSONResponse(label, loadedFiles, handler, matchFunc, requestOptions(c, log),
+		c, log)
+}
+
+// sendJSONResponse responds with the bindings, overlays, cards and logs as JSON
+func sendJSONResponse(label string, loadedFiles [][]byte,
+	handler common.FuncRequestHandler, matchFunc common.FuncMatchGameInputToModel,
+	opts *common.RequestOptions, c *gin.Context, log *common.Logger) {
+	gameData, gameBinds, gameAxes, gameDevices, _, gameLogo :=
+		handler(loadedFiles, config, log)
+	if !checkBindings(label, gameBinds, gameDevices, c, log) {
+		return
+	}
+	generatedFiles, _, cardChips, overlays := generateCards(label,
+		common.HashSources(loadedFiles), gameData, gameBinds, gameAxes,
+		gameDevices, nil, gameLogo, matchFunc, opts, log)
+	c.JSON(http.StatusOK, common.NewAPIResponse(label, config.Version, gameBinds,
+		gameAxes, overlays, generatedFiles, cardChips,
+		config.Devices.DeviceLabelsByImage, cardStore, log))
+}
+
+// sendArchive responds with a ZIP of the generated cards, the bindings and the
+// log. The archive is written to a buffer first so a failure can still be
+// sent as an error.
+func sendArchive(label string, loadedFiles [][]byte, handler common.FuncRequestHandler,
+	matchFunc common.FuncMatchGameInputToModel, c *gin.Context) {
+	log := common.NewLog()
+	opts := requestOptions(c, log)
+	gameData, gameBinds, gameAxes, gameDevices, _, gameLogo :=
+		handler(loadedFiles, config, log)
+	if !checkBindings(label, gameBinds, gameDevices, c, log) {
+		return
+	}
+	generatedFiles, _, cardChips, _ := generateCards(label,
+		common.HashSources(loadedFiles), gameData, gameBinds, gameAxes,
+		gameDevices, nil, gameLogo, matchFunc, opts, log)
+	// Matching errors were logged generating the cards, don't log them twice
+	rows := common.BindingRows(gameDevices, config, common.NewLog(), gameBinds,
+		gameData, matchFunc, opts)
+
+	var archive bytes.Buffer
+	if err := common.WriteCardArchive(&archive, generatedFiles, cardChips,
+		config.Devices.DeviceLabelsByImage, rows, log); err != nil {
+		log.ErrAs(common.ErrorStorage, "Error writing archive - %s", err)
+		sendError(c, http.StatusInternalServerError, "Error writing archive")
+		return
+	}
+	c.Header("Content-Disposition",
+		fmt.Sprintf("attachment; filename=\"metarefcard-%s.zip\"", label))
+	c.Data(http.StatusOK, "application/zip", archive.Bytes())
+}
+
+// sendShare saves the parsed bindings and the request options for a shareable
+// link. Responds with the link and the token that deletes it.
+func sendShare(label string, loadedFiles [][]byte, handler common.FuncRequestHandler,
+	c *gin.Context) {
+	log := common.NewLog()
+	if shareStore == nil {
+		c.JSON(http.StatusNotFound, common.ShareResponse{Error: "Sharing is disabled"})
+		return
+	}
+	opts := requestOptions(c, log)
+	_, gameBinds, gameAxes, gameDevices, _, _ := handler(loadedFiles, config, log)
+	if !checkBindings(label, gameBinds, gameDevices, c, log) {
+		return
+	}
+	if len(gameBinds) == 0 {
+		c.JSON(http.StatusBadRequest, common.ShareResponse{
+			Error: "No bindings to share", Logs: log.Entries})
+		return
+	}
+	card, deleteToken, err := common.NewSharedCard(label, config.Version,
+		common.HashSources(loadedFiles), gameBinds, gameAxes, opts,
+		time.Duration(config.Share.TTLDays)*24*time.Hour)
+	var id string
+	if err == nil {
+		id, err = shareStore.Create(card)
+	}
+	if err != nil {
+		log.ErrAs(common.ErrorStorage, "Error saving shared card - %s", err)
+		c.JSON(http.StatusInternalServerError, common.ShareResponse{
+			Error: "Error saving shared card", Logs: log.Entries})
+		return
+	}
+	response := common.ShareResponse{ID: id, URL: common.SharePath + id,
+		DeleteToken: deleteToken, Logs: log.Entries}
+	if !card.Expires.IsZero() {
+		response.Expires = &card.Expires
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// loadSharedCard returns the shared card with the HTTP status to respond with
+// if it couldn't be loaded
+func loadSharedCard(id string, log *common.Logger) (*common.SharedCard, int, error) {
+	if shareStore == nil {
+		return nil, http.StatusNotFound, common.ErrShareNotFound
+	}
+	card, err := shareStore.Load(id)
+	if errors.Is(err, common.ErrShareNotFound) {
+		return nil, http.StatusNotFound, err
+	} else if err != nil {
+		log.ErrAs(common.ErrorStorage, "Error loading shared card %s - %s", id, err)
+		return nil, http.StatusInternalServerError, err
+	}
+	return card, http.StatusOK, nil
+}
+
+// sendSharedPage responds with the page for a shared card. The page loads the
+// cards from /api/card/<id>.
+func sendSharedPage(c *gin.Context) {
+	id := c.Param("id")
+	page := gin.H{
+		"Title":   config.AppName,
+		"Version": config.Version,
+		"Domain":  config.Domain,
+		"ID":      id,
+	}
+	card, status, err := loadSharedCard(id, common.NewLog())
+	if err != nil {
+		page["Error"] = "This shared card doesn't exist or has expired"
+		if status != http.StatusNotFound {
+			page["Error"] = "This shared card couldn't be loaded"
+		}
+		c.HTML(status, "shared.html", page)
+		return
+	}
+	page["Game"] = card.Game
+	if !card.Expires.IsZero() {
+		page["Expires"] = card.Expires.Format("2 January 2006")
+	}
+	c.HTML(http.StatusOK, "shared.html", page)
+}
+
+// sendSharedCards responds with the HTML for a shared card's cards, rendered
+// with the current layouts and themes
+func sendSharedCards(c *gin.Context) {
+	log := common.NewLog()
+	card, status, err := loadSharedCard(c.Param("id"), log)
+	if err != nil {
+		c.Data(status, "text/plain; charset=utf-8", []byte(err.Error()))
+		return
+	}
+	generatedFiles, thumbnails, cardChips, err := renderBindings(card.Game,
+		card.Sources, card.Bindings, card.Axes, nil, &card.Options, log)
+	if err != nil {
+		log.ErrAs(common.ErrorImage, "Error rendering shared card - %s", err)
+	}
+	sendCards(generatedFiles, thumbnails, cardChips, &card.Options, c, log)
+}
+
+// bearerToken returns the token in the request's Authorization header. Tokens
+// aren't taken from the URL as URLs are logged.
+func bearerToken(c *gin.Context) string {
+	token, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return token
+}
 
+// deleteSharedCard deletes a shared card given its delete token as a bearer
+// token
+func deleteSharedCard(c *gin.Context) {
+	log := common.NewLog()
+	id := c.Param("id")
+	card, status, err := loadSharedCard(id, log)
+	if err != nil {
+		c.Data(status, "text/plain; charset=utf-8", []byte(err.Error()))
+		return
+	}
+	if !card.CheckDeleteToken(bearerToken(c)) {
+		c.Data(http.StatusForbidden, "text/plain; charset=utf-8",
+			[]byte("Invalid delete token"))
+		return
+	}
+	if err := shareStore.Delete(id); err != nil &&
+		!errors.Is(err, common.ErrShareNotFound) {
+		log.ErrAs(common.ErrorStorage, "Error deleting shared card %s - %s", id, err)
+		c.Data(http.StatusInternalServerError, "text/plain; charset=utf-8",
+			[]byte("Error deleting shared card"))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// sendStoredCard responds with a card from the card store. Cards are named by
+// their content so they can be cached until they expire.
+func sendStoredCard(c *gin.Context) {
+	name := c.Param("name")
+	if cardStore == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	data, found := cardStore.Get(name)
+	if !found {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	etag := fmt.Sprintf("\"%s\"", strings.TrimSuffix(name, path.Ext(name)))
+	cacheControl := "public, immutable"
+	if ttl := cardStore.TTL(); ttl > 0 {
+		cacheControl = fmt.Sprintf("public, max-age=%d, immutable", int(ttl.Seconds()))
+	}
+	c.Header("Cache-Control", cacheControl)
+	c.Header("ETag", etag)
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, common.CardContentType(name), data)
+}
+
+// sendRerender responds with the posted cards rendered again. Cards that
+// can't be rendered are logged, the request fails if none can.
+func sendRerender(cards [][]byte, c *gin.Context) {
+	log := common.NewLog()
+	opts := requestOptions(c, log)
+	if len(cards) == 0 {
+		sendError(c, http.StatusBadRequest, "No cards to render again")
+		return
+	}
+	var generatedFiles, thumbnails []bytes.Buffer
+	var cardChips []common.CardChips
+	var lastErr error
+	for _, card := range cards {
+		files, cardThumbnails, chips, err := RerenderCard(card, opts, log)
+		if err != nil {
+			log.ErrAs(common.ErrorImage, "Error rendering card again - %s", err)
+			lastErr = err
+			continue
+		}
+		generatedFiles = append(generatedFiles, files...)
+		thumbnails = append(thumbnails, cardThumbnails...)
+		cardChips = append(cardChips, chips...)
+	}
+	if len(generatedFiles) == 0 && lastErr != nil {
+		sendError(c, http.StatusUnprocessableEntity,
+			fmt.Sprintf("Error rendering card again - %s", lastErr))
+		return
+	}
+	sendCards(generatedFiles, thumbnails, cardChips, opts, c, log)
+}
+
+// sendCards responds with the HTML for the generated cards followed by the logs
+func sendCards(generatedFiles []bytes.Buffer, thumbnails []bytes.Buffer,
+	cardChips []common.CardChips, opts *common.RequestOptions, c *gin.Context,
+	log *common.Logger) {
 	// Generate HTML for images
 	cardTempl := "resources/www/templates/refcard.html"
+	if opts != nil && opts.Interactive {
+		cardTempl = "resources/www/templates/refcard_interactive.html"
+	}
 	t, err := template.New(path.Base(cardTempl)).ParseFiles(cardTempl)
 	if err != nil {
 		s := fmt.Sprintf("Error parsing card template - %s", err)
-		log.Err("%s", s)
+		log.ErrAs(common.ErrorTemplate, "%s", s)
 		if c != nil {
 			c.Data(http.StatusInternalServerError, "text/html; charset=utf-8",
 				[]byte(s))
@@ -166,38 +1132,97 @@ func sendResponse(loadedFiles [][]byte, handler common.FuncRequestHandler,
 	}
 
 	// Render images using the extracted function
-	renderImages(generatedFiles, t, c, log)
+	renderImages(generatedFiles, thumbnails, cardChips, t, c, log)
 
 	// Generate HTML for logs
 	logTempl := "resources/www/templates/log.html"
 	l, err := template.New(path.Base(logTempl)).ParseFiles(logTempl)
 	if err != nil {
 		s := fmt.Sprintf("Error parsing logging template - %s", err)
-		log.Err("%s", s)
+		log.ErrAs(common.ErrorTemplate, "%s", s)
 		c.Data(http.StatusInternalServerError, "text/html; charset=utf-8", []byte(s))
 	} else {
 		var tpl bytes.Buffer
 		err = l.Execute(&tpl, struct{ Logs []*common.LogEntry }{Logs: log.Entries})
 		if err != nil {
-			log.Err("Error executing logging template - %s", err)
+			log.ErrAs(common.ErrorTemplate, "Error executing logging template - %s", err)
 		}
 		c.Data(http.StatusOK, "text/html; charset=utf-8", tpl.Bytes())
 	}
 }
 
+// sendReport responds with a binding report instead of images
+func sendReport(label string, loadedFiles [][]byte, handler common.FuncRequestHandler,
+	matchFunc common.FuncMatchGameInputToModel, opts *common.RequestOptions,
+	c *gin.Context, log *common.Logger) {
+	format, found := common.ReportFormats[opts.Format]
+	if !found {
+		c.Data(http.StatusBadRequest, "text/plain; charset=utf-8",
+			[]byte(fmt.Sprintf("Unsupported format %s", opts.Format)))
+		return
+	}
+	gameData, gameBinds, _, gameDevices, _, _ := handler(loadedFiles, config, log)
+	if !checkBindings(label, gameBinds, gameDevices, c, log) {
+		return
+	}
+	rows := common.BindingRows(gameDevices, config, log, gameBinds, gameData,
+		matchFunc, opts)
+	var report bytes.Buffer
+	if err := common.WriteBindingReport(&report, opts.Format, rows); err != nil {
+		s := fmt.Sprintf("Error writing report - %s", err)
+		log.Err("%s", s)
+		c.Data(http.StatusInternalServerError, "text/plain; charset=utf-8", []byte(s))
+		return
+	}
+	c.Data(http.StatusOK, format.ContentType, report.Bytes())
+}
+
 // renderImages renders generated images using the template and sends them as HTTP responses.
+// The page shows the thumbnail, if there is one, and opens the full image on click.
+// Interactive templates position the chips over the image. Cards are linked
+// from the card store if there is one, otherwise they're inlined as data URLs.
 // Extracted from sendResponse for testability.
-func renderImages(generatedFiles []bytes.Buffer, t *template.Template, c *gin.Context, log *common.Logger) {
-	type base64Image struct {
-		Base64Contents string
+func renderImages(generatedFiles []bytes.Buffer, thumbnails []bytes.Buffer,
+	cardChips []common.CardChips, t *template.Template, c *gin.Context,
+	log *common.Logger) {
+	type chip struct {
+		Style   template.CSS // Position as a percentage of the image
+		Context string
+		Actions []common.OverlayAction
 	}
-	for _, file := range generatedFiles {
-		image := base64Image{
-			Base64Contents: base64.StdEncoding.EncodeToString(file.Bytes()),
+	type cardImage struct {
+		ContentsURL  template.URL // Card store or data URL
+		ThumbnailURL template.URL
+		Profile      string
+		Chips        []chip
+	}
+	for idx, file := range generatedFiles {
+		image := cardImage{ContentsURL: template.URL(
+			common.CardURL(cardStore, file.Bytes(), log))}
+		if idx < len(cardChips) && cardChips[idx].Width > 0 {
+			card := cardChips[idx]
+			image.Profile = card.Profile
+			w, h := float64(card.Width)/100, float64(card.Height)/100
+			for _, cardChip := range card.Chips {
+				r := cardChip.Rect
+				image.Chips = append(image.Chips, chip{
+					Style: template.CSS(fmt.Sprintf(
+						"left: %.3f%%; top: %.3f%%; width: %.3f%%; height: %.3f%%",
+						float64(r.Min.X)/w, float64(r.Min.Y)/h,
+						float64(r.Dx())/w, float64(r.Dy())/h)),
+					Context: cardChip.Context,
+					Actions: cardChip.Actions,
+				})
+			}
+		}
+		image.ThumbnailURL = image.ContentsURL
+		if idx < len(thumbnails) && thumbnails[idx].Len() > 0 {
+			image.ThumbnailURL = template.URL(
+				common.CardURL(cardStore, thumbnails[idx].Bytes(), log))
 		}
 		var tpl bytes.Buffer
 		if err := t.Execute(&tpl, image); err != nil {
-			log.Err("Error executing image template - %s", err)
+			log.ErrAs(common.ErrorTemplate, "Error executing image template - %s", err)
 			continue
 		}
 		c.Data(http.StatusOK, "text/html; charset=utf-8", tpl.Bytes())
@@ -1,24 +1,71 @@
 package mrc
 
 import (
+	"archive/zip"
 	"bytes"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"image"
 	"image/jpeg"
 	"io"
+	"maps"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/textproto"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/ankurkotwal/metarefcard/mrc/common"
+	"github.com/ankurkotwal/metarefcard/mrc/fs2020"
 )
 
+// testFile is a file uploaded by postFiles. It's named input.xml.
+type testFile struct {
+	contentType string // Defaults to application/octet-stream
+	contents    string
+}
+
+// newFilesRequest returns a multipart POST request of the files, in the file
+// field, and the form fields
+func newFilesRequest(url string, files []testFile, fields map[string]string) *http.Request {
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	for _, file := range files {
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", `form-data; name="file"; filename="input.xml"`)
+		header.Set("Content-Type", "application/octet-stream")
+		if len(file.contentType) > 0 {
+			header.Set("Content-Type", file.contentType)
+		}
+		part, _ := writer.CreatePart(header)
+		part.Write([]byte(file.contents))
+	}
+	for name, value := range fields {
+		writer.WriteField(name, value)
+	}
+	writer.Close()
+	req := httptest.NewRequest("POST", url, body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+// postFiles posts the files and form fields to the router
+func postFiles(router http.Handler, url string, files []testFile,
+	fields map[string]string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newFilesRequest(url, files, fields))
+	return w
+}
+
 func TestFilenames(t *testing.T) {
 	var f Filenames
 	if f.String() != "" {
@@ -108,6 +155,11 @@ GeneratedFile: "config/generated.yaml"
 	if port != ":8080" {
 		t.Errorf("Port mismatch %s", port)
 	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "ok" {
+		t.Errorf("Expected healthz ok, got %d %s", w.Code, w.Body.String())
+	}
 }
 
 func TestGetServer_WithPORTEnv(t *testing.T) {
@@ -218,7 +270,7 @@ InputImages:
 	
 	defer os.RemoveAll("resources")
 	// Template must be valid html/template
-	os.WriteFile("resources/www/templates/refcard.html", []byte("{{.Base64Contents}}"), 0644)
+	os.WriteFile("resources/www/templates/refcard.html", []byte("{{.ContentsURL}}"), 0644)
 	os.WriteFile("resources/www/templates/log.html", []byte("{{range .Logs}}{{.Msg}}{{end}}"), 0644)
 	
 	// Setup game input file
@@ -259,23 +311,29 @@ Regexes:
 	
 	// Test POST /api/fs2020
 	// This exercises loadFormFiles -> sendResponse
-	body := new(bytes.Buffer)
-	writer := multipart.NewWriter(body)
-	part, err := writer.CreateFormFile("file", "input.xml")
-	if err != nil {
-		t.Fatal(err)
-	}
-	part.Write(sampleXML)
-	writer.Close()
-	
-	reqPost, _ := http.NewRequest("POST", "/api/fs2020", body)
-	reqPost.Header.Set("Content-Type", writer.FormDataContentType())
-	wPost := httptest.NewRecorder()
-	router.ServeHTTP(wPost, reqPost)
-	
+	sample := []testFile{{contents: string(sampleXML)}}
+	wPost := postFiles(router, "/api/fs2020", sample, nil)
 	if wPost.Code != http.StatusOK {
 		t.Errorf("POST /api/fs2020 failed: %d", wPost.Code)
 	}
+
+	// Test POST /api/fs2020/zip responds with a ZIP
+	wZip := postFiles(router, "/api/fs2020/zip", sample, nil)
+	if wZip.Code != http.StatusOK || wZip.Header().Get("Content-Type") != "application/zip" {
+		t.Errorf("POST /api/fs2020/zip failed: %d %s", wZip.Code, wZip.Header().Get("Content-Type"))
+	}
+	archive, err := zip.NewReader(bytes.NewReader(wZip.Body.Bytes()), int64(wZip.Body.Len()))
+	if err != nil || len(archive.File) < 3 {
+		t.Errorf("Expected the bindings and log in the ZIP - %v", err)
+	}
+
+	// Test POST /api/v2/fs2020 responds with JSON
+	wJSON := postFiles(router, "/api/v2/fs2020", sample, nil)
+	var response common.APIResponse
+	if wJSON.Code != http.StatusOK ||
+		json.Unmarshal(wJSON.Body.Bytes(), &response) != nil || response.Game != "fs2020" {
+		t.Errorf("POST /api/v2/fs2020 failed: %d %s", wJSON.Code, wJSON.Body.String())
+	}
 	
 	// Test GET / (home page)
 	reqHome, _ := http.NewRequest("GET", "/", nil)
@@ -339,8 +397,9 @@ func TestSendResponseErrors(t *testing.T) {
 	os.Remove("resources/www/templates/refcard.html")
 	
 	mockHandler := func(files [][]byte, config *common.Config, log *common.Logger) (
-		common.GameData, common.GameBindsByProfile, common.Set, common.ContextToColours, string) {
-		return common.GameData{}, nil, nil, nil, ""
+		common.GameData, common.GameBindsByProfile, common.GameAxesByProfile, common.Set,
+		common.ContextToColours, string) {
+		return common.GameData{}, nil, nil, nil, nil, ""
 	}
 	
 	mockMatch := func(deviceName string, action common.GameInput, inputs common.DeviceInputs,
@@ -356,7 +415,7 @@ func TestSendResponseErrors(t *testing.T) {
 	// We need to set it.
 	config = &common.Config{}
 	
-	sendResponse(nil, mockHandler, mockMatch, c)
+	sendResponse("test", nil, mockHandler, mockMatch, c)
 	
 	if w.Code != http.StatusInternalServerError {
 		t.Errorf("Expected 500 for missing template, got %d", w.Code)
@@ -383,13 +442,14 @@ func TestSendResponse_LogTemplateError(t *testing.T) {
 	os.MkdirAll("resources/www/templates", 0755)
 	defer os.RemoveAll("resources")
 	// Exists
-	os.WriteFile("resources/www/templates/refcard.html", []byte("{{.Base64Contents}}"), 0644)
+	os.WriteFile("resources/www/templates/refcard.html", []byte("{{.ContentsURL}}"), 0644)
 	// Missing log.html
 	os.Remove("resources/www/templates/log.html")
 	
 	mockHandler := func(files [][]byte, config *common.Config, log *common.Logger) (
-		common.GameData, common.GameBindsByProfile, common.Set, common.ContextToColours, string) {
-		return common.GameData{}, nil, nil, nil, ""
+		common.GameData, common.GameBindsByProfile, common.GameAxesByProfile, common.Set,
+		common.ContextToColours, string) {
+		return common.GameData{}, nil, nil, nil, nil, ""
 	}
 	mockMatch := func(deviceName string, action common.GameInput, inputs common.DeviceInputs,
 		gameInputMap common.InputTypeMapping, log *common.Logger) (common.GameInput, string) {
@@ -401,7 +461,7 @@ func TestSendResponse_LogTemplateError(t *testing.T) {
 	config = &common.Config{}
 	
 	// This should run image generation, render images, then try to render log and fail
-	sendResponse(nil, mockHandler, mockMatch, c)
+	sendResponse("test", nil, mockHandler, mockMatch, c)
 	
 	// Should return 500
 	if w.Code != http.StatusInternalServerError {
@@ -440,7 +500,7 @@ Index:
 	defer os.RemoveAll("resources")
 	
 	// BAD TEMPLATE
-	os.WriteFile("resources/www/templates/refcard.html", []byte("{{call .Base64Contents}}"), 0644)
+	os.WriteFile("resources/www/templates/refcard.html", []byte("{{call .ContentsURL}}"), 0644)
 	os.WriteFile("resources/www/templates/log.html", []byte(""), 0644)
 	
 	// Setup game input file
@@ -498,14 +558,15 @@ func TestSendResponse_LogTemplateExecError(t *testing.T) {
 	defer os.RemoveAll("resources")
 	
 	// Valid refcard template
-	os.WriteFile("resources/www/templates/refcard.html", []byte("{{.Base64Contents}}"), 0644)
+	os.WriteFile("resources/www/templates/refcard.html", []byte("{{.ContentsURL}}"), 0644)
 	
 	// Invalid LOG template for execution
 	os.WriteFile("resources/www/templates/log.html", []byte("{{call .Logs}}"), 0644)
 	
 	mockHandler := func(files [][]byte, config *common.Config, log *common.Logger) (
-		common.GameData, common.GameBindsByProfile, common.Set, common.ContextToColours, string) {
-		return common.GameData{}, nil, nil, nil, ""
+		common.GameData, common.GameBindsByProfile, common.GameAxesByProfile, common.Set,
+		common.ContextToColours, string) {
+		return common.GameData{}, nil, nil, nil, nil, ""
 	}
 	mockMatch := func(deviceName string, action common.GameInput, inputs common.DeviceInputs,
 		gameInputMap common.InputTypeMapping, log *common.Logger) (common.GameInput, string) {
@@ -516,7 +577,7 @@ func TestSendResponse_LogTemplateExecError(t *testing.T) {
 	c, _ := gin.CreateTestContext(w)
 	config = &common.Config{}
 	
-	sendResponse(nil, mockHandler, mockMatch, c)
+	sendResponse("test", nil, mockHandler, mockMatch, c)
 	
 	if w.Code != http.StatusOK {
 		t.Errorf("Expected 200 OK (log error logged but response sent), got %d", w.Code)
@@ -673,7 +734,7 @@ InputImages:
 	createDummyJpg(t, "resources/images/test_game.jpg")
 	
 	// Valid templates
-	os.WriteFile("resources/www/templates/refcard.html", []byte("{{.Base64Contents}}"), 0644)
+	os.WriteFile("resources/www/templates/refcard.html", []byte("{{.ContentsURL}}"), 0644)
 	os.WriteFile("resources/www/templates/log.html", []byte("{{range .Logs}}{{.Msg}}{{end}}"), 0644)
 	
 	// Load the config properly
@@ -683,7 +744,8 @@ InputImages:
 	
 	// Create mock handler that returns data that will generate images
 	mockHandler := func(files [][]byte, cfg *common.Config, log *common.Logger) (
-		common.GameData, common.GameBindsByProfile, common.Set, common.ContextToColours, string) {
+		common.GameData, common.GameBindsByProfile, common.GameAxesByProfile, common.Set,
+		common.ContextToColours, string) {
 		
 		gameData := common.GameData{
 			Logo: "test_game",
@@ -702,7 +764,7 @@ InputImages:
 		neededDevices := common.Set{"TestDevice": true}
 		contexts := common.ContextToColours{"TestContext": "#FF0000"}
 		
-		return gameData, gameBinds, neededDevices, contexts, "test_game"
+		return gameData, gameBinds, nil, neededDevices, contexts, "test_game"
 	}
 	
 	mockMatch := func(deviceName string, action common.GameInput, inputs common.DeviceInputs,
@@ -713,11 +775,30 @@ InputImages:
 	w := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(w)
 	
-	sendResponse(nil, mockHandler, mockMatch, c)
+	sendResponse("test", nil, mockHandler, mockMatch, c)
 	
 	if w.Code != http.StatusOK {
 		t.Errorf("Expected 200, got %d", w.Code)
 	}
+
+	// Clients asking for JSON get the JSON API response
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/api/test", nil)
+	c.Request.Header.Set("Accept", "application/json")
+	sendResponse("test", nil, mockHandler, mockMatch, c)
+	var response common.APIResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Expected JSON, got %s - %v", w.Body.String(), err)
+	}
+	if response.APIVersion != common.APIVersion || response.Game != "test" ||
+		response.Bindings[common.ProfileDefault]["TestDevice"]["TestContext"]["TestAction"][0] != "Button1" {
+		t.Errorf("Unexpected response %+v", response)
+	}
+	// The device isn't in this config so no cards, just the errors
+	if len(response.Logs) == 0 || !response.Logs[0].IsError {
+		t.Errorf("Expected the logs, got %+v", response.Logs)
+	}
 }
 
 func TestRenderImages(t *testing.T) {
@@ -725,7 +806,7 @@ func TestRenderImages(t *testing.T) {
 	log := common.NewLog()
 	
 	// Create a valid template
-	tmpl, err := template.New("test").Parse("{{.Base64Contents}}")
+	tmpl, err := template.New("test").Parse("{{.ContentsURL}}")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -738,7 +819,7 @@ func TestRenderImages(t *testing.T) {
 	w := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(w)
 	
-	renderImages(generatedFiles, tmpl, c, log)
+	renderImages(generatedFiles, nil, nil, tmpl, c, log)
 	
 	if w.Code != http.StatusOK {
 		t.Errorf("Expected 200, got %d", w.Code)
@@ -755,14 +836,14 @@ func TestRenderImages_Empty(t *testing.T) {
 	// Test renderImages with no images
 	log := common.NewLog()
 	
-	tmpl, _ := template.New("test").Parse("{{.Base64Contents}}")
+	tmpl, _ := template.New("test").Parse("{{.ContentsURL}}")
 	
 	generatedFiles := []bytes.Buffer{}
 	
 	w := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(w)
 	
-	renderImages(generatedFiles, tmpl, c, log)
+	renderImages(generatedFiles, nil, nil, tmpl, c, log)
 	
 	// Should complete without error
 	if w.Code != http.StatusOK {
@@ -775,7 +856,7 @@ func TestRenderImages_TemplateError(t *testing.T) {
 	log := common.NewLog()
 	
 	// Template that will fail on execution (calling non-function)
-	tmpl, _ := template.New("test").Parse("{{call .Base64Contents}}")
+	tmpl, _ := template.New("test").Parse("{{call .ContentsURL}}")
 	
 	var img bytes.Buffer
 	img.WriteString("test content")
@@ -784,7 +865,7 @@ func TestRenderImages_TemplateError(t *testing.T) {
 	w := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(w)
 	
-	renderImages(generatedFiles, tmpl, c, log)
+	renderImages(generatedFiles, nil, nil, tmpl, c, log)
 	
 	// Error should be logged, but function continues
 	foundError := false
@@ -802,7 +883,7 @@ func TestRenderImages_MultipleImages(t *testing.T) {
 	// Test renderImages with multiple images
 	log := common.NewLog()
 	
-	tmpl, _ := template.New("test").Parse("{{.Base64Contents}}")
+	tmpl, _ := template.New("test").Parse("{{.ContentsURL}}")
 	
 	var img1, img2, img3 bytes.Buffer
 	img1.WriteString("image1")
@@ -813,7 +894,7 @@ func TestRenderImages_MultipleImages(t *testing.T) {
 	w := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(w)
 	
-	renderImages(generatedFiles, tmpl, c, log)
+	renderImages(generatedFiles, nil, nil, tmpl, c, log)
 	
 	if w.Code != http.StatusOK {
 		t.Errorf("Expected 200, got %d", w.Code)
@@ -821,3 +902,853 @@ func TestRenderImages_MultipleImages(t *testing.T) {
 }
 
 
+
+func TestRenderImages_Thumbnail(t *testing.T) {
+	log := common.NewLog()
+	tmpl, _ := template.New("test").Parse("{{.ThumbnailURL}}|{{.ContentsURL}}")
+
+	var img1, img2, thumb1 bytes.Buffer
+	img1.WriteString("image1")
+	img2.WriteString("image2")
+	thumb1.WriteString("thumb1")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	renderImages([]bytes.Buffer{img1, img2}, []bytes.Buffer{thumb1, {}}, nil, tmpl, c, log)
+
+	encode := func(data string) string {
+		return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString([]byte(data))
+	}
+	expected := encode("thumb1") + "|" + encode("image1") + encode("image2") + "|" +
+		encode("image2")
+	if body := w.Body.String(); body != expected {
+		t.Errorf("Expected %s, got %s", expected, body)
+	}
+}
+
+func TestRenderImages_CardStore(t *testing.T) {
+	store := common.NewMemoryCardStore(1<<20, time.Hour)
+	cardStore = store
+	defer func() { cardStore = nil }()
+	log := common.NewLog()
+	tmpl, _ := template.New("test").Parse("{{.ThumbnailURL}}|{{.ContentsURL}}")
+
+	var img, thumb bytes.Buffer
+	img.WriteString("image")
+	thumb.WriteString("thumb")
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	renderImages([]bytes.Buffer{img}, []bytes.Buffer{thumb}, nil, tmpl, c, log)
+
+	thumbName := common.CardName([]byte("thumb"), "jpg")
+	imageName := common.CardName([]byte("image"), "jpg")
+	expected := "/cards/" + thumbName + "|/cards/" + imageName
+	if body := w.Body.String(); body != expected {
+		t.Errorf("Expected %s, got %s", expected, body)
+	}
+	if data, found := store.Get(imageName); !found || string(data) != "image" {
+		t.Errorf("Expected the card stored, got %s", data)
+	}
+}
+
+func TestSendStoredCard(t *testing.T) {
+	router := gin.New()
+	router.GET(common.CardStorePath+":name", sendStoredCard)
+	get := func(url string, etag string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", url, nil)
+		if len(etag) > 0 {
+			req.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	name := common.CardName([]byte("card"), "jpg")
+
+	// Without a store there are no cards
+	if w := get("/cards/"+name, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without a store, got %d", w.Code)
+	}
+
+	cardStore = common.NewMemoryCardStore(1<<20, time.Hour)
+	defer func() { cardStore = nil }()
+	cardStore.Put(name, []byte("card"))
+	w := get("/cards/"+name, "")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || w.Body.String() != "card" ||
+		w.Header().Get("Content-Type") != "image/jpeg" ||
+		w.Header().Get("Cache-Control") != "public, max-age=3600, immutable" ||
+		etag != fmt.Sprintf("%q", strings.TrimSuffix(name, ".jpg")) {
+		t.Errorf("Unexpected response %d %v", w.Code, w.Header())
+	}
+	if w := get("/cards/"+name, etag); w.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for a matching ETag, got %d", w.Code)
+	}
+	if w := get("/cards/"+common.CardName([]byte("other"), "jpg"), ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing card, got %d", w.Code)
+	}
+}
+
+func TestRenderImages_Interactive(t *testing.T) {
+	log := common.NewLog()
+	tmpl, err := template.ParseFiles("../resources/www/templates/refcard_interactive.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var img, thumbnail bytes.Buffer
+	img.WriteString("image")
+	thumbnail.WriteString("thumb")
+	cardChips := []common.CardChips{{Profile: "Hornet", Width: 200, Height: 100,
+		Chips: []common.CardChip{{Rect: image.Rect(20, 10, 70, 30), Context: "PLANE",
+			Actions: []common.OverlayAction{{Text: "Gear", Action: "GEAR_TOGGLE",
+				Primary: "Button 1", Secondary: "Button 2"}}}}}}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	renderImages([]bytes.Buffer{img}, []bytes.Buffer{thumbnail}, cardChips, tmpl,
+		c, log)
+
+	body := w.Body.String()
+	for _, expected := range []string{
+		`data-context="PLANE"`,
+		`style="left: 10.000%; top: 10.000%; width: 25.000%; height: 20.000%"`,
+		"<strong>GEAR_TOGGLE</strong>", "Context: PLANE", "Primary: Button 1",
+		"Secondary: Button 2", "Profile: Hornet",
+		// The thumbnail is shown, the full card opens on click
+		`data-full="data:image/jpeg;base64,aW1hZ2U="`,
+		`src="data:image/jpeg;base64,dGh1bWI="`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected %q in\n%s", expected, body)
+		}
+	}
+}
+
+func TestSendResponse_Report(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockHandler := func(files [][]byte, config *common.Config, log *common.Logger) (
+		common.GameData, common.GameBindsByProfile, common.GameAxesByProfile, common.Set,
+		common.ContextToColours, string) {
+		binds := common.GameBindsByProfile{"Default": common.GameDeviceContextActions{
+			"d1": common.GameContextActions{"PLANE": common.GameActions{
+				"GEAR": {"Button 1", ""}}}}}
+		return common.GameData{}, binds, nil, common.Set{"d1": true}, nil, ""
+	}
+	mockMatch := func(deviceName string, action common.GameInput, inputs common.DeviceInputs,
+		gameInputMap common.InputTypeMapping, log *common.Logger) (common.GameInput, string) {
+		return common.GameInput{"1", ""}, ""
+	}
+	config = &common.Config{}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/test/fs2020?format=csv", nil)
+	sendResponse("test", nil, mockHandler, mockMatch, c)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Errorf("Expected csv report, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if !bytes.Contains(w.Body.Bytes(), []byte("Default,d1,PLANE,GEAR,GEAR,Button 1,,1")) {
+		t.Errorf("Unexpected report %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/test/fs2020?format=pdf", nil)
+	sendResponse("test", nil, mockHandler, mockMatch, c)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unsupported format, got %d", w.Code)
+	}
+}
+
+func TestGenerateReport_UnsupportedGame(t *testing.T) {
+	_, err := GenerateReport("unknown", nil, &common.RequestOptions{Format: "csv"},
+		common.NewLog())
+	if err == nil {
+		t.Error("Expected error for unsupported game")
+	}
+}
+
+func TestRerenderCard_Errors(t *testing.T) {
+	log := common.NewLog()
+	if _, _, _, err := RerenderCard([]byte("not a card"), nil, log); err == nil {
+		t.Error("Expected error without metadata")
+	}
+
+	var jpg bytes.Buffer
+	jpeg.Encode(&jpg, image.NewRGBA(image.Rect(0, 0, 8, 8)), nil)
+	card, _ := common.EmbedCardMetadata(jpg.Bytes(), &common.CardMetadata{
+		Generator: common.MetadataGenerator, Game: "unknown"})
+	if _, _, _, err := RerenderCard(card, nil, log); err == nil {
+		t.Error("Expected error for unsupported game")
+	}
+}
+
+func TestGenerateCards_FilteredColours(t *testing.T) {
+	t.Chdir("..")
+	defer func(previous *common.Config) { config = previous }(config)
+	config = nil
+	log := common.NewLog()
+	file, err := os.ReadFile("testdata/fs2020/T.16000M.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &common.RequestOptions{
+		Contexts: common.ParseContextFilter("", "*camera", log)}
+	cards, err := GenerateCards("fs2020", [][]byte{file}, opts, log)
+	if err != nil || len(cards) == 0 {
+		t.Fatalf("Expected cards, got %d %v", len(cards), err)
+	}
+	// Only the contexts drawn are given a colour, as if the others weren't bound
+	metadata, err := common.ReadCardMetadata(cards[0].Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, handler, _ := fs2020.GetGameInfo()
+	gameData, _, _, _, _, _ := handler(nil, config, log)
+	expected := common.ContextToColours{"PLANE": "", "MENU": "", "MODES": "",
+		"MODE_PAUSE": ""}
+	common.GenerateContextColours(expected, gameData.ContextColours, config)
+	if !maps.Equal(metadata.Colours, expected) {
+		t.Errorf("Expected colours %v, got %v", expected, metadata.Colours)
+	}
+}
+
+func TestRerenderCard_KeepsColours(t *testing.T) {
+	t.Chdir("..")
+	defer func(previous *common.Config) { config = previous }(config)
+	config = nil
+	log := common.NewLog()
+	var files [][]byte
+	for _, name := range []string{"T.16000M.xml", "TWCS_Throttle.xml",
+		"Saitek_Pro_Flight_X-56_Rhino_Stick.xml"} {
+		file, err := os.ReadFile("testdata/fs2020/" + name)
+		if err != nil {
+			t.Fatalf("Failed to read %s - %s", name, err)
+		}
+		files = append(files, file)
+	}
+	cards, err := GenerateCards("fs2020", files, nil, log)
+	if err != nil || len(cards) == 0 {
+		t.Fatalf("Expected cards, got %d %v", len(cards), err)
+	}
+	// Each card is rendered again from its own bindings, without the other
+	// files' contexts, and looks the same
+	for idx, card := range cards {
+		rerendered, _, _, err := RerenderCard(card.Bytes(), nil, log)
+		if err != nil || len(rerendered) != 1 {
+			t.Fatalf("Expected card %d rendered again, got %d %v", idx,
+				len(rerendered), err)
+		}
+		if !bytes.Equal(card.Bytes(), rerendered[0].Bytes()) {
+			t.Errorf("Card %d changed when rendered again", idx)
+		}
+	}
+}
+
+func TestSendRerender_Errors(t *testing.T) {
+	os.MkdirAll("resources/www/templates", 0755)
+	defer os.RemoveAll("resources")
+	os.WriteFile("resources/www/templates/refcard.html", []byte("{{.ContentsURL}}"), 0644)
+	os.WriteFile("resources/www/templates/log.html", []byte("{{range .Logs}}{{.Msg}}{{end}}"), 0644)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	sendRerender([][]byte{[]byte("not a card")}, c)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 when no card renders, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "Error rendering card again") {
+		t.Errorf("Expected the error, got %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	sendRerender(nil, c)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without cards, got %d", w.Code)
+	}
+}
+
+func TestGenerateCards_UnsupportedGame(t *testing.T) {
+	_, err := GenerateCards("unknown", nil, nil, common.NewLog())
+	if err == nil {
+		t.Error("Expected error for unsupported game")
+	}
+}
+
+func TestRequestOptions(t *testing.T) {
+	if opts := requestOptions(nil, common.NewLog()); opts != nil {
+		t.Error("Expected nil options without a request")
+	}
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/test/fs2020?include=plane&exclude=*camera", nil)
+	opts := requestOptions(c, common.NewLog())
+	if !opts.Contexts.Allows("PLANE") || opts.Contexts.Allows("MENU") ||
+		opts.Contexts.Allows("COCKPIT_CAMERA") {
+		t.Errorf("Unexpected context filter %v", opts.Contexts)
+	}
+
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/test/fs2020?scale=0.5&width=1280&interactive=true&composite=1", nil)
+	opts = requestOptions(c, common.NewLog())
+	if opts.Scale != 0.5 || opts.Width != 1280 || !opts.Interactive || !opts.Composite {
+		t.Errorf("Unexpected scale %v, width %v and interactive %v", opts.Scale,
+			opts.Width, opts.Interactive)
+	}
+
+	log := common.NewLog()
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/test/fs2020?scale=big&width=wide&interactive=maybe&composite=no", nil)
+	opts = requestOptions(c, log)
+	if opts.Scale != 0 || opts.Width != 0 || opts.Interactive || opts.Composite ||
+		len(log.Entries) != 4 {
+		t.Errorf("Invalid sizes should be logged and ignored, got %v %v",
+			opts.Scale, opts.Width)
+	}
+
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET",
+		"/test/fs2020?title=A320&subtitle=+Captain++side+&pilot=Maverick", nil)
+	opts = requestOptions(c, common.NewLog())
+	if opts.Title != "A320" || opts.Subtitle != "Captain side" || opts.Pilot != "Maverick" {
+		t.Errorf("Unexpected header text %+v", opts)
+	}
+}
+
+func TestShare(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockHandler := func(files [][]byte, config *common.Config, log *common.Logger) (
+		common.GameData, common.GameBindsByProfile, common.GameAxesByProfile, common.Set,
+		common.ContextToColours, string) {
+		if len(files) == 0 {
+			return common.GameData{}, nil, nil, nil, nil, ""
+		}
+		binds := common.GameBindsByProfile{"Default": common.GameDeviceContextActions{
+			"d1": common.GameContextActions{"PLANE": common.GameActions{
+				"GEAR": {"Button 1", ""}}}}}
+		return common.GameData{}, binds, nil, common.Set{"d1": true}, nil, ""
+	}
+	config = &common.Config{AppName: "TestApp", Version: "1.0",
+		Share: common.ShareData{TTLDays: 30}}
+	router := gin.New()
+	router.LoadHTMLGlob("../resources/www/templates/*.html")
+	router.POST("/api/test/share", func(c *gin.Context) {
+		sendShare("test", loadFormFiles(c, common.NewLog()), mockHandler, c)
+	})
+	router.GET(common.SharePath+":id", sendSharedPage)
+	router.DELETE("/api/card/:id", deleteSharedCard)
+	share := func(file string) (*httptest.ResponseRecorder, common.ShareResponse) {
+		var files []testFile
+		if len(file) > 0 {
+			files = append(files, testFile{contents: file})
+		}
+		w := postFiles(router, "/api/test/share", files, map[string]string{"title": "A320"})
+		var response common.ShareResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w, response
+	}
+	serve := func(method string, url string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	deleteCard := func(id string, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("DELETE", "/api/card/"+id, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Sharing is disabled without a store
+	if w, _ := share("bindings"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without a store, got %d", w.Code)
+	}
+	if w := serve("GET", "/card/abcdefgh"); w.Code != http.StatusNotFound ||
+		!strings.Contains(w.Body.String(), "doesn&#39;t exist") {
+		t.Errorf("Expected the missing card page, got %d", w.Code)
+	}
+
+	store, _ := common.NewFileShareStore(t.TempDir())
+	shareStore = store
+	defer func() { shareStore = nil }()
+	if w, response := share(""); w.Code != http.StatusBadRequest ||
+		response.Error != "No bindings to share" {
+		t.Errorf("Expected 400 without bindings, got %d %+v", w.Code, response)
+	}
+	w, response := share("bindings")
+	if w.Code != http.StatusOK || response.URL != "/card/"+response.ID ||
+		len(response.DeleteToken) == 0 || response.Expires == nil {
+		t.Fatalf("Unexpected share response %d %s", w.Code, w.Body.String())
+	}
+	card, err := store.Load(response.ID)
+	if err != nil || card.Game != "test" || card.Options.Title != "A320" ||
+		card.Bindings["Default"]["d1"]["PLANE"]["GEAR"][0] != "Button 1" {
+		t.Errorf("Unexpected shared card %+v %v", card, err)
+	}
+
+	if w := serve("GET", response.URL); w.Code != http.StatusOK ||
+		!strings.Contains(w.Body.String(), `loadSharedCard("`+response.ID+`"`) {
+		t.Errorf("Expected the shared card page, got %d %s", w.Code, w.Body.String())
+	}
+	if w := deleteCard(response.ID, "wrong"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for the wrong token, got %d", w.Code)
+	}
+	// The token isn't taken from the URL
+	if w := serve("DELETE", "/api/card/"+response.ID+"?token="+
+		response.DeleteToken); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a token in the URL, got %d", w.Code)
+	}
+	if w := deleteCard(response.ID, response.DeleteToken); w.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 deleting, got %d", w.Code)
+	}
+	if w := deleteCard(response.ID, response.DeleteToken); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 deleting again, got %d", w.Code)
+	}
+}
+
+func TestCheckUploads(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	config = &common.Config{Uploads: common.UploadLimits{MaxFiles: 2, MaxFileKB: 1,
+		MaxRequestKB: 4, MaxCardKB: 3}}
+	router := gin.New()
+	handled := 0
+	var loaded [][]byte
+	router.POST("/api/test", checkUploads(true), func(c *gin.Context) {
+		handled++
+		// The files checked aren't read again
+		c.Request.MultipartForm.File = nil
+		loaded = loadFormFiles(c, common.NewLog())
+		c.Status(http.StatusOK)
+	})
+	router.POST("/api/rerender", checkUploads(false), func(c *gin.Context) {
+		handled++
+		c.Status(http.StatusOK)
+	})
+	xml := testFile{"text/xml", "<Device></Device>"}
+	large := testFile{"text/xml", "<Device>" + strings.Repeat(" ", 1100) + "</Device>"}
+
+	postFiles(router, "/api/test", []testFile{xml, xml}, nil)
+	if len(loaded) != 2 || string(loaded[1]) != xml.contents {
+		t.Errorf("Expected the checked files to be loaded, got %q", loaded)
+	}
+
+	for _, test := range []struct {
+		name    string
+		url     string
+		uploads []testFile
+		status  int
+		message string
+	}{
+		{"valid", "/api/test", []testFile{xml, {"", "GstInput.JoystickPitch 1"}}, http.StatusOK, ""},
+		{"too many files", "/api/test", []testFile{xml, xml, xml}, http.StatusBadRequest,
+			"Too many files, 3 were uploaded but at most 2 are allowed"},
+		{"large file", "/api/test", []testFile{large}, http.StatusRequestEntityTooLarge,
+			"input.xml is too large, files can be at most 1 KB"},
+		{"large request", "/api/test", []testFile{{"text/plain", strings.Repeat("a", 5000)}},
+			http.StatusRequestEntityTooLarge,
+			"The upload is too large, requests can be at most 4 KB"},
+		{"image", "/api/test", []testFile{{"image/jpeg", "jpg"}}, http.StatusBadRequest,
+			"input.xml isn't a game input file, its content type is image/jpeg"},
+		{"binary", "/api/test", []testFile{{"", "\x00\x01"}}, http.StatusBadRequest,
+			"input.xml isn't a game input file, it isn't UTF-8 text"},
+		{"cards", "/api/rerender", []testFile{{"image/jpeg", strings.Repeat("a", 2000)}},
+			http.StatusOK, ""},
+		{"too many cards", "/api/rerender", []testFile{xml, xml, xml}, http.StatusBadRequest,
+			"Too many files, 3 were uploaded but at most 2 are allowed"},
+		{"large card", "/api/rerender", []testFile{{"image/jpeg", strings.Repeat("a", 4000)}},
+			http.StatusRequestEntityTooLarge, "input.xml is too large, cards can be at most 3 KB"},
+		{"large cards request", "/api/rerender", []testFile{{"image/jpeg", strings.Repeat("a", 11000)}},
+			http.StatusRequestEntityTooLarge, "The upload is too large, requests can be at most 10 KB"},
+	} {
+		handled = 0
+		w := postFiles(router, test.url, test.uploads, nil)
+		if w.Code != test.status || (test.status != http.StatusOK && w.Body.String() != test.message) {
+			t.Errorf("%s expected %d %s, got %d %s", test.name, test.status, test.message,
+				w.Code, w.Body.String())
+		}
+		if (handled == 1) != (test.status == http.StatusOK) {
+			t.Errorf("%s handled %d times", test.name, handled)
+		}
+	}
+
+	// JSON clients get JSON errors
+	req := newFilesRequest("/api/test", []testFile{xml, xml, xml}, nil)
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var response struct{ Error string }
+	if w.Code != http.StatusBadRequest || json.Unmarshal(w.Body.Bytes(), &response) != nil ||
+		!strings.HasPrefix(response.Error, "Too many files") {
+		t.Errorf("Expected a JSON error, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestCheckLocalFiles(t *testing.T) {
+	config = &common.Config{Uploads: common.UploadLimits{MaxFiles: 2, MaxFileKB: 1,
+		MaxRequestKB: 1}}
+	dir := t.TempDir()
+	write := func(name string, contents string) string {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(contents), 0644)
+		return path
+	}
+	valid := write("valid.xml", "<Device></Device>")
+	half := write("half.xml", "<Device>"+strings.Repeat(" ", 600)+"</Device>")
+	binary := write("binary.xml", "\x00")
+	if err := checkLocalFiles([]string{valid, filepath.Join(dir, "missing.xml")}); err != nil {
+		t.Errorf("Expected valid files allowed, got %v", err)
+	}
+	for _, files := range [][]string{{valid, valid, valid}, {half, half}, {binary}} {
+		if err := checkLocalFiles(files); err == nil {
+			t.Errorf("Expected %v rejected", files)
+		}
+	}
+}
+
+func TestSendResponse_TooManyProfiles(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockHandler := func(files [][]byte, config *common.Config, log *common.Logger) (
+		common.GameData, common.GameBindsByProfile, common.GameAxesByProfile, common.Set,
+		common.ContextToColours, string) {
+		binds := common.GameBindsByProfile{"Default": nil, "Hornet": nil}
+		return common.GameData{}, binds, nil, common.Set{"d1": true}, nil, ""
+	}
+	config = &common.Config{Uploads: common.UploadLimits{MaxProfiles: 1}}
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/api/test", nil)
+	sendResponse("test", nil, mockHandler, nil, c)
+	if w.Code != http.StatusBadRequest ||
+		w.Body.String() != "Too many profiles, the files have 2 but at most 1 are allowed" {
+		t.Errorf("Expected 400 for too many profiles, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestLimitRate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	defer func() { rateLimiter = nil }()
+	rateLimiter = common.NewRateLimiter(common.RateLimitData{RequestsPerMinute: 1})
+	router := gin.New()
+	router.Use(limitRate)
+	router.GET("/api/test", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/admin/usage", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/fs2020", func(c *gin.Context) { c.Status(http.StatusOK) })
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w
+	}
+	if w := get("/api/test"); w.Code != http.StatusOK {
+		t.Errorf("Expected the first request allowed, got %d", w.Code)
+	}
+	w := get("/api/test")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Errorf("Expected 429 with Retry-After 60, got %d %q", w.Code,
+			w.Header().Get("Retry-After"))
+	}
+	if w := get("/admin/usage"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected admin requests limited, got %d", w.Code)
+	}
+	// Only the API and admin pages are limited
+	if w := get("/fs2020"); w.Code != http.StatusOK {
+		t.Errorf("Expected pages not limited, got %d", w.Code)
+	}
+}
+
+func TestLimitRate_SpoofedForwardedFor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	defer func() { rateLimiter = nil }()
+	rateLimiter = common.NewRateLimiter(common.RateLimitData{RequestsPerMinute: 1})
+	router := gin.New()
+	if err := trustProxies(router, &common.RateLimitData{}); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	router.Use(limitRate)
+	router.GET("/api/test", func(c *gin.Context) { c.Status(http.StatusOK) })
+	get := func(forwardedFor string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/api/test", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := get("203.0.113.1"); code != http.StatusOK {
+		t.Errorf("Expected the first request allowed, got %d", code)
+	}
+	// A new address each request is still the same client
+	if code := get("203.0.113.2"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected a spoofed X-Forwarded-For limited, got %d", code)
+	}
+
+	// Behind a trusted proxy its X-Forwarded-For is believed
+	rateLimiter = common.NewRateLimiter(common.RateLimitData{RequestsPerMinute: 1})
+	if err := trustProxies(router, &common.RateLimitData{
+		TrustedProxies: []string{"192.0.2.0/24"}}); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if code := get("203.0.113.1"); code != http.StatusOK {
+		t.Errorf("Expected the first client allowed, got %d", code)
+	}
+	if code := get("203.0.113.2"); code != http.StatusOK {
+		t.Errorf("Expected the second client allowed, got %d", code)
+	}
+	if err := trustProxies(router, &common.RateLimitData{
+		TrustedProxies: []string{"not a cidr"}}); err == nil {
+		t.Errorf("Expected an error for an invalid proxy")
+	}
+}
+
+func TestLimitRenders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	config = &common.Config{Render: common.RenderLimits{MaxRequests: 1}}
+	rendering := make(chan bool)
+	done := make(chan bool)
+	router := gin.New()
+	router.GET("/api/test", limitRenders, func(c *gin.Context) {
+		rendering <- true
+		<-done
+		c.Status(http.StatusOK)
+	})
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/api/test", nil)
+		req.Header.Set("Accept", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- get() }()
+	<-rendering
+	w := get()
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "5" ||
+		!strings.Contains(w.Body.String(), `"Error"`) {
+		t.Errorf("Expected 503 with Retry-After 5, got %d %q %s", w.Code,
+			w.Header().Get("Retry-After"), w.Body.String())
+	}
+	done <- true
+	if w := <-first; w.Code != http.StatusOK {
+		t.Errorf("Expected the first request rendered, got %d", w.Code)
+	}
+	// The slot is free again
+	go func() { <-rendering; done <- true }()
+	if w := get(); w.Code != http.StatusOK {
+		t.Errorf("Expected a request after the first rendered, got %d", w.Code)
+	}
+}
+
+func TestSendMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(recordRequests)
+	router.GET("/metrics", sendMetrics)
+	router.GET("/api/test", func(c *gin.Context) { c.Status(http.StatusTeapot) })
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/test", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/missing", nil))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("Expected metrics, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	for _, line := range []string{
+		`mrc_requests_total{method="GET",route="/api/test",status="418"} 1`,
+		`mrc_requests_total{method="GET",route="unmatched",status="404"} 1`,
+	} {
+		if !strings.Contains(w.Body.String(), line) {
+			t.Errorf("Expected %s in\n%s", line, w.Body.String())
+		}
+	}
+}
+
+func TestObserveHandler(t *testing.T) {
+	defer func() { usageRecorder = nil }()
+	usageRecorder, _ = common.NewUsageRecorder(common.UsageData{Dir: t.TempDir()})
+	calls := 0
+	handler := observeHandler("test", func(files [][]byte, config *common.Config,
+		log *common.Logger) (common.GameData, common.GameBindsByProfile,
+		common.GameAxesByProfile, common.Set, common.ContextToColours, string) {
+		calls++
+		return common.GameData{}, common.GameBindsByProfile{"Default": nil}, nil,
+			common.Set{"X55": true}, nil, "logo"
+	})
+	if _, _, _, _, _, logo := handler([][]byte{{}}, nil, common.NewLog()); logo != "logo" || calls != 1 {
+		t.Errorf("Expected the handler called, got %s %d", logo, calls)
+	}
+	// Loading the game's data isn't a request
+	handler(nil, nil, common.NewLog())
+	w := httptest.NewRecorder()
+	common.MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(w.Body.String(), `mrc_parse_duration_seconds_count{game="test"} 1`) {
+		t.Errorf("Expected the parse recorded in\n%s", w.Body.String())
+	}
+	// Usage is recorded once the upload limits are checked
+	if rows, _ := usageRecorder.Rows(time.Time{}); len(rows) != 0 {
+		t.Errorf("Expected no usage recorded parsing, got %v", rows)
+	}
+}
+
+func TestCheckBindings_Usage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	defer func() { usageRecorder = nil }()
+	usageRecorder, _ = common.NewUsageRecorder(common.UsageData{Dir: t.TempDir()})
+	config = &common.Config{Uploads: common.UploadLimits{MaxProfiles: 1}}
+	log := common.NewLog()
+	log.UnsupportedDevice("Mystery Stick")
+	check := func(gameBinds common.GameBindsByProfile) bool {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		return checkBindings("test", gameBinds, common.Set{"X55": true}, c, log)
+	}
+
+	// Requests over the limits aren't counted
+	if check(common.GameBindsByProfile{"Default": nil, "Hornet": nil}) {
+		t.Error("Expected too many profiles rejected")
+	}
+	if rows, _ := usageRecorder.Rows(time.Time{}); len(rows) != 0 {
+		t.Errorf("Expected no usage for a rejected request, got %v", rows)
+	}
+	if !check(common.GameBindsByProfile{"Default": nil}) {
+		t.Error("Expected the bindings allowed")
+	}
+	rows, _ := usageRecorder.Rows(time.Time{})
+	kinds := make(map[string]string)
+	for _, row := range rows {
+		kinds[row.Kind] = row.Name
+	}
+	if len(rows) != 4 || kinds[common.UsageDevice] != "X55" ||
+		kinds[common.UsageUnsupportedDevice] != "Mystery Stick" {
+		t.Errorf("Expected the request and its devices recorded, got %v", rows)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	defer func() { usageRecorder = nil }()
+	dir := t.TempDir()
+	usageRecorder, _ = common.NewUsageRecorder(common.UsageData{Dir: dir})
+	// The first request is written, the second waits for the next flush
+	usageRecorder.RecordRequest("sws", nil, 1)
+	usageRecorder.RecordRequest("sws", nil, 1)
+	Shutdown(common.NewLog())
+	restarted, _ := common.NewUsageRecorder(common.UsageData{Dir: dir})
+	rows, _ := restarted.Rows(time.Time{})
+	if len(rows) != 2 || rows[0].Count != 2 {
+		t.Errorf("Expected both requests written, got %v", rows)
+	}
+}
+
+func TestUsageAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	defer func() { usageRecorder = nil }()
+	config = &common.Config{Usage: common.UsageData{AdminToken: "secret"}}
+	router := gin.New()
+	router.LoadHTMLGlob("../resources/www/templates/*.html")
+	router.GET("/admin/usage", checkAdminToken(true), sendUsagePage)
+	router.GET("/admin/usage.csv", checkAdminToken(false), sendUsageCSV)
+	router.POST("/admin/login", adminLogin)
+	get := func(url string, header string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", url, nil)
+		if len(header) > 0 {
+			req.Header.Set("Authorization", header)
+		}
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+	login := func(token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/admin/login",
+			strings.NewReader(url.Values{"token": {token}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		router.ServeHTTP(w, req)
+		return w
+	}
+	if w := get("/admin/usage", "Bearer secret"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without a recorder, got %d", w.Code)
+	}
+
+	usageRecorder, _ = common.NewUsageRecorder(common.UsageData{Dir: t.TempDir()})
+	usageRecorder.RecordRequest("sws", common.Set{"X55": true}, 1)
+	if w := get("/admin/usage", "Bearer wrong"); w.Code != http.StatusUnauthorized ||
+		!strings.Contains(w.Body.String(), `action="/admin/login"`) {
+		t.Errorf("Expected 401 and the sign in form for a wrong token, got %d", w.Code)
+	}
+	// The token isn't taken from the URL
+	if w := get("/admin/usage.csv?token=secret", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a token in the URL, got %d", w.Code)
+	}
+	w := get("/admin/usage?days=7", "Bearer secret")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<td>X55</td>") ||
+		!strings.Contains(w.Body.String(), `href="/admin/usage.csv?days=7"`) {
+		t.Errorf("Expected the usage page, got %d %s", w.Code, w.Body.String())
+	}
+	w = get("/admin/usage.csv", "Bearer secret")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/csv; charset=utf-8" ||
+		!strings.Contains(w.Body.String(), ",sws,device,X55,1\n") {
+		t.Errorf("Expected the usage CSV, got %d %s", w.Code, w.Body.String())
+	}
+
+	// Signing in keeps a session cookie for the page and CSV
+	if w := login("wrong"); w.Code != http.StatusUnauthorized ||
+		len(w.Result().Cookies()) != 0 {
+		t.Errorf("Expected 401 without a session, got %d", w.Code)
+	}
+	w = login("secret")
+	cookies := w.Result().Cookies()
+	if w.Code != http.StatusSeeOther || len(cookies) != 1 ||
+		!cookies[0].HttpOnly || !cookies[0].Secure ||
+		strings.Contains(cookies[0].Value, "secret") {
+		t.Fatalf("Expected a session cookie, got %d %v", w.Code, cookies)
+	}
+	if w := get("/admin/usage.csv", "", cookies[0]); w.Code != http.StatusOK {
+		t.Errorf("Expected the usage CSV with a session, got %d", w.Code)
+	}
+	forged := &http.Cookie{Name: adminCookie, Value: "forged"}
+	if w := get("/admin/usage.csv", "", forged); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a forged session, got %d", w.Code)
+	}
+}
+
+func TestProbes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	wd, _ := os.Getwd()
+	t.Chdir(filepath.Dir(wd))
+	config = nil
+	router := gin.New()
+	router.GET("/readyz", sendReadiness)
+	router.GET("/version", sendVersion)
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w
+	}
+
+	// Not ready until the config is loaded
+	if w := get("/readyz"); w.Code != http.StatusServiceUnavailable ||
+		!strings.Contains(w.Body.String(), "the config isn't loaded") {
+		t.Errorf("Expected 503 before loading the config, got %d %s", w.Code, w.Body.String())
+	}
+	loadConfig(common.NewLog())
+	w := get("/readyz")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"Ready":true`) {
+		t.Errorf("Expected the project ready, got %d %s", w.Code, w.Body.String())
+	}
+
+	var version common.VersionInfo
+	w = get("/version")
+	if err := json.Unmarshal(w.Body.Bytes(), &version); err != nil {
+		t.Fatal(err)
+	}
+	if version.AppName != config.AppName || version.Version != config.Version ||
+		len(version.Commit) == 0 || strings.Join(version.Games, ",") != "fs2020,sws" ||
+		version.Devices != len(config.Devices.Index) || version.Devices == 0 {
+		t.Errorf("Unexpected version %+v", version)
+	}
+}
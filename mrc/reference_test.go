@@ -87,16 +87,16 @@ func TestReferenceFiles(t *testing.T) {
 				files := [][]byte{content}
 				
 				// 1. Handle Request
-				gameData, gameBinds, gameDevices, gameContexts, gameLogo := handler(files, cfg, log)
+				gameData, gameBinds, gameAxes, gameDevices, gameContexts, gameLogo := handler(files, cfg, log)
 				
 				// 2. Populate Overlays
-				overlaysByImage := common.PopulateImageOverlays(gameDevices, cfg, log, gameBinds, gameData, matchFunc)
+				overlaysByImage, hidden := common.PopulateImageOverlays(gameDevices, cfg, log, gameBinds, gameAxes, gameData, matchFunc, nil)
 				
 				// 3. Generate Images
-				generatedImages, _ := common.GenerateImages(overlaysByImage, gameContexts, gameLogo, cfg, log)
+				generatedImages, thumbnails, _, _ := common.GenerateImages(overlaysByImage, hidden, gameContexts, gameLogo, cfg, log, nil)
 				
 				// 4. Generate HTML
-				htmlOutput := generateHTML(t, generatedImages, projectRoot)
+				htmlOutput := generateHTML(t, generatedImages, thumbnails, projectRoot)
 				
 				referenceFilename := fmt.Sprintf("%s_%s.html", label, d.Name())
 				referencePath := filepath.Join(referenceDir, referenceFilename)
@@ -154,7 +154,7 @@ func compareHTMLImages(t *testing.T, gotHTML, wantHTML []byte, cfg *common.Confi
 
 func extractImages(t *testing.T, htmlBytes []byte) [][]byte {
 	// Pattern to capture the base64 content
-	ptn := regexp.MustCompile(`data:image/jpg;base64,([^"]+)`)
+	ptn := regexp.MustCompile(`data:image/jpe?g;base64,([^"]+)`)
 	matches := ptn.FindAllSubmatch(htmlBytes, -1)
 	var images [][]byte
 	for _, m := range matches {
@@ -287,7 +287,7 @@ func ensureRGBA(img image.Image) *image.RGBA {
 	return dst
 }
 
-func generateHTML(t *testing.T, generatedFiles []bytes.Buffer, projectRoot string) []byte {
+func generateHTML(t *testing.T, generatedFiles []bytes.Buffer, thumbnails []bytes.Buffer, projectRoot string) []byte {
 	// Replicating logic from sendResponse for HTML generation
 	// Use relative path from project root
 	cardTempl := "resources/www/templates/refcard.html"
@@ -297,14 +297,20 @@ func generateHTML(t *testing.T, generatedFiles []bytes.Buffer, projectRoot strin
 	}
 
 	type base64Image struct {
-		Base64Contents string
+		Base64Contents  string
+		Base64Thumbnail string
+		ContentsURL     template.URL
+		ThumbnailURL    template.URL
 	}
 
 	var fullOutput bytes.Buffer
 
-	for _, file := range generatedFiles {
+	for idx, file := range generatedFiles {
 		image := base64Image{
-			Base64Contents: base64.StdEncoding.EncodeToString(file.Bytes()),
+			Base64Contents:  base64.StdEncoding.EncodeToString(file.Bytes()),
+			Base64Thumbnail: base64.StdEncoding.EncodeToString(thumbnails[idx].Bytes()),
+			ContentsURL:     template.URL(common.CardURL(nil, file.Bytes(), nil)),
+			ThumbnailURL:    template.URL(common.CardURL(nil, thumbnails[idx].Bytes(), nil)),
 		}
 		if err := tmpl.Execute(&fullOutput, image); err != nil {
 			t.Fatalf("Failed to execute template: %v", err)
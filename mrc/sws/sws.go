@@ -46,33 +46,37 @@ func GetGameInfo() (string, string, common.FuncRequestHandler, common.FuncMatchG
 
 // handleRequest services the request to load files
 func handleRequest(files [][]byte, cfg *common.Config, log *common.Logger) (common.GameData,
-	common.GameBindsByProfile, common.Set, common.ContextToColours, string) {
+	common.GameBindsByProfile, common.GameAxesByProfile, common.Set, common.ContextToColours,
+	string) {
 	firstInit.Do(func() {
-		sharedGameData = common.LoadGameModel("config/sws.yaml", "StarWarsSquadrons Data",
-			cfg.DebugOutput, log)
+		sharedGameData = common.LoadGameModel(common.GameConfigFile(label),
+			"StarWarsSquadrons Data", cfg.DebugOutput, log)
 		sharedRegexes.Bind = regexp.MustCompile(sharedGameData.Regexes["Bind"])
 		sharedRegexes.Joystick = regexp.MustCompile(sharedGameData.Regexes["Joystick"])
 	})
 
-	gameBinds, gameDevices, gameContexts := loadInputFiles(files, cfg.Devices.DeviceToShortNameMap,
-		log, cfg.DebugOutput, cfg.VerboseOutput)
-	common.GenerateContextColours(gameContexts, cfg)
-	return sharedGameData, gameBinds, gameDevices, gameContexts, sharedGameData.Logo
+	gameBinds, gameAxes, gameDevices, gameContexts := loadInputFiles(files,
+		cfg.Devices.DeviceToShortNameMap, log, cfg.DebugOutput, cfg.VerboseOutput)
+	common.GenerateContextColours(gameContexts, sharedGameData.ContextColours, cfg)
+	return sharedGameData, gameBinds, gameAxes, gameDevices, gameContexts, sharedGameData.Logo
 }
 
 // Load the game config files (provided by user)
 func loadInputFiles(files [][]byte, deviceNameMap common.DeviceNameFullToShort,
-	log *common.Logger, bool, verboseOutput bool) (common.GameBindsByProfile, common.Set,
-	common.ContextToColours) {
+	log *common.Logger, bool, verboseOutput bool) (common.GameBindsByProfile,
+	common.GameAxesByProfile, common.Set, common.ContextToColours) {
 	gameBindsByProfile := make(common.GameBindsByProfile)
 	gameBinds := make(common.GameDeviceContextActions)
 	gameBindsByProfile[common.ProfileDefault] = gameBinds
+	gameAxes := make(common.GameAxesByProfile)
 	deviceNames := make(common.Set)
 	contexts := make(common.ContextToColours)
 
 	// deviceIndex: deviceId -> full name
 	deviceIndex := make(map[string]string)
 	contextActionIndex := make(swsContextActionIndex)
+	// Action -> sensitivity and deadzone of the joystick axes
+	axisCurves := make(map[string]common.AxisSettings)
 
 	// Load all the device and inputs
 	for idx, file := range files {
@@ -85,7 +89,7 @@ func loadInputFiles(files [][]byte, deviceNameMap common.DeviceNameFullToShort,
 				if matches != nil {
 					override, err := strconv.Atoi(matches[3])
 					if err != nil {
-						log.Err("SWS Device num not an integer %s", matches[3])
+						log.ErrAs(common.ErrorParse, "SWS Device num not an integer %s", matches[3])
 						continue
 					}
 					addAction(contextActionIndex, matches[1], contexts, matches[2],
@@ -96,7 +100,9 @@ func loadInputFiles(files [][]byte, deviceNameMap common.DeviceNameFullToShort,
 				matches2 := sharedRegexes.Joystick.FindStringSubmatch(line)
 				if matches2 != nil && len(matches2[2]) > 0 {
 					if shortName, found := deviceNameMap[matches2[2]]; !found {
-						log.Err("SWS Unknown device found %s", matches2[2])
+						log.ErrAs(common.ErrorUnsupportedDevice, "SWS Unknown device found %s", matches2[2])
+						common.RecordUnsupportedDevice(label, matches2[2])
+						log.UnsupportedDevice(matches2[2])
 						continue
 					} else {
 						num, err := strconv.Atoi(matches2[1])
@@ -106,15 +112,17 @@ func loadInputFiles(files [][]byte, deviceNameMap common.DeviceNameFullToShort,
 							deviceIndex[strconv.Itoa(num)] = shortName
 							deviceNames[shortName] = true
 						} else {
-							log.Err("SWS unexpected device number %s", matches2[1])
+							log.ErrAs(common.ErrorParse, "SWS unexpected device number %s", matches2[1])
 						}
 					}
 				}
+			} else if matches := axisCurveRegex.FindStringSubmatch(line); matches != nil {
+				addAxisCurve(axisCurves, matches[1], matches[2], matches[3])
 			}
 		}
 
 		if err := scanner.Err(); err != nil {
-			log.Err("SWS scan file %d. %s", idx, err)
+			log.ErrAs(common.ErrorParse, "SWS scan file %d. %s", idx, err)
 		}
 	}
 
@@ -130,6 +138,8 @@ func loadInputFiles(files [][]byte, deviceNameMap common.DeviceNameFullToShort,
 			}
 			sort.Ints(overrides)
 
+			// Axis halves bound to the action, by device
+			axisHalves := make(map[string]*swsAxisHalves)
 			// Don't need to use the override index
 			for _, override := range overrides {
 				actionSubMap := overrideActionSubMap[override]
@@ -142,7 +152,7 @@ func loadInputFiles(files [][]byte, deviceNameMap common.DeviceNameFullToShort,
 				for actionSub, value := range actionSubMap {
 					field, err := getInputTypeAsField(actionSub, &actionDetails)
 					if err != nil {
-						log.Err("%s value %s", err, value)
+						log.ErrAs(common.ErrorParse, "%s value %s", err, value)
 					} else if field != nil { // Ignore nil fields, this info isn't needed
 						*field = value
 					}
@@ -167,9 +177,17 @@ func loadInputFiles(files [][]byte, deviceNameMap common.DeviceNameFullToShort,
 				// Assign action details accordingly
 				input, err := interpretInput(&actionDetails, shortName, context, action, log)
 				if err != nil {
-					log.Err("%s", err)
+					log.ErrAs(common.ErrorUnknownInput, "%s", err)
 					continue
 				}
+				if positive, isAxis := axisHalf(&actionDetails); isAxis && len(input) > 0 {
+					halves, found := axisHalves[shortName]
+					if !found {
+						halves = &swsAxisHalves{}
+						axisHalves[shortName] = halves
+					}
+					halves.add(positive, actionDetails.Negate == "1")
+				}
 				if len(input) > 0 {
 					gameAction, found := actions[action]
 					if !found {
@@ -187,10 +205,85 @@ func loadInputFiles(files [][]byte, deviceNameMap common.DeviceNameFullToShort,
 					delete(actions, action)
 				}
 			}
+			for shortName, halves := range axisHalves {
+				settings := axisCurves[action]
+				settings.Direction, settings.Inverted = halves.direction()
+				gameAxes.Set(common.ProfileDefault, shortName, context, action, settings)
+			}
+		}
+	}
+
+	return gameBindsByProfile, gameAxes, deviceNames, contexts
+}
+
+// addAxisCurve stores a joystick axis setting e.g. GstInput.JoystickPitchSensitivity
+// for the action of the same name. Defaults are left empty.
+func addAxisCurve(axisCurves map[string]common.AxisSettings, action string,
+	setting string, value string) {
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return
+	}
+	settings := axisCurves[action]
+	switch setting {
+	case "Sensitivity":
+		if number != defaultSensitivity {
+			settings.Sensitivity = strconv.FormatFloat(number, 'f', -1, 64)
+		}
+	case "Deadzone":
+		if number != 0 {
+			settings.Deadzone = strconv.FormatFloat(number, 'f', -1, 64)
+		}
+	}
+	axisCurves[action] = settings
+}
+
+// axisHalf returns whether the input is the positive half of an axis and
+// true if the input is half an axis. Like interpretInput, reverse engineered
+// for the X-55. Axes 8 and 9, and even buttons from 40, are the positive halves.
+func axisHalf(details *swsActionDetails) (bool, bool) {
+	switch details.Axis {
+	case "8", "9":
+		return true, true
+	case "10", "11":
+		return false, true
+	case "26":
+		button, err := strconv.Atoi(details.Button)
+		if err != nil || button < 40 || button > 47 {
+			return false, false
 		}
+		return button%2 == 0, true
+	}
+	return false, false
+}
+
+// swsAxisHalves are the halves of an axis bound to an action
+type swsAxisHalves struct {
+	positive, negative, inverted bool
+}
+
+// add records a bound half. Halves normally negate the negative half so it
+// adds to the positive half. Anything else inverts the axis.
+func (h *swsAxisHalves) add(positive bool, negate bool) {
+	if positive {
+		h.positive = true
+	} else {
+		h.negative = true
 	}
+	if positive == negate {
+		h.inverted = true
+	}
+}
 
-	return gameBindsByProfile, deviceNames, contexts
+// direction returns the direction of the bound halves and if they're inverted
+func (h *swsAxisHalves) direction() (string, bool) {
+	switch {
+	case h.positive && !h.negative:
+		return common.AxisPositive, h.inverted
+	case h.negative && !h.positive:
+		return common.AxisNegative, h.inverted
+	}
+	return "", h.inverted
 }
 
 func addAction(contextActionIndex swsContextActionIndex, context string,
@@ -228,7 +321,9 @@ func getInputTypeAsField(actionSub string, currAction *swsActionDetails) (*strin
 		return &currAction.Button, nil
 	case "deviceid":
 		return &currAction.DeviceID, nil
-	case "altbutton", "identifier", "modifier", "negate", "type":
+	case "negate":
+		return &currAction.Negate, nil
+	case "altbutton", "identifier", "modifier", "type":
 		// Don't need to store these but they aren't an error
 		return nil, nil
 	}
@@ -324,6 +419,12 @@ func matchGameInputToModel(deviceName string, gameInput common.GameInput,
 // swsContextActionIndex: context -> action name -> override -> action sub -> value
 type swsContextActionIndex map[string]map[string]map[int]map[string]string
 
+// axisCurveRegex matches the curve settings of the joystick axes
+var axisCurveRegex = regexp.MustCompile(`^GstInput\.Joystick(Pitch|Roll|Yaw|Throttle)(Sensitivity|Deadzone)\s+(\S+)$`)
+
+// Joystick sensitivity when not changed in the game's settings
+const defaultSensitivity = 0.5
+
 type swsRegexes struct {
 	Bind     *regexp.Regexp
 	Joystick *regexp.Regexp
@@ -337,6 +438,6 @@ type swsActionDetails struct {
 	DeviceID string
 	// Unused  Identifier string
 	// Unused  Modifier   string
-	// Unused  Negate     string
+	Negate string
 	// Unused  Type       string
 }
@@ -40,7 +40,7 @@ func TestLoadInputFiles(t *testing.T) {
 	files := [][]byte{fileContent}
 
 	// Mocking config flags
-	gameBinds, deviceNames, contexts := loadInputFiles(files, deviceMap, log, true, true)
+	gameBinds, _, deviceNames, contexts := loadInputFiles(files, deviceMap, log, true, true)
 
 	if len(gameBinds) == 0 {
 		t.Error("Expected game binds to be populated")
@@ -195,6 +195,11 @@ func TestLoadInputFiles_Errors(t *testing.T) {
 	if !found {
 		t.Error("Expected error for unknown device")
 	}
+	// Noted for the usage statistics
+	if devices := log.UnsupportedDevices(); len(devices) != 1 ||
+		devices[0] != "UnknownDevice" {
+		t.Errorf("Expected the unknown device in the log, got %v", devices)
+	}
 
 	
 	// Input type field error
@@ -226,7 +231,7 @@ func TestLoadInputFiles_CorruptData(t *testing.T) {
 	files := [][]byte{corruptFile}
 
 	// Should not panic, just ignore
-	gameBinds, _, _ := loadInputFiles(files, deviceMap, log, true, true)
+	gameBinds, _, _, _ := loadInputFiles(files, deviceMap, log, true, true)
 	
 	if len(gameBinds[common.ProfileDefault]) > 0 {
 		t.Errorf("Expected empty gameBinds for corrupt data, got %v", gameBinds)
@@ -251,7 +256,7 @@ func TestLoadInputFiles_ErroneousData(t *testing.T) {
 	// loadInputFiles should see "Unknown Joystick", fail to map it in deviceMap, and log error/skip it.
 	// Subsequently, binds referring to deviceid 0 (which maps to joystick 1 -> Unknown) should be skipped.
 
-	gameBinds, _, _ := loadInputFiles(files, deviceMap, log, true, true)
+	gameBinds, _, _, _ := loadInputFiles(files, deviceMap, log, true, true)
 
 	if len(gameBinds[common.ProfileDefault]) != 0 {
 		// Because device 1 was unknown, it shouldn't be in the index, 
@@ -360,7 +365,7 @@ func TestHandleRequest(t *testing.T) {
 	files := [][]byte{}
 	
 	// Call
-	gData, _, _, _, logo := handleRequest(files, config, log)
+	gData, _, _, _, _, logo := handleRequest(files, config, log)
 	
 	if gData.Logo == "" {
 		t.Error("GameData Logo empty")
@@ -390,7 +395,7 @@ func TestLoadInputFiles_UnexpectedDeviceNumber(t *testing.T) {
 
 	files := [][]byte{fileData}
 
-	_, devices, _ := loadInputFiles(files, deviceMap, log, false, false)
+	_, _, devices, _ := loadInputFiles(files, deviceMap, log, false, false)
 
 	// Device should NOT be added because num-1 = -1 which is >= 0 check fails
 	if devices["ValidDevice"] {
@@ -617,3 +622,95 @@ GstKeyBinding.IncomDefaultInputConcepts.ConceptTest.0.deviceid 0`)
 		t.Error("Expected error from interpretInput")
 	}
 }
+
+func TestLoadInputFiles_Axes(t *testing.T) {
+	log := common.NewLog()
+	wd, _ := os.Getwd()
+	configPath := filepath.Join(wd, "../../config/sws.yaml")
+	sharedGameData = common.LoadGameModel(configPath, "SWS Data", false, log)
+	sharedRegexes = swsRegexes{
+		Bind:     regexp.MustCompile(sharedGameData.Regexes["Bind"]),
+		Joystick: regexp.MustCompile(sharedGameData.Regexes["Joystick"]),
+	}
+	deviceMap := common.DeviceNameFullToShort{
+		"Saitek Pro Flight X-55 Rhino Stick":    "SaitekX55Joystick",
+		"Saitek Pro Flight X-55 Rhino Throttle": "SaitekX55Throttle",
+	}
+	fileContent, err := os.ReadFile("../../testdata/sws/Saitek_Pro_Flight_X-55_Rhino.profile")
+	if err != nil {
+		t.Fatalf("Failed to read test data file: %v", err)
+	}
+
+	gameBinds, gameAxes, _, _ := loadInputFiles([][]byte{fileContent}, deviceMap, log,
+		false, false)
+
+	for _, test := range []struct {
+		device   string
+		context  string
+		action   string
+		expected common.AxisSettings
+	}{
+		// Pitch and yaw sensitivity changed from the default, roll's isn't
+		{"SaitekX55Joystick", "Starship", "Pitch", common.AxisSettings{Sensitivity: "0.7"}},
+		{"SaitekX55Joystick", "Starship", "Yaw", common.AxisSettings{Sensitivity: "0.7"}},
+		{"SaitekX55Joystick", "Starship", "Roll", common.AxisSettings{}},
+		// Throttle negates the positive half
+		{"SaitekX55Throttle", "Starship", "Throttle", common.AxisSettings{Inverted: true}},
+		{"SaitekX55Joystick", "Soldier", "CameraPitch", common.AxisSettings{}},
+	} {
+		settings := gameAxes.Lookup(common.ProfileDefault, test.device, test.context,
+			test.action)
+		if settings == nil || *settings != test.expected {
+			t.Errorf("%s expected %+v, got %+v", test.action, test.expected, settings)
+		}
+	}
+	// Buttons aren't axes
+	for action := range gameBinds[common.ProfileDefault]["SaitekX55Joystick"]["Starship"] {
+		if strings.HasPrefix(action, "Target") &&
+			gameAxes.Lookup(common.ProfileDefault, "SaitekX55Joystick", "Starship",
+				action) != nil {
+			t.Errorf("Expected no axis settings for %s", action)
+		}
+	}
+}
+
+func TestAxisHalves(t *testing.T) {
+	for _, test := range []struct {
+		halves    [][2]bool // positive, negate
+		direction string
+		inverted  bool
+	}{
+		{[][2]bool{{true, false}, {false, true}}, "", false},
+		{[][2]bool{{true, true}, {false, false}}, "", true},
+		{[][2]bool{{true, false}}, common.AxisPositive, false},
+		{[][2]bool{{false, false}}, common.AxisNegative, true},
+	} {
+		halves := &swsAxisHalves{}
+		for _, half := range test.halves {
+			halves.add(half[0], half[1])
+		}
+		direction, inverted := halves.direction()
+		if direction != test.direction || inverted != test.inverted {
+			t.Errorf("%v expected %s %v, got %s %v", test.halves, test.direction,
+				test.inverted, direction, inverted)
+		}
+	}
+	for _, test := range []struct {
+		details  swsActionDetails
+		positive bool
+		isAxis   bool
+	}{
+		{swsActionDetails{Axis: "9"}, true, true},
+		{swsActionDetails{Axis: "11"}, false, true},
+		{swsActionDetails{Axis: "26", Button: "46"}, true, true},
+		{swsActionDetails{Axis: "26", Button: "47"}, false, true},
+		{swsActionDetails{Axis: "26", Button: "48"}, false, false},
+		{swsActionDetails{Axis: "26", Button: "x"}, false, false},
+	} {
+		positive, isAxis := axisHalf(&test.details)
+		if positive != test.positive || isAxis != test.isAxis {
+			t.Errorf("%+v expected %v %v, got %v %v", test.details, test.positive,
+				test.isAxis, positive, isAxis)
+		}
+	}
+}
@@ -38,11 +38,14 @@ function registerHandlers(game) {
   let inputFile = $('#' + game + 'FilesInput');
   let addButton = $('#' + game + 'AddButton');
   let generateButton = $('#' + game + 'GenerateButton');
+  let downloadButton = $('#' + game + 'DownloadButton');
+  let shareButton = $('#' + game + 'ShareButton');
   let filesContainer = $('#' + game + 'Files');
   let navItem = $('#' + game + 'Nav')[0]
   let files = []
   inputFile.change(function () {
-    inputFileChange(generateButton, inputFile, files, filesContainer);
+    inputFileChange(generateButton.add(downloadButton).add(shareButton), inputFile, files,
+      filesContainer);
     $(this).val('') // Makes it possible to add, remove, add same file
   });
   addButton.click(function () {
@@ -51,9 +54,24 @@ function registerHandlers(game) {
   generateButton.click(function () {
     callBackend('/api/' + game,
       files,
+      $('#' + game + ' .mrc-option'),
       $('#' + game + 'Progressbar'),
       $('#' + game + 'Images'))
   });
+  downloadButton.click(function () {
+    downloadZip('/api/' + game + '/zip',
+      files,
+      $('#' + game + ' .mrc-option'),
+      $('#' + game + 'Progressbar'),
+      'metarefcard-' + game + '.zip')
+  });
+  shareButton.click(function () {
+    shareCards('/api/' + game + '/share',
+      files,
+      $('#' + game + ' .mrc-option'),
+      $('#' + game + 'Progressbar'),
+      $('#' + game + 'Share'))
+  });
   navItem.className += ' active'
 }
 
@@ -87,11 +105,27 @@ function inputFileChange(generateButton, inputFile, files, filesContainer) {
   });
 }
 
-function callBackend(url, files, progressbar, imageContainer) {
+// Returns the form data for the files and request options. Request options
+// are inputs tagged with the mrc-option class.
+function requestFormData(files, options) {
   let formData = new FormData();
   files.forEach(file => {
     formData.append('file', file);
   });
+  options.each(function () {
+    let value = $(this).val();
+    if (this.type === 'checkbox') {
+      value = this.checked ? 'true' : '';
+    }
+    if (value) {
+      formData.append(this.name, value);
+    }
+  });
+  return formData;
+}
+
+function callBackend(url, files, options, progressbar, imageContainer) {
+  let formData = requestFormData(files, options);
 
   imageContainer.empty();
   progressbar.show();
@@ -101,10 +135,11 @@ function callBackend(url, files, progressbar, imageContainer) {
     type: 'POST',
     success: function (data) {
       progressbar.hide();
-      imageContainer.html(data);
+      showCards(imageContainer, data);
     },
     error: function (data) {
       progressbar.hide();
+      showError(imageContainer, data.responseText);
       console.log('ERROR !!!');
     },
     cache: false,
@@ -112,3 +147,120 @@ function callBackend(url, files, progressbar, imageContainer) {
     contentType: false
   });
 }
+
+// Shows why a request was rejected e.g. the upload was too large
+function showError(container, message) {
+  if (message) {
+    container.append($('<div class="alert alert-warning mt-3">').text(message));
+  }
+}
+
+// Shows the cards' HTML in the container
+function showCards(imageContainer, data) {
+  imageContainer.html(data);
+  // Cards show a thumbnail, open the full resolution image on click
+  imageContainer.find('a.mrc-card').click(function () {
+    let img = $('<img>').attr('src', $(this).data('full'));
+    window.open().document.body.innerHTML = img.prop('outerHTML');
+  });
+  imageContainer.find('.mrc-chip').click(function () {
+    filterContext(imageContainer, String($(this).data('context')));
+  });
+}
+
+// Loads the cards of a shared card
+function loadSharedCard(id, progressbar, imageContainer) {
+  progressbar.show();
+  $.ajax({
+    url: '/api/card/' + encodeURIComponent(id),
+    type: 'GET',
+    success: function (data) {
+      progressbar.hide();
+      showCards(imageContainer, data);
+    },
+    error: function (data) {
+      progressbar.hide();
+      console.log('ERROR !!!');
+    }
+  });
+}
+
+// Saves the bindings and options for a shareable link and shows the link with
+// a button to delete it. The delete token is only shown once.
+function shareCards(url, files, options, progressbar, shareContainer) {
+  shareContainer.empty().hide();
+  progressbar.show();
+  fetch(url, {
+    method: 'POST',
+    headers: { 'Accept': 'application/json' },
+    body: requestFormData(files, options)
+  })
+    .then(response => response.json())
+    .then(share => {
+      progressbar.hide();
+      if (share.Error) {
+        shareContainer.append($('<div class="alert alert-warning">').text(share.Error)).show();
+        return;
+      }
+      let link = location.origin + share.URL;
+      let deleteButton = $('<button type="button" class="btn btn-sm btn-outline-danger">Delete</button>');
+      deleteButton.click(function () {
+        fetch('/api/card/' + share.ID, {
+          method: 'DELETE',
+          headers: { 'Authorization': 'Bearer ' + share.DeleteToken }
+        })
+          .then(response => {
+            shareContainer.empty().text(response.ok ? 'Deleted ' + link : 'Delete failed');
+          });
+      });
+      shareContainer
+        .append($('<div>').append('Share link: ', $('<a target="_blank">').attr('href', link).text(link)))
+        .append($('<div>').text('Delete token (keep this to delete the link later): ' + share.DeleteToken))
+        .append(share.Expires ? $('<div>').text('Expires ' + new Date(share.Expires).toLocaleDateString()) : '')
+        .append(deleteButton)
+        .show();
+    })
+    .catch(error => {
+      progressbar.hide();
+      console.log('ERROR !!! ' + error);
+    });
+}
+
+// Downloads the ZIP of the cards, bindings and log
+function downloadZip(url, files, options, progressbar, filename) {
+  progressbar.show();
+  fetch(url, { method: 'POST', body: requestFormData(files, options) })
+    .then(response => {
+      if (!response.ok) {
+        return response.text().then(message => { throw new Error(message || response.statusText); });
+      }
+      return response.blob();
+    })
+    .then(blob => {
+      progressbar.hide();
+      let link = document.createElement('a');
+      link.href = URL.createObjectURL(blob);
+      link.download = filename;
+      link.click();
+      // Revoking straight away can cancel the download before it starts
+      setTimeout(() => URL.revokeObjectURL(link.href), 60000);
+    })
+    .catch(error => {
+      progressbar.hide();
+      alert(error.message);
+      console.log('ERROR !!! ' + error);
+    });
+}
+
+// Dims the chips of interactive cards that aren't in the context. Filtering
+// on the same context again shows all chips.
+function filterContext(imageContainer, context) {
+  if (imageContainer.data('context') === context) {
+    context = '';
+  }
+  imageContainer.data('context', context);
+  imageContainer.find('.mrc-chip').each(function () {
+    let chipContext = String($(this).data('context'));
+    $(this).toggleClass('mrc-dimmed', context !== '' && chipContext !== context);
+  });
+}